@@ -0,0 +1,112 @@
+// Package allocation decides which warehouses fulfil an order line. The
+// strategy is picked with ALLOCATION_STRATEGY:
+//
+//	priority       warehouses with the lowest priority number first (default)
+//	highest_stock  warehouses with the most available stock first
+//	nearest        warehouses closest to the shipping address first, falling
+//	               back to priority when the order has no address
+package allocation
+
+import (
+	"golang-final-project/models"
+	"math"
+	"os"
+	"sort"
+)
+
+const (
+	StrategyPriority     = "priority"
+	StrategyHighestStock = "highest_stock"
+	StrategyNearest      = "nearest"
+)
+
+type Location struct {
+	WarehouseId int
+	Available   int
+	Priority    int
+	Point       *models.GeoPoint
+}
+
+type Allocation struct {
+	WarehouseId int
+	Quantity    int
+}
+
+func Strategy() string {
+	switch strategy := os.Getenv("ALLOCATION_STRATEGY"); strategy {
+	case StrategyHighestStock, StrategyNearest:
+		return strategy
+	default:
+		return StrategyPriority
+	}
+}
+
+// Allocate takes quantity from the locations in the order the strategy
+// prefers them, emptying each before moving to the next, so a line is only
+// split when no single preferred location can cover it. It returns nil when
+// the locations together don't have enough.
+func Allocate(strategy string, quantity int, locations []Location, shipTo *models.GeoPoint) []Allocation {
+	ordered := make([]Location, len(locations))
+	copy(ordered, locations)
+
+	if strategy == StrategyNearest && shipTo == nil {
+		strategy = StrategyPriority
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+
+		switch strategy {
+		case StrategyHighestStock:
+			if a.Available != b.Available {
+				return a.Available > b.Available
+			}
+		case StrategyNearest:
+			if (a.Point == nil) != (b.Point == nil) {
+				return a.Point != nil
+			} else if a.Point != nil {
+				da, db := distance(*a.Point, *shipTo), distance(*b.Point, *shipTo)
+				if da != db {
+					return da < db
+				}
+			}
+		}
+
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.WarehouseId < b.WarehouseId
+	})
+
+	allocations := []Allocation{}
+	remaining := quantity
+
+	for _, location := range ordered {
+		if remaining == 0 {
+			break
+		} else if location.Available <= 0 {
+			continue
+		}
+
+		take := min(location.Available, remaining)
+		allocations = append(allocations, Allocation{WarehouseId: location.WarehouseId, Quantity: take})
+		remaining -= take
+	}
+
+	if remaining > 0 {
+		return nil
+	}
+	return allocations
+}
+
+// distance is the great-circle distance between a and b in kilometres.
+func distance(a models.GeoPoint, b models.GeoPoint) float64 {
+	const earthRadius = 6371.0
+
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
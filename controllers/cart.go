@@ -2,9 +2,10 @@ package controllers
 
 import (
 	"database/sql"
-	"fmt"
+	"errors"
 	"golang-final-project/middleware"
 	"golang-final-project/models"
+	"golang-final-project/policy"
 	"golang-final-project/repository"
 	"golang-final-project/utils"
 	"net/http"
@@ -17,60 +18,73 @@ import (
 func PostCart(ctx *gin.Context) {
 	var postCartBody models.PostCartBody
 
-	userId, _, accessTokenValidation := middleware.ValidateAccessToken(ctx)
+	userId := middleware.CurrentPrincipal(ctx).UserId
 
-	if accessTokenValidation != "" {
+	if err := ctx.ShouldBindJSON(&postCartBody); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error": err.Error(),
+		})
+	} else if len(postCartBody.Items) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Cart must contain at least one item",
+		})
+	} else if !validQuantities(postCartBody.Items) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Quantity must be greater than zero",
 		})
 	} else {
-		if err := ctx.ShouldBindJSON(&postCartBody); err != nil {
+		cartId := utils.IDGenerator()
+		createdAt := time.Now()
+
+		for i := range postCartBody.Items {
+			postCartBody.Items[i].Id = utils.IDGenerator()
+			postCartBody.Items[i].CartId = cartId
+		}
+
+		postCartBody.Id = cartId
+		postCartBody.UserId = userId
+		postCartBody.CreatedAt = createdAt
+
+		cart, err := repository.ReplaceCart(postCartBody)
+
+		if errors.Is(err, repository.ErrItemNotFound) || errors.Is(err, repository.ErrInsufficientStock) || cartVariantError(err) {
 			ctx.JSON(http.StatusBadRequest, gin.H{
 				"error": err.Error(),
 			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
 		} else {
-			cartId := utils.IDGenerator()
-			userIdInt, _ := strconv.Atoi(userId)
-			createdAt := time.Now()
-
-			for i := range postCartBody.Items {
-				postCartBody.Items[i].Id = utils.IDGenerator()
-				postCartBody.Items[i].CartId = cartId
-			}
-
-			postCartBody.Id = cartId
-			postCartBody.UserId = userIdInt
-			postCartBody.CreatedAt = createdAt
-			postCartBody.PaymentStatus = "Pending"
-
-			repository.CreateCart(postCartBody)
-			ctx.JSON(http.StatusCreated, gin.H{
-				"message": "cart added",
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "cart saved",
+				"cart":    cart,
 			})
 		}
 	}
 }
 
+func validQuantities(items []models.CartItem) bool {
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func GetCarts(ctx *gin.Context) {
 	carts, err := repository.GetCarts()
 
-	_, _, accessTokenValidation := middleware.ValidateAccessToken(ctx)
-
-	if accessTokenValidation != "" {
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error": err.Error(),
 		})
+		return
 	} else {
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
-			return
-		} else {
-			ctx.JSON(http.StatusOK, gin.H{
-				"carts": carts,
-			})
-		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"carts": carts,
+		})
 	}
 }
 
@@ -78,33 +92,29 @@ func GetCartById(ctx *gin.Context) {
 	idParam := ctx.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 
-	_, _, accessTokenValidation := middleware.ValidateAccessToken(ctx)
-
-	if accessTokenValidation != "" {
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error": "Invalid ID",
 		})
 	} else {
+		cart, err := repository.GetCartById(id)
+		if err == nil && !policy.CanReadCart(middleware.CurrentPrincipal(ctx), cart.UserId) {
+			err = sql.ErrNoRows
+		}
+
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid ID",
-			})
-		} else {
-			cart, err := repository.GetCartById(id)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					ctx.JSON(http.StatusNotFound, gin.H{
-						"error": "Cart doesn't exist",
-					})
-				} else {
-					ctx.JSON(http.StatusInternalServerError, gin.H{
-						"error": err.Error(),
-					})
-				}
-				return
+			if err == sql.ErrNoRows {
+				ctx.JSON(http.StatusNotFound, gin.H{
+					"error": "Cart doesn't exist",
+				})
 			} else {
-				ctx.JSON(http.StatusOK, cart)
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
 			}
+			return
+		} else {
+			ctx.JSON(http.StatusOK, cart)
 		}
 	}
 }
@@ -113,201 +123,287 @@ func GetCartsByUserId(ctx *gin.Context) {
 	idParam := ctx.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 
-	_, _, accessTokenValidation := middleware.ValidateAccessToken(ctx)
-
-	if accessTokenValidation != "" {
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error": "Invalid ID",
+		})
+	} else if !policy.CanListUserCarts(middleware.CurrentPrincipal(ctx), int(id)) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": "You can only view your own carts",
 		})
 	} else {
+		carts, err := repository.GetCartsByUserId(id)
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid ID",
-			})
-		} else {
-			carts, err := repository.GetCartsByUserId(id)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					ctx.JSON(http.StatusNotFound, gin.H{
-						"error": "Cart doesn't exist",
-					})
-				} else {
-					ctx.JSON(http.StatusInternalServerError, gin.H{
-						"error": err.Error(),
-					})
-				}
-				return
+			if err == sql.ErrNoRows {
+				ctx.JSON(http.StatusNotFound, gin.H{
+					"error": "Cart doesn't exist",
+				})
 			} else {
-				ctx.JSON(http.StatusOK, gin.H{
-					"carts": carts,
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
 				})
 			}
+			return
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"carts": carts,
+			})
 		}
 	}
 }
 
-// func UpdateCart(ctx *gin.Context) {
-// 	idParam := ctx.Param("id")
-// 	id, err := strconv.ParseInt(idParam, 10, 64)
-
-// 	_, _, accessTokenValidation := middleware.ValidateAccessToken(ctx)
-
-// 	if accessTokenValidation != "" {
-// 		ctx.JSON(http.StatusBadRequest, gin.H{
-// 			"error": accessTokenValidation,
-// 		})
-// 	} else {
-// 		if err != nil {
-// 			ctx.JSON(http.StatusBadRequest, gin.H{
-// 				"error": "Invalid ID",
-// 			})
-// 			return
-// 		} else {
-// 			var input []models.CartItemUpdate
-
-// 			if err := ctx.ShouldBindJSON(&input); err != nil {
-// 				ctx.JSON(http.StatusBadRequest, gin.H{
-// 					"error":   "Invalid input",
-// 					"details": err.Error(),
-// 				})
-// 				return
-// 			} else {
-// 				err := repository.UpdateCart(id, input)
-
-// 				if err != nil {
-// 					fmt.Println(input)
-// 					ctx.JSON(http.StatusInternalServerError, gin.H{
-// 						"error":   "Failed to update cart",
-// 						"details": err.Error(),
-// 					})
-// 					return
-// 				} else {
-// 					ctx.JSON(http.StatusOK, gin.H{
-// 						"message": "Cart was successfully updated",
-// 					})
-// 				}
-// 			}
-// 		}
-// 	}
-// }
-
-// func DeleteCartItems(ctx *gin.Context) {
-// 	idParam := ctx.Param("id")
-// 	id, err := strconv.ParseInt(idParam, 10, 64)
-
-// 	_, _, accessTokenValidation := middleware.ValidateAccessToken(ctx)
-
-// 	if accessTokenValidation != "" {
-// 		ctx.JSON(http.StatusBadRequest, gin.H{
-// 			"error": accessTokenValidation,
-// 		})
-// 	} else {
-// 		if err != nil {
-// 			ctx.JSON(http.StatusBadRequest, gin.H{
-// 				"error": "Invalid ID",
-// 			})
-// 			return
-// 		} else {
-// 			var itemIds []int
-
-// 			err := repository.DeleteCartItems(id, itemIds)
-
-// 			if err != nil {
-// 				ctx.JSON(http.StatusInternalServerError, gin.H{
-// 					"error":  "Failed to delete cart item",
-// 					"detais": err.Error(),
-// 				})
-// 				return
-// 			} else {
-// 				ctx.JSON(http.StatusOK, gin.H{
-// 					"message": "Cart items have been deleted",
-// 				})
-// 			}
-// 		}
-// 	}
-// }
+func AddCartItem(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.CartItemUpdate
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if !cartWritable(ctx, id) {
+		return
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if input.Quantity <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Quantity must be greater than zero",
+		})
+	} else {
+		err := repository.AddCartItem(id, models.CartItem{
+			Id:        utils.IDGenerator(),
+			ItemId:    int(input.ItemId),
+			VariantId: int(input.VariantId),
+			Quantity:  input.Quantity,
+		})
+
+		respondCartItemChange(ctx, id, err, http.StatusCreated)
+	}
+}
+
+// UpdateCartItem changes the quantity of the item's line in the cart. The
+// variant_id query parameter picks the line when the item is in the cart
+// as several variants.
+func UpdateCartItem(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	itemId, itemErr := strconv.ParseInt(ctx.Param("item_id"), 10, 64)
+	variantId, variantErr := strconv.ParseInt(ctx.DefaultQuery("variant_id", "0"), 10, 64)
+
+	var input models.CartItemUpdate
+
+	if err != nil || itemErr != nil || variantErr != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if !cartWritable(ctx, id) {
+		return
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if input.Quantity <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Quantity must be greater than zero, remove the item instead",
+		})
+	} else {
+		err := repository.UpdateCartItemQuantity(id, itemId, variantId, input.Quantity)
+
+		respondCartItemChange(ctx, id, err, http.StatusOK)
+	}
+}
+
+func DeleteCartItem(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	itemId, itemErr := strconv.ParseInt(ctx.Param("item_id"), 10, 64)
+	variantId, variantErr := strconv.ParseInt(ctx.DefaultQuery("variant_id", "0"), 10, 64)
+
+	if err != nil || itemErr != nil || variantErr != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if !cartWritable(ctx, id) {
+		return
+	} else {
+		err := repository.RemoveCartItem(id, itemId, variantId)
+
+		respondCartItemChange(ctx, id, err, http.StatusOK)
+	}
+}
+
+// cartWritable responds with 404 and returns false when the cart doesn't
+// exist or belongs to somebody the caller may not act for.
+func cartWritable(ctx *gin.Context, id int64) bool {
+	ownerId, err := repository.GetCartOwner(id)
+
+	if err == sql.ErrNoRows || (err == nil && !policy.CanWriteCart(middleware.CurrentPrincipal(ctx), ownerId)) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Cart doesn't exist",
+		})
+		return false
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return false
+	} else {
+		return true
+	}
+}
+
+// cartVariantError reports whether err is about the variant a cart line
+// picked rather than the cart itself.
+func cartVariantError(err error) bool {
+	return errors.Is(err, repository.ErrVariantRequired) ||
+		errors.Is(err, repository.ErrVariantNotFound) ||
+		errors.Is(err, repository.ErrVariantInactive)
+}
+
+func respondCartItemChange(ctx *gin.Context, cartId int64, err error, status int) {
+	if errors.Is(err, repository.ErrVariantRequired) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	} else if errors.Is(err, repository.ErrItemNotFound) || errors.Is(err, repository.ErrCartItemNotFound) || errors.Is(err, repository.ErrVariantNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	} else if errors.Is(err, repository.ErrInsufficientStock) || errors.Is(err, repository.ErrVariantInactive) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to update cart",
+			"details": err.Error(),
+		})
+	} else if cart, err := repository.GetCartById(cartId); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(status, gin.H{
+			"message": "Cart was successfully updated",
+			"cart":    cart,
+		})
+	}
+}
 
 func DeleteCart(ctx *gin.Context) {
 	idParam := ctx.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 
-	_, _, accessTokenValidation := middleware.ValidateAccessToken(ctx)
-
-	if accessTokenValidation != "" {
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error": "Invalid ID",
+		})
+		return
+	} else if ownerId, err := repository.GetCartOwner(id); err == sql.ErrNoRows || (err == nil && !policy.CanWriteCart(middleware.CurrentPrincipal(ctx), ownerId)) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "No cart found with the given ID",
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":  "Failed to delete cart",
+			"detais": err.Error(),
 		})
 	} else {
+		rowsDeleted, err := repository.DeleteCart(id)
+
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid ID",
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error":  "Failed to delete cart",
+				"detais": err.Error(),
+			})
+			return
+		} else if rowsDeleted == 0 {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "No cart found with the given ID",
 			})
 			return
 		} else {
-			rowsDeleted, err := repository.DeleteCart(id)
-
-			if err != nil {
-				ctx.JSON(http.StatusInternalServerError, gin.H{
-					"error":  "Failed to delete cart",
-					"detais": err.Error(),
-				})
-				return
-			} else if rowsDeleted == 0 {
-				ctx.JSON(http.StatusNotFound, gin.H{
-					"message": "No cart found with the given ID",
-				})
-				return
-			} else {
-				ctx.JSON(http.StatusOK, gin.H{
-					"message": "Cart has been deleted",
-					"rows":    rowsDeleted,
-				})
-			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Cart has been deleted",
+				"rows":    rowsDeleted,
+			})
 		}
 	}
 }
 
-func PayCart(ctx *gin.Context) {
-	idParam := ctx.Param("cart_id")
+func GetActiveCart(ctx *gin.Context) {
+	cart, err := repository.GetActiveCart(middleware.CurrentPrincipal(ctx).UserId, utils.IDGenerator())
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, cart)
+	}
+}
+
+func Checkout(ctx *gin.Context) {
+	idParam := ctx.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 
-	_, _, accessTokenValidation := middleware.ValidateAccessToken(ctx)
+	var input models.CheckoutBody
 
-	if accessTokenValidation != "" {
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if ownerId, err := repository.GetCartOwner(id); err == sql.ErrNoRows || (err == nil && !policy.CanCheckoutCart(middleware.CurrentPrincipal(ctx), ownerId)) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Cart doesn't exist",
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Checkout failed",
+			"details": err.Error(),
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error":   "Invalid input",
+			"details": err.Error(),
 		})
 	} else {
-		if err != nil {
+		order, err := repository.Checkout(id, models.Order{
+			Id:            utils.IDGenerator(),
+			UserId:        ownerId,
+			PaymentMethod: input.PaymentMethod,
+			CreatedAt:     time.Now(),
+		}, input.ShipTo)
+
+		var priceChange *repository.PriceChangeError
+
+		if errors.As(err, &priceChange) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error":   priceChange.Error(),
+				"changes": priceChange.Changes,
+				"pricing": priceChange.Pricing,
+			})
+		} else if errors.Is(err, repository.ErrCartEmpty) {
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid ID",
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrInsufficientStock) || errors.Is(err, repository.ErrVariantInactive) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Checkout failed",
+				"details": err.Error(),
 			})
-			return
 		} else {
-			var input models.CartPayment
-
-			if err := ctx.ShouldBindJSON(&input); err != nil {
-				ctx.JSON(http.StatusBadRequest, gin.H{
-					"error":   "Invalid input",
-					"details": err.Error(),
-				})
-				return
-			} else {
-				_, err := repository.PayCart(id)
-
-				if err != nil {
-					fmt.Println(input)
-					ctx.JSON(http.StatusInternalServerError, gin.H{
-						"error":   "Payment Failed",
-						"details": err.Error(),
-					})
-					return
-				} else {
-					ctx.JSON(http.StatusOK, gin.H{
-						"message": "Payment Successful",
-					})
-				}
-			}
+			ctx.JSON(http.StatusCreated, gin.H{
+				"message": "Order has been placed",
+				"order":   order,
+			})
 		}
 	}
 }
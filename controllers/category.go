@@ -0,0 +1,379 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/models"
+	"golang-final-project/repository"
+	"golang-final-project/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetCategories(ctx *gin.Context) {
+	categories, err := repository.GetCategoryTree()
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"categories": categories,
+		})
+	}
+}
+
+func PostCategory(ctx *gin.Context) {
+	var input models.CategoryBody
+
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if message := normalizeSlugged(&input.Name, &input.Slug); message != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+	} else if taken, err := repository.CategorySlugTaken(input.Slug, 0); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if taken {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "Category slug has been taken",
+		})
+	} else {
+		category := models.Category{
+			Id:        utils.IDGenerator(),
+			ParentId:  input.ParentId,
+			Name:      input.Name,
+			Slug:      input.Slug,
+			Position:  input.Position,
+			Children:  []models.Category{},
+			CreatedAt: time.Now(),
+		}
+
+		err := repository.CreateCategory(category)
+
+		if errors.Is(err, repository.ErrParentCategoryNotFound) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusCreated, gin.H{
+				"category": category,
+			})
+		}
+	}
+}
+
+func UpdateCategory(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.CategoryBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if message := normalizeSlugged(&input.Name, &input.Slug); message != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+	} else if taken, err := repository.CategorySlugTaken(input.Slug, id); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if taken {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "Category slug has been taken",
+		})
+	} else {
+		err := repository.UpdateCategory(id, input)
+
+		if errors.Is(err, repository.ErrCategoryNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrParentCategoryNotFound) || errors.Is(err, repository.ErrCategoryCycle) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else if category, err := repository.GetCategoryById(id); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message":  "Category was successfully updated",
+				"category": category,
+			})
+		}
+	}
+}
+
+func DeleteCategory(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else {
+		err := repository.DeleteCategory(id)
+
+		if errors.Is(err, repository.ErrCategoryNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrCategoryInUse) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Category has been deleted",
+			})
+		}
+	}
+}
+
+func GetTags(ctx *gin.Context) {
+	tags, err := repository.GetTags()
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"tags": tags,
+		})
+	}
+}
+
+func PostTag(ctx *gin.Context) {
+	var input models.TagBody
+
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if message := normalizeSlugged(&input.Name, &input.Slug); message != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+	} else if taken, err := repository.TagSlugTaken(input.Slug, 0); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if taken {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "Tag slug has been taken",
+		})
+	} else {
+		tag := models.Tag{
+			Id:        utils.IDGenerator(),
+			Name:      input.Name,
+			Slug:      input.Slug,
+			CreatedAt: time.Now(),
+		}
+
+		if err := repository.CreateTag(tag); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusCreated, gin.H{
+				"tag": tag,
+			})
+		}
+	}
+}
+
+func UpdateTag(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.TagBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if message := normalizeSlugged(&input.Name, &input.Slug); message != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+	} else if taken, err := repository.TagSlugTaken(input.Slug, id); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if taken {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "Tag slug has been taken",
+		})
+	} else {
+		err := repository.UpdateTag(id, input)
+
+		if errors.Is(err, repository.ErrTagNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Tag was successfully updated",
+			})
+		}
+	}
+}
+
+func DeleteTag(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else {
+		err := repository.DeleteTag(id)
+
+		if errors.Is(err, repository.ErrTagNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Tag has been deleted",
+			})
+		}
+	}
+}
+
+func UpdateItemCategory(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.ItemCategoryBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else {
+		err := repository.SetItemCategory(id, input.CategoryId)
+		respondItemClassification(ctx, id, err)
+	}
+}
+
+func UpdateItemTags(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.ItemTagsBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else {
+		err := repository.SetItemTags(id, input.Tags)
+		respondItemClassification(ctx, id, err)
+	}
+}
+
+func respondItemClassification(ctx *gin.Context, id int64, err error) {
+	if errors.Is(err, repository.ErrItemNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	} else if errors.Is(err, repository.ErrCategoryNotFound) || errors.Is(err, repository.ErrUnknownTag) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if item, err := repository.GetItemById(id); err == sql.ErrNoRows {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Item doesn't exist",
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "Item was successfully updated",
+			"item":    item,
+		})
+	}
+}
+
+// normalizeSlugged trims the name and derives the slug from it when none was
+// given, returning what is wrong with either.
+func normalizeSlugged(name, slug *string) string {
+	*name = strings.TrimSpace(*name)
+	if *slug == "" {
+		*slug = utils.Slugify(*name)
+	}
+
+	if *name == "" {
+		return "Name cannot be empty"
+	} else if !utils.IsSlug(*slug) {
+		return "Slug can only contain lowercase letters, digits and single dashes"
+	} else {
+		return ""
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"errors"
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+	"golang-final-project/repository"
+	"golang-final-project/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PostInvitation(ctx *gin.Context) {
+	var input models.InvitationBody
+
+	principal := middleware.CurrentPrincipal(ctx)
+
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	} else if input.Role == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Please specify a role",
+		})
+	} else if exists, err := repository.RoleExists(input.Role); err != nil || !exists {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Role doesn't exist",
+		})
+	} else {
+		now := time.Now()
+		invitation := models.Invitation{
+			Id:        utils.IDGenerator(),
+			Role:      input.Role,
+			CreatedBy: principal.UserId,
+			CreatedAt: now,
+			ExpiresAt: now.Add(middleware.InvitationTTL),
+		}
+
+		token, err := middleware.GenerateInvitationToken(strconv.Itoa(invitation.Id), invitation.Role, invitation.ExpiresAt)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if err := repository.CreateInvitation(invitation); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusCreated, gin.H{
+				"invitation": models.InvitationResponse{
+					Invitation: invitation,
+					Token:      token,
+				},
+			})
+		}
+	}
+}
+
+func AcceptInvitation(ctx *gin.Context) {
+	var input models.AcceptInvitationBody
+
+	claims, err := middleware.ValidateInvitationToken(ctx.Param("token"))
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid or expired invitation",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	} else if input.Username == "" || input.Password == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Username and/or password fields cannot be empty",
+		})
+	} else {
+		invitationId, _ := strconv.Atoi(claims.InvitationId)
+		hashedPassword, _ := middleware.HashPassword(input.Password)
+
+		user, err := repository.AcceptInvitation(invitationId, models.User{
+			Id:       utils.IDGenerator(),
+			Username: input.Username,
+			Password: hashedPassword,
+		})
+
+		if errors.Is(err, repository.ErrInvitationNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrInvitationAccepted) || errors.Is(err, repository.ErrInvitationExpired) {
+			ctx.JSON(http.StatusGone, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrUsernameTaken) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": "Username has been taken",
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusCreated, gin.H{
+				"user": models.BuildUserResponse(*user),
+			})
+		}
+	}
+}
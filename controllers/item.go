@@ -2,13 +2,16 @@ package controllers
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"golang-final-project/middleware"
 	"golang-final-project/models"
+	"golang-final-project/policy"
 	"golang-final-project/repository"
 	"golang-final-project/utils"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,94 +20,300 @@ import (
 func PostItem(ctx *gin.Context) {
 	var item models.Item
 
-	userId, role, accessTokenValidation := middleware.ValidateAccessToken(ctx)
+	userId := strconv.Itoa(middleware.CurrentPrincipal(ctx).UserId)
 
-	if accessTokenValidation != "" {
+	if err := ctx.Request.ParseMultipartForm(10 << 20); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error": "Failed to parse form data",
 		})
+		return
 	} else {
-		if role == "user" {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "Only admins are allowed to perform this action",
+		now := time.Now()
+		price, err := strconv.Atoi(ctx.PostForm("price"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Price must be a number"})
+			return
+		}
+		stock, err := strconv.Atoi(ctx.PostForm("stock"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Stock must be a number"})
+			return
+		}
+
+		item = models.Item{
+			Id:          utils.IDGenerator(),
+			ItemName:    ctx.PostForm("item_name"),
+			Description: ctx.PostForm("desc"),
+			Price:       price,
+			Stock:       stock,
+			CreatedAt:   &now,
+			CreatedBy:   userId,
+			ModifiedAt:  &now,
+			ModifiedBy:  userId,
+		}
+
+		form, _ := ctx.MultipartForm()
+		images, renditions, err := processUploads(item.Id, form.File["images"])
+
+		if respondUploadError(ctx, err) {
+			return
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to read image",
+				"details": err.Error(),
+			})
+			return
+		} else if err := storeUploads(images, renditions); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to save image",
+			})
+			return
+		}
+		item.Images = images
+
+		repository.CreateItem(item)
+
+		if created, err := repository.GetItemById(int64(item.Id)); err != nil {
+			ctx.JSON(http.StatusCreated, gin.H{
+				"message": "item created",
 			})
 		} else {
-			if err := ctx.Request.ParseMultipartForm(10 << 20); err != nil {
-				ctx.JSON(http.StatusBadRequest, gin.H{
-					"error": "Failed to parse form data",
-				})
-				return
-			} else {
-				now := time.Now()
-				price, err := strconv.Atoi(ctx.PostForm("price"))
-				if err != nil {
-					ctx.JSON(http.StatusBadRequest, gin.H{"error": "Price must be a number"})
-					return
-				}
-				stock, err := strconv.Atoi(ctx.PostForm("stock"))
-				if err != nil {
-					ctx.JSON(http.StatusBadRequest, gin.H{"error": "Stock must be a number"})
-					return
-				}
-
-				item = models.Item{
-					Id:          utils.IDGenerator(),
-					ItemName:    ctx.PostForm("item_name"),
-					Description: ctx.PostForm("desc"),
-					Price:       price,
-					Stock:       stock,
-					CreatedAt:   &now,
-					CreatedBy:   userId,
-					ModifiedAt:  &now,
-					ModifiedBy:  userId,
-				}
-
-				form, _ := ctx.MultipartForm()
-				files := form.File["images"]
-				for _, file := range files {
-					filename := fmt.Sprintf("uploads/%d_%s", time.Now().UnixNano(), file.Filename)
-					if err := ctx.SaveUploadedFile(file, filename); err != nil {
-						ctx.JSON(http.StatusInternalServerError, gin.H{
-							"error": "Failed to save image",
-						})
-
-						return
-					} else {
-						item.Images = append(item.Images, models.ItemImages{
-							Id:       utils.IDGenerator(),
-							ImageUrl: filename,
-						})
-					}
-				}
-
-				repository.CreateItem(item)
-				ctx.JSON(http.StatusCreated, gin.H{
-					"message": "item created",
-				})
-			}
+			ctx.JSON(http.StatusCreated, gin.H{
+				"message": "item created",
+				"item":    created,
+			})
 		}
 	}
 }
 
+const (
+	defaultItemPageSize = 20
+	maxItemPageSize     = 100
+)
+
 func GetItems(ctx *gin.Context) {
-	items, err := repository.GetItems()
+	query, message := parseItemQuery(ctx)
+
+	if message != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+		return
+	}
 
-	_, _, accessTokenValidation := middleware.ValidateAccessToken(ctx)
+	page, err := repository.GetItems(query)
+	if err == nil && policy.CanSeeItemLocations(middleware.CurrentPrincipal(ctx)) {
+		err = attachItemLocations(page.Items)
+	}
 
-	if accessTokenValidation != "" {
+	if errors.Is(err, repository.ErrInvalidCursor) {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error": err.Error(),
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
 		})
 	} else {
+		page.Links = itemPageLinks(ctx, query, page)
+		ctx.JSON(http.StatusOK, page)
+	}
+}
+
+// parseItemQuery reads the listing's query string, returning what is wrong
+// with it when it can't be used.
+func parseItemQuery(ctx *gin.Context) (models.ItemQuery, string) {
+	query := models.ItemQuery{
+		Sort:      ctx.DefaultQuery("sort", models.ItemSortNewest),
+		Limit:     defaultItemPageSize,
+		After:     ctx.Query("after"),
+		Before:    ctx.Query("before"),
+		CreatedBy: ctx.Query("created_by"),
+		Category:  ctx.Query("category"),
+	}
+
+	var err error
+
+	if !repository.IsItemSort(query.Sort) {
+		return query, "Sort must be one of name, -name, price, -price, newest, oldest, stock or -stock"
+	}
+
+	if value := ctx.Query("limit"); value != "" {
+		if query.Limit, err = strconv.Atoi(value); err != nil || query.Limit < 1 || query.Limit > maxItemPageSize {
+			return query, fmt.Sprintf("Limit must be a number from 1 to %d", maxItemPageSize)
+		}
+	}
+
+	if value := ctx.Query("offset"); value != "" {
+		if query.Offset, err = strconv.Atoi(value); err != nil || query.Offset < 0 {
+			return query, "Offset must be zero or more"
+		}
+	}
+
+	if query.After != "" && query.Before != "" {
+		return query, "Only one of after and before can be given"
+	} else if (query.After != "" || query.Before != "") && query.Offset > 0 {
+		return query, "Offset cannot be combined with a cursor"
+	}
+
+	if query.MinPrice, err = intParam(ctx, "min_price"); err != nil {
+		return query, "min_price must be a number of zero or more"
+	}
+	if query.MaxPrice, err = intParam(ctx, "max_price"); err != nil {
+		return query, "max_price must be a number of zero or more"
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+		return query, "min_price cannot be greater than max_price"
+	}
+
+	// Tags can be repeated or comma separated, an item needs all of them
+	seen := make(map[string]bool)
+	for _, value := range ctx.QueryArray("tag") {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" && !seen[tag] {
+				seen[tag] = true
+				query.Tags = append(query.Tags, tag)
+			}
+		}
+	}
+
+	if value := ctx.Query("in_stock"); value != "" {
+		inStock, err := strconv.ParseBool(value)
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
+			return query, "in_stock must be true or false"
+		}
+		query.InStock = &inStock
+	}
+
+	dates := []struct {
+		name   string
+		target **time.Time
+	}{
+		{"created_after", &query.CreatedAfter},
+		{"created_before", &query.CreatedBefore},
+		{"modified_after", &query.ModifiedAfter},
+		{"modified_before", &query.ModifiedBefore},
+	}
+
+	for _, date := range dates {
+		if *date.target, err = timeParam(ctx, date.name); err != nil {
+			return query, date.name + " must be a date (2006-01-02) or an RFC 3339 timestamp"
+		}
+	}
+
+	return query, ""
+}
+
+func intParam(ctx *gin.Context, name string) (*int, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	} else if number < 0 {
+		return nil, strconv.ErrRange
+	}
+	return &number, nil
+}
+
+func timeParam(ctx *gin.Context, name string) (*time.Time, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// itemPageLinks points at the pages either side of page, keeping the
+// request's filters and paging the same way the request did.
+func itemPageLinks(ctx *gin.Context, query models.ItemQuery, page *models.ItemPage) models.PageLinks {
+	if page.Offset != nil {
+		return offsetPageLinks(ctx, query.Limit, query.Offset, page.HasNext)
+	}
+
+	links := models.PageLinks{}
+
+	if page.HasNext && page.NextCursor != "" {
+		links.Next = pageLink(ctx, query.Limit, "after", page.NextCursor)
+	}
+	if page.HasPrev && page.PrevCursor != "" {
+		links.Prev = pageLink(ctx, query.Limit, "before", page.PrevCursor)
+	}
+
+	return links
+}
+
+func offsetPageLinks(ctx *gin.Context, limit, offset int, hasNext bool) models.PageLinks {
+	links := models.PageLinks{}
+
+	if hasNext {
+		links.Next = pageLink(ctx, limit, "offset", strconv.Itoa(offset+limit))
+	}
+	if offset > limit {
+		links.Prev = pageLink(ctx, limit, "offset", strconv.Itoa(offset-limit))
+	} else if offset > 0 {
+		links.Prev = pageLink(ctx, limit, "", "")
+	}
+
+	return links
+}
+
+// pageLink is the request's URL with its paging replaced by key=value.
+func pageLink(ctx *gin.Context, limit int, key, value string) string {
+	values := ctx.Request.URL.Query()
+	values.Del("offset")
+	values.Del("after")
+	values.Del("before")
+	values.Set("limit", strconv.Itoa(limit))
+	if key != "" {
+		values.Set(key, value)
+	}
+	return ctx.Request.URL.Path + "?" + values.Encode()
+}
+
+const maxSearchLength = 200
+
+func SearchItems(ctx *gin.Context) {
+	query, message := parseItemQuery(ctx)
+	text := strings.TrimSpace(ctx.Query("q"))
+
+	if message != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+	} else if query.After != "" || query.Before != "" || ctx.Query("sort") != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Search results are ordered by relevance and paged with limit and offset",
+		})
+	} else if len(text) > maxSearchLength {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("q cannot be longer than %d characters", maxSearchLength),
+		})
+	} else if repository.SearchTerms(text) == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "q must contain at least one word to search for",
+		})
+	} else {
+		page, err := repository.SearchItems(models.ItemSearchQuery{ItemQuery: query, Text: text})
+
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
 				"error": err.Error(),
 			})
-			return
 		} else {
-			ctx.JSON(http.StatusOK, gin.H{
-				"items": items,
-			})
+			page.Links = offsetPageLinks(ctx, query.Limit, query.Offset, query.Offset+len(page.Results) < page.Total)
+			ctx.JSON(http.StatusOK, page)
 		}
 	}
 }
@@ -113,90 +322,95 @@ func GetItemById(ctx *gin.Context) {
 	idParam := ctx.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 
-	_, _, accessTokenValidation := middleware.ValidateAccessToken(ctx)
-
-	if accessTokenValidation != "" {
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error": "Invalid ID",
 		})
 	} else {
+		item, err := repository.GetItemById(id)
+		if err == nil && policy.CanSeeItemLocations(middleware.CurrentPrincipal(ctx)) {
+			items := []models.Item{*item}
+			err = attachItemLocations(items)
+			item = &items[0]
+		}
+
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid ID",
-			})
-		} else {
-			item, err := repository.GetItemById(id)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					ctx.JSON(http.StatusNotFound, gin.H{
-						"error": "Item doesn't exist",
-					})
-				} else {
-					ctx.JSON(http.StatusInternalServerError, gin.H{
-						"error": err.Error(),
-					})
-				}
-				return
+			if err == sql.ErrNoRows {
+				ctx.JSON(http.StatusNotFound, gin.H{
+					"error": "Item doesn't exist",
+				})
 			} else {
-				ctx.JSON(http.StatusOK, item)
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
 			}
+			return
+		} else {
+			ctx.JSON(http.StatusOK, item)
 		}
 	}
 }
 
+func attachItemLocations(items []models.Item) error {
+	ids := make([]int, len(items))
+	for i, item := range items {
+		ids[i] = item.Id
+	}
+
+	locations, err := repository.GetItemLocations(ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		items[i].Locations = locations[items[i].Id]
+	}
+	return nil
+}
+
 func UpdateItem(ctx *gin.Context) {
 	idParam := ctx.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 
-	userId, role, accessTokenValidation := middleware.ValidateAccessToken(ctx)
+	userId := strconv.Itoa(middleware.CurrentPrincipal(ctx).UserId)
 
-	if accessTokenValidation != "" {
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error": "Invalid ID",
 		})
+		return
 	} else {
-		if err != nil {
+		var input models.Item
+
+		if err := ctx.ShouldBindJSON(&input); err != nil {
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid ID",
+				"error":   "Invalid input",
+				"details": err.Error(),
 			})
 			return
 		} else {
-			var input models.Item
+			now := time.Now()
+
+			input.ModifiedAt = &now
+			input.ModifiedBy = userId
+			rowsUpdated, err := repository.UpdateItem(id, input)
 
-			if err := ctx.ShouldBindJSON(&input); err != nil {
-				ctx.JSON(http.StatusBadRequest, gin.H{
-					"error":   "Invalid input",
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"error":   "Failed to update item",
 					"details": err.Error(),
 				})
 				return
-			} else if role == "user" {
-				ctx.JSON(http.StatusBadRequest, gin.H{
-					"error": "Only admins are allowed to perform this action",
+			} else if rowsUpdated == 0 {
+				ctx.JSON(http.StatusNotFound, gin.H{
+					"message": "No item found with the given ID",
 				})
+				return
 			} else {
-				now := time.Now()
-
-				input.ModifiedAt = &now
-				input.ModifiedBy = userId
-				rowsUpdated, err := repository.UpdateItem(id, input)
-
-				if err != nil {
-					ctx.JSON(http.StatusInternalServerError, gin.H{
-						"error":   "Failed to update item",
-						"details": err.Error(),
-					})
-					return
-				} else if rowsUpdated == 0 {
-					ctx.JSON(http.StatusNotFound, gin.H{
-						"message": "No item found with the given ID",
-					})
-					return
-				} else {
-					ctx.JSON(http.StatusOK, gin.H{
-						"message": "Item was successfully updated",
-						"rows":    rowsUpdated,
-					})
-				}
+				ctx.JSON(http.StatusOK, gin.H{
+					"message": "Item was successfully updated",
+					"rows":    rowsUpdated,
+				})
 			}
 		}
 	}
@@ -206,42 +420,30 @@ func DeleteItem(ctx *gin.Context) {
 	idParam := ctx.Param("id")
 	id, err := strconv.ParseInt(idParam, 10, 64)
 
-	_, role, accessTokenValidation := middleware.ValidateAccessToken(ctx)
-
-	if accessTokenValidation != "" {
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": accessTokenValidation,
+			"error": "Invalid ID",
 		})
+		return
 	} else {
+		rowsDeleted, err := repository.DeleteItem(id)
+
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid ID",
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error":  "Failed to delete item",
+				"detais": err.Error(),
 			})
 			return
-		} else if role == "user" {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "Only admins are allowed to perform this action",
+		} else if rowsDeleted == 0 {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "No item found with the given ID",
 			})
+			return
 		} else {
-			rowsDeleted, err := repository.DeleteItem(id)
-
-			if err != nil {
-				ctx.JSON(http.StatusInternalServerError, gin.H{
-					"error":  "Failed to delete item",
-					"detais": err.Error(),
-				})
-				return
-			} else if rowsDeleted == 0 {
-				ctx.JSON(http.StatusNotFound, gin.H{
-					"message": "No item found with the given ID",
-				})
-				return
-			} else {
-				ctx.JSON(http.StatusOK, gin.H{
-					"message": "Item has been deleted",
-					"rows":    rowsDeleted,
-				})
-			}
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Item has been deleted",
+				"rows":    rowsDeleted,
+			})
 		}
 	}
 }
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"errors"
+	"golang-final-project/models"
+	"golang-final-project/repository"
+	"golang-final-project/storage"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetItemImages(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if images, err := repository.GetItemImages(id); errors.Is(err, repository.ErrItemNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"images": images,
+		})
+	}
+}
+
+func PostItemImages(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+		return
+	} else if err := ctx.Request.ParseMultipartForm(10 << 20); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Failed to parse form data",
+		})
+		return
+	} else if _, err := repository.GetItemImages(id); errors.Is(err, repository.ErrItemNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	form, _ := ctx.MultipartForm()
+	files := form.File["images"]
+	if len(files) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "No images uploaded",
+		})
+		return
+	}
+
+	images, renditions, err := processUploads(int(id), files)
+
+	if respondUploadError(ctx, err) {
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to read image",
+			"details": err.Error(),
+		})
+		return
+	} else if err := storeUploads(images, renditions); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save image",
+		})
+		return
+	}
+
+	if err := repository.AddItemImages(id, images); err != nil {
+		discardUploads(images)
+
+		if errors.Is(err, repository.ErrItemNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		}
+		return
+	}
+
+	respondItemImages(ctx, id, http.StatusCreated)
+}
+
+func DeleteItemImage(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	imageId, imageErr := strconv.ParseInt(ctx.Param("image_id"), 10, 64)
+
+	if err != nil || imageErr != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else {
+		err := repository.DeleteItemImage(id, imageId)
+		respondImageChange(ctx, id, err)
+	}
+}
+
+func ReorderItemImages(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+
+	var input models.ItemImagesOrderBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else {
+		err := repository.ReorderItemImages(id, input.ImageIds)
+		respondImageChange(ctx, id, err)
+	}
+}
+
+func SetPrimaryItemImage(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	imageId, imageErr := strconv.ParseInt(ctx.Param("image_id"), 10, 64)
+
+	if err != nil || imageErr != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else {
+		err := repository.SetPrimaryImage(id, imageId)
+		respondImageChange(ctx, id, err)
+	}
+}
+
+// respondImageChange responds to a change of the item's images with the
+// images as they are now.
+func respondImageChange(ctx *gin.Context, id int64, err error) {
+	if errors.Is(err, repository.ErrItemNotFound) || errors.Is(err, repository.ErrImageNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	} else if errors.Is(err, repository.ErrImageOrderMismatch) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		respondItemImages(ctx, id, http.StatusOK)
+	}
+}
+
+func respondItemImages(ctx *gin.Context, id int64, status int) {
+	if images, err := repository.GetItemImages(id); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(status, gin.H{
+			"images": images,
+		})
+	}
+}
+
+// discardUploads removes stored uploads that never made it into the
+// database.
+func discardUploads(images []models.ItemImages) {
+	store := storage.Default()
+
+	for _, image := range images {
+		for _, rendition := range image.Renditions {
+			store.Delete(rendition.Key)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"golang-final-project/middleware"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetJWKS(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"keys": middleware.JWKS(),
+	})
+}
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/lifecycle"
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+	"golang-final-project/policy"
+	"golang-final-project/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetOrders(ctx *gin.Context) {
+	orders, err := repository.GetOrdersByUserId(int64(middleware.CurrentPrincipal(ctx).UserId))
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"orders": orders,
+		})
+	}
+}
+
+func GetOrderById(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else {
+		order, err := repository.GetOrderById(id)
+		if err == nil && !policy.CanReadOrder(middleware.CurrentPrincipal(ctx), order.UserId) {
+			err = sql.ErrNoRows
+		}
+
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": "Order doesn't exist",
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, order)
+		}
+	}
+}
+
+func GetOrdersByUserId(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if !policy.CanListUserOrders(middleware.CurrentPrincipal(ctx), int(id)) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": "You can only view your own orders",
+		})
+	} else {
+		orders, err := repository.GetOrdersByUserId(id)
+
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"orders": orders,
+			})
+		}
+	}
+}
+
+func GetOrderHistory(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if ownerId, err := repository.GetOrderOwner(id); err == sql.ErrNoRows || (err == nil && !policy.CanReadOrder(middleware.CurrentPrincipal(ctx), ownerId)) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Order doesn't exist",
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		history, err := repository.GetOrderHistory(id)
+
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"history": history,
+			})
+		}
+	}
+}
+
+func UpdateOrderStatus(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.OrderStatusBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if !lifecycle.IsOrderStatus(input.Status) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Unknown order status",
+		})
+	} else if !lifecycle.IsManualStatus(input.Status) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Orders can't be moved to this status directly",
+		})
+	} else {
+		err := repository.TransitionOrder(id, input.Status, &middleware.CurrentPrincipal(ctx).UserId, input.Reason)
+
+		if errors.Is(err, repository.ErrOrderNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, lifecycle.ErrIllegalTransition) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else if order, err := repository.GetOrderById(id); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Order status was successfully updated",
+				"order":   order,
+			})
+		}
+	}
+}
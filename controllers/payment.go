@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/lifecycle"
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+	"golang-final-project/payment"
+	"golang-final-project/policy"
+	"golang-final-project/repository"
+	"golang-final-project/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func PayOrder(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.PaymentBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if !orderPayable(ctx, id) {
+		return
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if input.PaymentToken == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Payment token cannot be empty",
+		})
+	} else if provider, err := payment.Default(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		attempt, err := repository.CreatePayment(models.Payment{
+			Id:       utils.IDGenerator(),
+			OrderId:  int(id),
+			Provider: provider.Name(),
+		}, &middleware.CurrentPrincipal(ctx).UserId)
+
+		if errors.Is(err, repository.ErrOrderNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, lifecycle.ErrIllegalTransition) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Payment Failed",
+				"details": err.Error(),
+			})
+		} else {
+			result, err := provider.Authorize(payment.AuthorizeRequest{
+				OrderId: attempt.OrderId,
+				Amount:  attempt.Amount,
+				Token:   input.PaymentToken,
+			})
+
+			settlePayment(ctx, provider, attempt, result, err)
+		}
+	}
+}
+
+// ConfirmPayment is called once the customer finished the provider's extra
+// verification step, it asks the provider how the attempt ended.
+func ConfirmPayment(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	paymentId, paymentErr := strconv.ParseInt(ctx.Param("payment_id"), 10, 64)
+
+	if err != nil || paymentErr != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if !orderPayable(ctx, id) {
+		return
+	} else if attempt, err := repository.GetPaymentById(paymentId); errors.Is(err, repository.ErrPaymentNotFound) || (err == nil && attempt.OrderId != int(id)) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Payment doesn't exist",
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if attempt.Status != models.PaymentStatusRequiresAction {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error":   "Payment is not waiting for verification",
+			"payment": attempt,
+		})
+	} else if provider, err := payment.Get(attempt.Provider); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		result, err := provider.Status(attempt.Reference)
+
+		settlePayment(ctx, provider, attempt, result, err)
+	}
+}
+
+func GetOrderPayments(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if ownerId, err := repository.GetOrderOwner(id); err == sql.ErrNoRows || (err == nil && !policy.CanReadOrder(middleware.CurrentPrincipal(ctx), ownerId)) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Order doesn't exist",
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		payments, err := repository.GetPaymentsByOrderId(id)
+
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"payments": payments,
+			})
+		}
+	}
+}
+
+// orderPayable responds with 404 and returns false when the order doesn't
+// exist or wasn't placed by the caller.
+func orderPayable(ctx *gin.Context, id int64) bool {
+	ownerId, err := repository.GetOrderOwner(id)
+
+	if err == sql.ErrNoRows || (err == nil && !policy.CanPayOrder(middleware.CurrentPrincipal(ctx), ownerId)) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Order doesn't exist",
+		})
+		return false
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Payment Failed",
+			"details": err.Error(),
+		})
+		return false
+	} else {
+		return true
+	}
+}
+
+// settlePayment records the provider's answer for the attempt and, once the
+// money is authorized, captures it and marks the order as paid. An
+// authorization that can't be captured is voided so the customer isn't left
+// with a hold on their card, a capture the order can't take is refunded.
+func settlePayment(ctx *gin.Context, provider payment.Provider, attempt *models.Payment, result payment.Result, err error) {
+	if err != nil {
+		attempt.Status = models.PaymentStatusFailed
+		attempt.Message = err.Error()
+	} else {
+		attempt.Reference = result.Reference
+		attempt.Status = result.Status
+		attempt.ActionURL = result.ActionURL
+		attempt.Message = result.Message
+	}
+
+	if updateErr := repository.UpdatePayment(attempt); updateErr != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Payment Failed",
+			"details": updateErr.Error(),
+		})
+	} else if errors.Is(err, payment.ErrTimeout) {
+		ctx.JSON(http.StatusGatewayTimeout, gin.H{
+			"error":   "Payment provider didn't respond, please try again",
+			"payment": attempt,
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"error":   "Payment Failed",
+			"details": err.Error(),
+			"payment": attempt,
+		})
+	} else if attempt.Status == models.PaymentStatusDeclined {
+		ctx.JSON(http.StatusPaymentRequired, gin.H{
+			"error":   "Payment was declined",
+			"payment": attempt,
+		})
+	} else if attempt.Status == models.PaymentStatusRequiresAction {
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"message": "Additional verification required",
+			"payment": attempt,
+		})
+	} else if attempt.Status != models.PaymentStatusAuthorized {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"error":   "Unexpected payment status " + attempt.Status,
+			"payment": attempt,
+		})
+	} else {
+		err := repository.CompletePayment(int64(attempt.Id), &middleware.CurrentPrincipal(ctx).UserId, func(p models.Payment) error {
+			_, err := provider.Capture(p.Reference, p.Amount)
+			return err
+		}, refundThroughProvider)
+
+		if errors.Is(err, repository.ErrPaymentCaptured) {
+			// The money has been taken and given back, there's no hold to void
+			if stored, getErr := repository.GetPaymentById(int64(attempt.Id)); getErr == nil {
+				attempt = stored
+			}
+		} else if err != nil {
+			if voided, voidErr := provider.Void(attempt.Reference); voidErr == nil {
+				attempt.Status = voided.Status
+			}
+			attempt.Message = err.Error()
+			repository.UpdatePayment(attempt)
+		}
+
+		if errors.Is(err, repository.ErrInsufficientStock) || errors.Is(err, lifecycle.ErrIllegalTransition) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error":   err.Error(),
+				"payment": attempt,
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Payment Failed",
+				"details": err.Error(),
+				"payment": attempt,
+			})
+		} else {
+			attempt.Status = models.PaymentStatusCaptured
+			attempt.Message = ""
+
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Payment Successful",
+				"payment": attempt,
+			})
+		}
+	}
+}
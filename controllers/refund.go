@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/lifecycle"
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+	"golang-final-project/payment"
+	"golang-final-project/policy"
+	"golang-final-project/repository"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RefundOrder(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.RefundBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if !validRefundQuantities(input.Lines) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Quantity must be greater than zero",
+		})
+	} else {
+		refund, err := repository.RefundOrder(id, input, &middleware.CurrentPrincipal(ctx).UserId, models.OrderStatusRefunded, refundThroughProvider)
+
+		respondRefund(ctx, refund, err)
+	}
+}
+
+func validRefundQuantities(lines []models.RefundLineBody) bool {
+	for _, line := range lines {
+		if line.Quantity <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// CancelOrder cancels an order before fulfilment. Unpaid orders are simply
+// cancelled and their open payment attempts voided, paid ones are refunded in
+// full and restocked.
+func CancelOrder(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.CancelOrderBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if ownerId, err := repository.GetOrderOwner(id); err == sql.ErrNoRows || (err == nil && !policy.CanCancelOrder(middleware.CurrentPrincipal(ctx), ownerId)) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Order doesn't exist",
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else {
+		actorId := &middleware.CurrentPrincipal(ctx).UserId
+
+		open, err := repository.CancelOrder(id, actorId, input.Reason)
+
+		if errors.Is(err, repository.ErrOrderAlreadyPaid) {
+			refund, err := repository.RefundOrder(id, models.RefundBody{Reason: input.Reason}, actorId, models.OrderStatusCancelled, refundThroughProvider)
+
+			respondRefund(ctx, refund, err)
+		} else if errors.Is(err, lifecycle.ErrIllegalTransition) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			for i := range open {
+				voidPayment(&open[i])
+			}
+
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Order has been cancelled",
+			})
+		}
+	}
+}
+
+func GetOrderRefunds(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if ownerId, err := repository.GetOrderOwner(id); err == sql.ErrNoRows || (err == nil && !policy.CanReadOrder(middleware.CurrentPrincipal(ctx), ownerId)) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": "Order doesn't exist",
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		refunds, err := repository.GetRefundsByOrderId(id)
+
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"refunds": refunds,
+			})
+		}
+	}
+}
+
+// refundThroughProvider returns the money of a refund, key keeps a retried
+// refund from being paid out twice.
+func refundThroughProvider(p models.Payment, amount int, key string) error {
+	provider, err := payment.Get(p.Provider)
+	if err != nil {
+		return err
+	}
+
+	_, err = provider.Refund(p.Reference, amount, key)
+	return err
+}
+
+// voidPayment releases an attempt still open at the provider. Failures are
+// kept on the payment, the hold expires at the provider eventually anyway.
+func voidPayment(attempt *models.Payment) {
+	provider, err := payment.Get(attempt.Provider)
+	if err == nil {
+		var result payment.Result
+		if result, err = provider.Void(attempt.Reference); err == nil {
+			attempt.Status = result.Status
+		}
+	}
+
+	if err != nil {
+		attempt.Message = err.Error()
+	}
+	repository.UpdatePayment(attempt)
+}
+
+func respondRefund(ctx *gin.Context, refund *models.Refund, err error) {
+	if errors.Is(err, repository.ErrOrderNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	} else if errors.Is(err, repository.ErrOrderLineNotFound) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	} else if errors.Is(err, lifecycle.ErrIllegalTransition) ||
+		errors.Is(err, repository.ErrRefundExceedsLine) ||
+		errors.Is(err, repository.ErrNothingToRefund) ||
+		errors.Is(err, repository.ErrNoCapturedPayment) ||
+		errors.Is(err, repository.ErrRefundPending) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Refund Failed",
+			"details": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusCreated, gin.H{
+			"message": "Refund was successfully issued",
+			"refund":  refund,
+		})
+	}
+}
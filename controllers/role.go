@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"errors"
+	"golang-final-project/models"
+	"golang-final-project/repository"
+	"golang-final-project/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetRoles(ctx *gin.Context) {
+	roles, err := repository.GetRoles()
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"roles": roles,
+		})
+	}
+}
+
+func GetPermissions(ctx *gin.Context) {
+	permissions, err := repository.GetPermissions()
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"permissions": permissions,
+		})
+	}
+}
+
+func PostRole(ctx *gin.Context) {
+	var role models.Role
+
+	if err := ctx.ShouldBindJSON(&role); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	} else if role.Name == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Role name cannot be empty",
+		})
+	} else {
+		exists, err := repository.RoleExists(role.Name)
+
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else if exists {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": "Role name has been taken",
+			})
+		} else {
+			role.Id = utils.IDGenerator()
+			err := repository.CreateRole(role)
+
+			if errors.Is(err, repository.ErrUnknownPermission) {
+				ctx.JSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+				})
+			} else if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
+			} else {
+				ctx.JSON(http.StatusCreated, gin.H{
+					"role": role,
+				})
+			}
+		}
+	}
+}
+
+func UpdateRolePermissions(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.Atoi(idParam)
+
+	var input models.RolePermissionsBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else {
+		err := repository.SetRolePermissions(id, input.Permissions)
+
+		if errors.Is(err, repository.ErrRoleNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrUnknownPermission) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Role permissions were successfully updated",
+			})
+		}
+	}
+}
+
+func DeleteRole(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.Atoi(idParam)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else {
+		err := repository.DeleteRole(id)
+
+		if errors.Is(err, repository.ErrRoleNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrRoleInUse) || errors.Is(err, repository.ErrRoleBuiltIn) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Role has been deleted",
+			})
+		}
+	}
+}
+
+func UpdateUserRole(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.Atoi(idParam)
+
+	var input models.UserRoleBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else {
+		rowsUpdated, err := repository.AssignUserRole(id, input.Role)
+
+		if errors.Is(err, repository.ErrRoleNotFound) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else if rowsUpdated == 0 {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "No user found with the given ID",
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "User role was successfully updated",
+			})
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"errors"
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+	"golang-final-project/repository"
+	"golang-final-project/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RefreshToken(ctx *gin.Context) {
+	var body models.RefreshTokenBody
+
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	} else if body.RefreshToken == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Refresh token is required",
+		})
+	} else {
+		refreshToken, refreshTokenHash, err := middleware.GenerateRefreshToken()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		now := time.Now()
+		next := models.RefreshToken{
+			Id:        utils.IDGenerator(),
+			TokenHash: refreshTokenHash,
+			CreatedAt: now,
+			ExpiresAt: now.Add(middleware.RefreshTokenTTL),
+		}
+
+		session, role, err := repository.RotateRefreshToken(middleware.HashRefreshToken(body.RefreshToken), next)
+
+		if errors.Is(err, repository.ErrRefreshTokenInvalid) ||
+			errors.Is(err, repository.ErrRefreshTokenExpired) ||
+			errors.Is(err, repository.ErrRefreshTokenReused) ||
+			errors.Is(err, repository.ErrSessionRevoked) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			accessToken, err := middleware.GenerateJwt(strconv.Itoa(session.UserId), role, strconv.Itoa(session.Id))
+
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
+			} else {
+				ctx.JSON(http.StatusOK, gin.H{
+					"data": models.TokenPair{
+						AccessToken:                accessToken,
+						TokenExpirationTime:        now.Add(middleware.AccessTokenTTL),
+						RefreshToken:               refreshToken,
+						RefreshTokenExpirationTime: next.ExpiresAt,
+					},
+				})
+			}
+		}
+	}
+}
+
+func Logout(ctx *gin.Context) {
+	principal := middleware.CurrentPrincipal(ctx)
+
+	_, err := repository.RevokeSession(principal.SessionId, &principal.UserId, models.RevocationReasonLogout)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "Logged out",
+		})
+	}
+}
+
+func LogoutAll(ctx *gin.Context) {
+	principal := middleware.CurrentPrincipal(ctx)
+
+	rowsRevoked, err := repository.RevokeUserSessions(principal.UserId, &principal.UserId, models.RevocationReasonLogoutAll)
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "Logged out of all sessions",
+			"rows":    rowsRevoked,
+		})
+	}
+}
+
+func RevokeUserSessions(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.Atoi(idParam)
+
+	principal := middleware.CurrentPrincipal(ctx)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else {
+		rowsRevoked, err := repository.RevokeUserSessions(id, &principal.UserId, models.RevocationReasonAdmin)
+
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "User sessions have been revoked",
+				"rows":    rowsRevoked,
+			})
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+	"golang-final-project/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetStockMovements(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else {
+		ledger, err := repository.GetStockLedger(id)
+
+		if err == sql.ErrNoRows {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": "Item doesn't exist",
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, ledger)
+		}
+	}
+}
+
+func PostStockAdjustment(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.StockAdjustmentBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if message := validateStockAdjustment(input); message != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+	} else {
+		movement, err := repository.AdjustStock(id, input, &middleware.CurrentPrincipal(ctx).UserId)
+
+		if errors.Is(err, repository.ErrItemNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrWarehouseNotFound) || errors.Is(err, repository.ErrVariantNotFound) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrNegativeStock) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to adjust stock",
+				"details": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusCreated, gin.H{
+				"message":  "Stock was successfully adjusted",
+				"movement": movement,
+			})
+		}
+	}
+}
+
+// validateStockAdjustment returns what is wrong with the adjustment, or an
+// empty string when it can be applied. Sales and refunds are recorded by
+// orders, only the manual kinds are accepted here.
+func validateStockAdjustment(input models.StockAdjustmentBody) string {
+	switch {
+	case input.Reason == "":
+		return "Reason cannot be empty"
+	case input.Kind == models.StockMovementStocktake:
+		if input.CountedStock == nil || *input.CountedStock < 0 {
+			return "Stocktake needs a counted_stock of zero or more"
+		}
+	case input.Kind == models.StockMovementReceipt:
+		if input.Delta <= 0 {
+			return "Receipt delta must be greater than zero"
+		}
+	case input.Kind == models.StockMovementAdjustment:
+		if input.Delta == 0 {
+			return "Adjustment delta cannot be zero"
+		}
+	default:
+		return "Kind must be one of adjustment, stocktake or receipt"
+	}
+	return ""
+}
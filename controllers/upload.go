@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang-final-project/imaging"
+	"golang-final-project/models"
+	"golang-final-project/storage"
+	"golang-final-project/utils"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadError is an upload that was refused, naming the file it came from.
+type uploadError struct {
+	Filename string
+	Err      error
+}
+
+func (e *uploadError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Filename, e.Err)
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.Err
+}
+
+// processUploads checks and converts every uploaded image before any of
+// them is stored, so one bad file doesn't leave the others behind.
+func processUploads(itemId int, files []*multipart.FileHeader) ([]models.ItemImages, [][]imaging.Rendition, error) {
+	limits := imaging.DefaultLimits()
+	images := []models.ItemImages{}
+	renditions := [][]imaging.Rendition{}
+
+	for _, file := range files {
+		if file.Size > limits.MaxFileSize {
+			return nil, nil, &uploadError{file.Filename, fmt.Errorf("%w, the limit is %d bytes", imaging.ErrFileTooLarge, limits.MaxFileSize)}
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		processed, err := imaging.Process(src, limits)
+		src.Close()
+		if err != nil {
+			return nil, nil, &uploadError{file.Filename, err}
+		}
+
+		image := models.ItemImages{
+			Id:     utils.IDGenerator(),
+			ItemId: itemId,
+		}
+
+		for _, rendition := range processed {
+			key := fmt.Sprintf("items/%d/%d/%s.%s", itemId, image.Id, rendition.Name, rendition.Extension)
+
+			if rendition.Name == imaging.RenditionOriginal {
+				image.ImageUrl = key
+			}
+
+			image.Renditions = append(image.Renditions, models.ImageRendition{
+				Name:        rendition.Name,
+				Width:       rendition.Width,
+				Height:      rendition.Height,
+				ContentType: rendition.ContentType,
+				Size:        len(rendition.Data),
+				Key:         key,
+			})
+		}
+
+		images = append(images, image)
+		renditions = append(renditions, processed)
+	}
+
+	return images, renditions, nil
+}
+
+// storeUploads puts the renditions made by processUploads into the blob
+// store, removing the ones already stored when one of them fails.
+func storeUploads(images []models.ItemImages, renditions [][]imaging.Rendition) error {
+	store := storage.Default()
+	stored := []string{}
+
+	for i, image := range images {
+		for j, rendition := range renditions[i] {
+			key := image.Renditions[j].Key
+
+			if err := store.Put(key, bytes.NewReader(rendition.Data), rendition.ContentType); err != nil {
+				for _, key := range stored {
+					store.Delete(key)
+				}
+				return err
+			}
+			stored = append(stored, key)
+		}
+	}
+
+	return nil
+}
+
+// respondUploadError responds to a refused upload, returning false when err
+// isn't one.
+func respondUploadError(ctx *gin.Context, err error) bool {
+	var refused *uploadError
+
+	if !errors.As(err, &refused) {
+		return false
+	} else if errors.Is(err, imaging.ErrFileTooLarge) {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": err.Error(),
+		})
+	} else if errors.Is(err, imaging.ErrUnsupportedFormat) {
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	}
+	return true
+}
@@ -25,11 +25,9 @@ func Register(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": "Username and/or password fields cannot be empty",
 		})
-	} else if user.Role == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "Please specify a role",
-		})
 	} else {
+		// Admin and staff accounts are created through invitations only.
+		user.Role = models.RoleUser
 		user.Id = utils.IDGenerator()
 		user.Password, _ = middleware.HashPassword(user.Password)
 
@@ -71,8 +69,33 @@ func Login(ctx *gin.Context) {
 		} else {
 			var data models.LoggedIn
 			idStr := strconv.Itoa(userData.Id)
+			now := time.Now()
+
+			session := models.Session{
+				Id:         utils.IDGenerator(),
+				UserId:     userData.Id,
+				UserAgent:  ctx.Request.UserAgent(),
+				IpAddress:  ctx.ClientIP(),
+				CreatedAt:  now,
+				LastSeenAt: now,
+			}
+
+			refreshToken, refreshTokenHash, e := middleware.GenerateRefreshToken()
+			if e != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"error": e.Error(),
+				})
+				return
+			}
+
+			refreshTokenRow := models.RefreshToken{
+				Id:        utils.IDGenerator(),
+				TokenHash: refreshTokenHash,
+				CreatedAt: now,
+				ExpiresAt: now.Add(middleware.RefreshTokenTTL),
+			}
 
-			accessToken, e := middleware.GenerateJwt(idStr, userData.Role)
+			accessToken, e := middleware.GenerateJwt(idStr, userData.Role, strconv.Itoa(session.Id))
 
 			if e != nil {
 				ctx.JSON(http.StatusBadRequest, gin.H{
@@ -82,10 +105,13 @@ func Login(ctx *gin.Context) {
 				data.Id = userData.Id
 				data.Username = userData.Username
 				data.Role = userData.Role
+				data.SessionId = session.Id
 				data.AccessToken = accessToken
-				data.TokenExpirationTime = time.Now().Add(time.Hour * 1)
+				data.TokenExpirationTime = now.Add(middleware.AccessTokenTTL)
+				data.RefreshToken = refreshToken
+				data.RefreshTokenExpirationTime = refreshTokenRow.ExpiresAt
 
-				_, e := repository.AssignAccessToken(data.Id, data.AccessToken, data.TokenExpirationTime)
+				e := repository.CreateSession(session, refreshTokenRow)
 
 				if e != nil {
 					ctx.JSON(http.StatusBadRequest, gin.H{
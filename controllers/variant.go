@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"errors"
+	"golang-final-project/models"
+	"golang-final-project/repository"
+	"golang-final-project/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetItemVariants(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if variants, err := repository.GetItemVariants(id); errors.Is(err, repository.ErrItemNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, variants)
+	}
+}
+
+func PostOptionType(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.OptionTypeBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if message := validateOptionType(&input); message != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+	} else if exists, err := repository.OptionTypeExists(id, input.Name); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if exists {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "Item already has an option type with this name",
+		})
+	} else {
+		optionType := models.OptionType{
+			Id:       utils.IDGenerator(),
+			ItemId:   int(id),
+			Name:     input.Name,
+			Position: input.Position,
+			Values:   []models.OptionValue{},
+		}
+		for position, value := range input.Values {
+			optionType.Values = append(optionType.Values, models.OptionValue{
+				Value:    value,
+				Position: position,
+			})
+		}
+
+		err := repository.CreateOptionType(id, &optionType)
+
+		if errors.Is(err, repository.ErrItemNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrOptionsInUse) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusCreated, gin.H{
+				"option_type": optionType,
+			})
+		}
+	}
+}
+
+// validateOptionType trims the option type's name and values and returns
+// what is wrong with them, if anything.
+func validateOptionType(input *models.OptionTypeBody) string {
+	input.Name = strings.TrimSpace(input.Name)
+
+	if input.Name == "" || len(input.Name) > 100 {
+		return "Name must be between 1 and 100 characters"
+	} else if len(input.Values) == 0 {
+		return "Option type must have at least one value"
+	}
+
+	seen := make(map[string]bool)
+	for i, value := range input.Values {
+		value = strings.TrimSpace(value)
+		if value == "" || len(value) > 100 {
+			return "Values must be between 1 and 100 characters"
+		} else if seen[value] {
+			return "Values must be unique"
+		}
+		seen[value] = true
+		input.Values[i] = value
+	}
+
+	return ""
+}
+
+func PostOptionValue(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	optionTypeId, optionErr := strconv.ParseInt(ctx.Param("option_id"), 10, 64)
+
+	var input models.OptionValueBody
+
+	if err != nil || optionErr != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if input.Value = strings.TrimSpace(input.Value); input.Value == "" || len(input.Value) > 100 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Value must be between 1 and 100 characters",
+		})
+	} else if exists, err := repository.OptionValueExists(optionTypeId, input.Value); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if exists {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "Option type already has this value",
+		})
+	} else {
+		value := models.OptionValue{
+			Id:       utils.IDGenerator(),
+			Value:    input.Value,
+			Position: input.Position,
+		}
+
+		err := repository.AddOptionValue(id, optionTypeId, value)
+
+		if errors.Is(err, repository.ErrOptionTypeNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusCreated, gin.H{
+				"value": value,
+			})
+		}
+	}
+}
+
+func PostVariant(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.VariantBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if message := validateVariant(&input); message != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+	} else if taken, err := repository.SkuTaken(input.Sku, 0); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if taken {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "SKU has been taken",
+		})
+	} else {
+		variant := models.Variant{
+			Id:        utils.IDGenerator(),
+			Active:    input.Active == nil || *input.Active,
+			CreatedAt: time.Now(),
+		}
+
+		err := repository.CreateVariant(id, variant, input)
+		respondVariantChange(ctx, id, int64(variant.Id), err, http.StatusCreated)
+	}
+}
+
+func UpdateVariant(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	variantId, variantErr := strconv.ParseInt(ctx.Param("variant_id"), 10, 64)
+
+	var input models.VariantBody
+
+	if err != nil || variantErr != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if message := validateVariant(&input); message != "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": message,
+		})
+	} else if taken, err := repository.SkuTaken(input.Sku, variantId); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if taken {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": "SKU has been taken",
+		})
+	} else {
+		err := repository.UpdateVariant(id, variantId, input)
+		respondVariantChange(ctx, id, variantId, err, http.StatusOK)
+	}
+}
+
+// validateVariant trims the variant's SKU and returns what is wrong with the
+// body, if anything.
+func validateVariant(input *models.VariantBody) string {
+	input.Sku = strings.TrimSpace(input.Sku)
+
+	if input.Sku == "" || len(input.Sku) > 100 {
+		return "SKU must be between 1 and 100 characters"
+	} else if input.Price != nil && *input.Price < 0 {
+		return "Price cannot be negative"
+	} else {
+		return ""
+	}
+}
+
+func respondVariantChange(ctx *gin.Context, itemId, variantId int64, err error, status int) {
+	if errors.Is(err, repository.ErrItemNotFound) || errors.Is(err, repository.ErrVariantNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	} else if errors.Is(err, repository.ErrInvalidVariantOptions) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	} else if errors.Is(err, repository.ErrDuplicateVariant) || errors.Is(err, repository.ErrVariantOptionsFixed) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if variant, err := repository.GetVariantById(itemId, variantId); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(status, gin.H{
+			"variant": variant,
+		})
+	}
+}
+
+func UpdateVariantImages(ctx *gin.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	variantId, variantErr := strconv.ParseInt(ctx.Param("variant_id"), 10, 64)
+
+	var input models.VariantImagesBody
+
+	if err != nil || variantErr != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else {
+		err := repository.SetVariantImages(id, variantId, input.ImageIds)
+
+		if errors.Is(err, repository.ErrUnknownImage) {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			respondVariantChange(ctx, id, variantId, err, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"errors"
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+	"golang-final-project/repository"
+	"golang-final-project/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetWarehouses(ctx *gin.Context) {
+	warehouses, err := repository.GetWarehouses()
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"warehouses": warehouses,
+		})
+	}
+}
+
+func PostWarehouse(ctx *gin.Context) {
+	var input models.WarehouseBody
+
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if input.Code == "" || input.Name == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Warehouse code and name cannot be empty",
+		})
+	} else if !validGeoPoint(input.Location) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Location must have a latitude between -90 and 90 and a longitude between -180 and 180",
+		})
+	} else {
+		exists, err := repository.WarehouseExists(input.Code)
+
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else if exists {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": "Warehouse code has been taken",
+			})
+		} else {
+			warehouse := models.Warehouse{
+				Id:        utils.IDGenerator(),
+				Code:      input.Code,
+				Name:      input.Name,
+				Location:  input.Location,
+				Priority:  input.Priority,
+				Active:    input.Active == nil || *input.Active,
+				CreatedAt: time.Now(),
+			}
+
+			if err := repository.CreateWarehouse(warehouse); err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
+			} else {
+				ctx.JSON(http.StatusCreated, gin.H{
+					"warehouse": warehouse,
+				})
+			}
+		}
+	}
+}
+
+func UpdateWarehouse(ctx *gin.Context) {
+	idParam := ctx.Param("id")
+	id, err := strconv.ParseInt(idParam, 10, 64)
+
+	var input models.WarehouseBody
+
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid ID",
+		})
+	} else if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if input.Name == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Warehouse name cannot be empty",
+		})
+	} else if !validGeoPoint(input.Location) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Location must have a latitude between -90 and 90 and a longitude between -180 and 180",
+		})
+	} else {
+		err := repository.UpdateWarehouse(id, input)
+
+		if errors.Is(err, repository.ErrWarehouseNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrWarehouseDefault) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else if warehouse, err := repository.GetWarehouseById(id); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message":   "Warehouse was successfully updated",
+				"warehouse": warehouse,
+			})
+		}
+	}
+}
+
+func validGeoPoint(point *models.GeoPoint) bool {
+	return point == nil || (point.Latitude >= -90 && point.Latitude <= 90 && point.Longitude >= -180 && point.Longitude <= 180)
+}
+
+func PostStockTransfer(ctx *gin.Context) {
+	var input models.StockTransferBody
+
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid input",
+			"details": err.Error(),
+		})
+	} else if input.Quantity <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Quantity must be greater than zero",
+		})
+	} else if input.FromWarehouseId == input.ToWarehouseId {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Stock can only be transferred between two different warehouses",
+		})
+	} else if input.Reason == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "Reason cannot be empty",
+		})
+	} else {
+		movements, err := repository.TransferStock(input, &middleware.CurrentPrincipal(ctx).UserId)
+
+		if errors.Is(err, repository.ErrItemNotFound) || errors.Is(err, repository.ErrVariantNotFound) || errors.Is(err, repository.ErrWarehouseNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+		} else if errors.Is(err, repository.ErrWarehouseInactive) || errors.Is(err, repository.ErrInsufficientStock) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to transfer stock",
+				"details": err.Error(),
+			})
+		} else {
+			ctx.JSON(http.StatusCreated, gin.H{
+				"message":   "Stock was successfully transferred",
+				"movements": movements,
+			})
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"errors"
+	"golang-final-project/lifecycle"
+	"golang-final-project/models"
+	"golang-final-project/payment"
+	"golang-final-project/repository"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReceivePaymentWebhook handles the asynchronous callbacks of payment
+// providers. Events are only acted on once their signature checks out, and
+// every verified event is stored whether or not it could be applied.
+func ReceivePaymentWebhook(ctx *gin.Context) {
+	providerName := ctx.Param("provider")
+
+	if _, err := payment.Get(providerName); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+	} else if secret, err := payment.WebhookSecret(providerName); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if body, err := ctx.GetRawData(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+	} else if err := payment.VerifyWebhook(secret, ctx.GetHeader(payment.WebhookSignatureHeader), body, time.Now()); err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
+	} else if event, err := payment.ParseEvent(body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid event",
+			"details": err.Error(),
+		})
+	} else {
+		record := models.PaymentEvent{
+			Provider:   providerName,
+			EventId:    event.Id,
+			Type:       event.Type,
+			Reference:  event.Reference,
+			Payload:    body,
+			ReceivedAt: time.Now(),
+		}
+
+		inserted, err := repository.RecordPaymentEvent(&record)
+
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else if !inserted {
+			ctx.JSON(http.StatusOK, gin.H{
+				"message": "Event was already received",
+			})
+		} else {
+			status, note := applyPaymentEvent(providerName, event)
+
+			if err := repository.FinishPaymentEvent(record.Id, status, note); err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
+			} else if status == models.PaymentEventFailed {
+				// Lets the provider retry, RecordPaymentEvent accepts the
+				// same event again after a failure
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"error": note,
+				})
+			} else {
+				ctx.JSON(http.StatusOK, gin.H{
+					"message": "Event received",
+					"status":  status,
+				})
+			}
+		}
+	}
+}
+
+// applyPaymentEvent drives the payment and its order from the event and
+// returns how that went. Events that don't fit the current state of the order
+// are reported as out of order instead of being forced through.
+func applyPaymentEvent(providerName string, event payment.Event) (string, string) {
+	switch event.Type {
+	case payment.EventPaymentSucceeded, payment.EventPaymentFailed, payment.EventPaymentRefunded, payment.EventPaymentDisputed:
+	default:
+		return models.PaymentEventUnknownType, ""
+	}
+
+	attempt, err := repository.GetPaymentByReference(providerName, event.Reference)
+	if errors.Is(err, repository.ErrPaymentNotFound) {
+		return models.PaymentEventUnmatched, err.Error()
+	} else if err != nil {
+		return models.PaymentEventFailed, err.Error()
+	}
+
+	id := int64(attempt.Id)
+
+	switch event.Type {
+	case payment.EventPaymentSucceeded:
+		if attempt.Status == models.PaymentStatusCaptured {
+			return models.PaymentEventProcessed, "payment was already captured"
+		}
+		// The provider has already taken the money, there's nothing to capture
+		err = repository.CompletePayment(id, nil, nil, refundThroughProvider)
+	case payment.EventPaymentFailed:
+		err = repository.FailPayment(id, event.Message)
+	case payment.EventPaymentRefunded:
+		if attempt.Status == models.PaymentStatusRefunded {
+			return models.PaymentEventProcessed, "payment was already refunded"
+		}
+		// The provider has already given the money back, what is left of the
+		// order is refunded and restocked without calling it again
+		body := models.RefundBody{Reason: "refunded by payment provider"}
+		_, err = repository.RefundOrder(int64(attempt.OrderId), body, nil, models.OrderStatusRefunded, nil)
+	case payment.EventPaymentDisputed:
+		err = repository.SettlePayment(id, models.OrderStatusDisputed, models.PaymentStatusDisputed, nil, "disputed by cardholder")
+	}
+
+	if errors.Is(err, repository.ErrPaymentCaptured) {
+		// Handled all the same, the payment has been refunded
+		return models.PaymentEventProcessed, err.Error()
+	} else if errors.Is(err, lifecycle.ErrIllegalTransition) ||
+		errors.Is(err, repository.ErrPaymentOutOfOrder) ||
+		errors.Is(err, repository.ErrNoCapturedPayment) ||
+		errors.Is(err, repository.ErrNothingToRefund) {
+		return models.PaymentEventOutOfOrder, err.Error()
+	} else if err != nil {
+		return models.PaymentEventFailed, err.Error()
+	} else {
+		return models.PaymentEventProcessed, ""
+	}
+}
+
+func GetPaymentEvents(ctx *gin.Context) {
+	events, err := repository.GetPaymentEvents(ctx.Query("status"))
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else {
+		ctx.JSON(http.StatusOK, gin.H{
+			"events": events,
+		})
+	}
+}
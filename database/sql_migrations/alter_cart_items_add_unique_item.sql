@@ -0,0 +1,19 @@
+-- +migrate Up
+UPDATE cart_items ci
+SET quantity = d.quantity
+FROM (
+    SELECT MIN(id) AS id, SUM(quantity) AS quantity
+    FROM cart_items
+    GROUP BY cart_id, item_id
+    HAVING COUNT(*) > 1
+) d
+WHERE ci.id = d.id;
+
+DELETE FROM cart_items ci
+USING cart_items kept
+WHERE ci.cart_id = kept.cart_id AND ci.item_id = kept.item_id AND ci.id > kept.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS cart_items_cart_id_item_id_key ON cart_items (cart_id, item_id);
+
+-- +migrate Down
+DROP INDEX IF EXISTS cart_items_cart_id_item_id_key;
@@ -0,0 +1,34 @@
+-- +migrate Up
+ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS unit_price INT;
+
+UPDATE cart_items ci
+SET unit_price = i.price
+FROM items i
+WHERE i.id = ci.item_id AND ci.unit_price IS NULL;
+
+ALTER TABLE cart_items ALTER COLUMN unit_price SET NOT NULL;
+
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS subtotal INT NOT NULL DEFAULT 0;
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS discount INT NOT NULL DEFAULT 0;
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS tax INT NOT NULL DEFAULT 0;
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS shipping INT NOT NULL DEFAULT 0;
+
+UPDATE carts c
+SET subtotal = s.subtotal
+FROM (
+    SELECT cart_id, SUM(unit_price * quantity) AS subtotal
+    FROM cart_items
+    GROUP BY cart_id
+) s
+WHERE s.cart_id = c.id;
+
+-- Unpaid carts were priced by the client, paid ones keep what was charged.
+UPDATE carts SET total_price = subtotal WHERE payment_status IS DISTINCT FROM 'Paid';
+
+-- +migrate Down
+ALTER TABLE carts DROP COLUMN IF EXISTS shipping;
+ALTER TABLE carts DROP COLUMN IF EXISTS tax;
+ALTER TABLE carts DROP COLUMN IF EXISTS discount;
+ALTER TABLE carts DROP COLUMN IF EXISTS subtotal;
+
+ALTER TABLE cart_items DROP COLUMN IF EXISTS unit_price;
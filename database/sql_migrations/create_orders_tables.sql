@@ -0,0 +1,125 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS orders (
+    id BIGINT PRIMARY KEY NOT NULL,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    status VARCHAR(50) NOT NULL,
+    payment_method VARCHAR(255),
+    subtotal INT NOT NULL,
+    discount INT NOT NULL,
+    tax INT NOT NULL,
+    shipping INT NOT NULL,
+    total_price INT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    paid_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id);
+
+CREATE TABLE IF NOT EXISTS order_lines (
+    id BIGINT PRIMARY KEY NOT NULL,
+    order_id BIGINT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    item_id BIGINT REFERENCES items(id) ON DELETE SET NULL,
+    item_name VARCHAR(500) NOT NULL,
+    unit_price INT NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    subtotal INT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_lines_order_id_idx ON order_lines (order_id);
+
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION prevent_order_line_update() RETURNS trigger AS $$
+BEGIN
+    -- Deleting an item only unlinks it, the snapshot itself stays untouched
+    IF NEW.item_id IS NULL AND OLD.item_id IS NOT NULL
+        AND (NEW.order_id, NEW.item_name, NEW.unit_price, NEW.quantity, NEW.subtotal)
+            = (OLD.order_id, OLD.item_name, OLD.unit_price, OLD.quantity, OLD.subtotal) THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'order lines cannot be changed once the order has been placed';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER order_lines_immutable
+BEFORE UPDATE ON order_lines
+FOR EACH ROW EXECUTE FUNCTION prevent_order_line_update();
+
+-- Carts used to double as orders, the ones paid for that way are orders
+-- already and move over. Every other cart stays a cart.
+INSERT INTO orders (
+    id, user_id, status, payment_method,
+    subtotal, discount, tax, shipping, total_price,
+    created_at, updated_at, paid_at
+)
+SELECT
+    id, user_id, 'paid', payment_method,
+    subtotal, discount, tax, shipping, COALESCE(total_price, subtotal),
+    created_at, created_at, created_at
+FROM carts
+WHERE payment_status = 'Paid';
+
+INSERT INTO order_lines (id, order_id, item_id, item_name, unit_price, quantity, subtotal)
+SELECT ci.id, ci.cart_id, ci.item_id, i.item_name, ci.unit_price, ci.quantity, ci.unit_price * ci.quantity
+FROM cart_items ci
+JOIN carts c ON c.id = ci.cart_id
+JOIN items i ON i.id = ci.item_id
+WHERE c.payment_status = 'Paid';
+
+DELETE FROM carts WHERE payment_status = 'Paid';
+
+-- A user keeps a single cart from now on, the newest one, which takes over
+-- the lines of the user's other carts.
+CREATE TEMPORARY TABLE merged_cart_items ON COMMIT DROP AS
+WITH kept AS (
+    SELECT id, FIRST_VALUE(id) OVER (PARTITION BY user_id ORDER BY created_at DESC, id DESC) AS cart_id
+    FROM carts
+)
+SELECT
+    MIN(ci.id) AS id,
+    k.cart_id,
+    ci.item_id,
+    SUM(ci.quantity) AS quantity,
+    (ARRAY_AGG(ci.unit_price ORDER BY ci.cart_id = k.cart_id DESC, ci.id DESC))[1] AS unit_price
+FROM cart_items ci
+JOIN kept k ON k.id = ci.cart_id
+GROUP BY k.cart_id, ci.item_id;
+
+DELETE FROM carts c
+WHERE EXISTS (
+    SELECT 1 FROM carts newer
+    WHERE newer.user_id = c.user_id
+        AND (newer.created_at, newer.id) > (c.created_at, c.id)
+);
+
+DELETE FROM cart_items;
+
+INSERT INTO cart_items (id, cart_id, item_id, quantity, unit_price)
+SELECT id, cart_id, item_id, quantity, unit_price
+FROM merged_cart_items;
+
+UPDATE carts c
+SET subtotal = s.subtotal, total_price = s.subtotal
+FROM (
+    SELECT cart_id, SUM(unit_price * quantity) AS subtotal
+    FROM cart_items
+    GROUP BY cart_id
+) s
+WHERE s.cart_id = c.id;
+
+ALTER TABLE carts DROP COLUMN IF EXISTS payment_method;
+ALTER TABLE carts DROP COLUMN IF EXISTS payment_status;
+
+CREATE UNIQUE INDEX IF NOT EXISTS carts_user_id_key ON carts (user_id);
+
+-- +migrate Down
+DROP INDEX IF EXISTS carts_user_id_key;
+
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS payment_method VARCHAR(255);
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS payment_status VARCHAR(255);
+
+DROP TABLE IF EXISTS order_lines;
+DROP TABLE IF EXISTS orders;
+DROP FUNCTION IF EXISTS prevent_order_line_update();
@@ -0,0 +1,22 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS payments (
+    id BIGINT PRIMARY KEY NOT NULL,
+    order_id BIGINT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    reference VARCHAR(255),
+    status VARCHAR(50) NOT NULL,
+    amount INT NOT NULL,
+    action_url TEXT,
+    message TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS payments_order_id_idx ON payments (order_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS payments_provider_reference_key
+ON payments (provider, reference)
+WHERE reference IS NOT NULL;
+
+-- +migrate Down
+DROP TABLE IF EXISTS payments;
@@ -0,0 +1,27 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS refunds (
+    id BIGINT PRIMARY KEY NOT NULL,
+    order_id BIGINT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    payment_id BIGINT NOT NULL REFERENCES payments(id),
+    amount INT NOT NULL CHECK (amount > 0),
+    reason TEXT NOT NULL DEFAULT '',
+    operator_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refunds_order_id_idx ON refunds (order_id);
+
+CREATE TABLE IF NOT EXISTS refund_lines (
+    id BIGINT PRIMARY KEY NOT NULL,
+    refund_id BIGINT NOT NULL REFERENCES refunds(id) ON DELETE CASCADE,
+    order_line_id BIGINT NOT NULL REFERENCES order_lines(id) ON DELETE CASCADE,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    amount INT NOT NULL,
+    restocked BOOLEAN NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refund_lines_order_line_id_idx ON refund_lines (order_line_id);
+
+-- +migrate Down
+DROP TABLE IF EXISTS refund_lines;
+DROP TABLE IF EXISTS refunds;
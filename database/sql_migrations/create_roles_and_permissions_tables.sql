@@ -0,0 +1,59 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS roles (
+    id BIGINT PRIMARY KEY NOT NULL,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id BIGINT PRIMARY KEY NOT NULL,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id BIGINT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+INSERT INTO roles (id, name, description) VALUES
+    (1, 'admin', 'Full access to the store'),
+    (2, 'staff', 'Manages the catalogue and can see every cart'),
+    (3, 'user', 'Customer account');
+
+INSERT INTO permissions (id, name, description) VALUES
+    (1, 'items:read', 'Browse items'),
+    (2, 'items:write', 'Create, update and delete items'),
+    (3, 'carts:read', 'Read own carts'),
+    (4, 'carts:write', 'Create and delete own carts'),
+    (5, 'carts:read:any', 'Read carts of any user'),
+    (6, 'carts:write:any', 'Delete carts of any user'),
+    (7, 'orders:pay', 'Pay for own orders'),
+    (8, 'orders:refund', 'Refund and cancel orders'),
+    (9, 'sessions:revoke', 'Revoke sessions of any user'),
+    (10, 'roles:manage', 'Manage roles, permissions and user roles');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 1, id FROM permissions;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 2, id FROM permissions
+WHERE name IN ('items:read', 'items:write', 'carts:read', 'carts:write', 'carts:read:any', 'orders:pay');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 3, id FROM permissions
+WHERE name IN ('items:read', 'carts:read', 'carts:write', 'orders:pay');
+
+-- Every role other than "user" used to be treated as an admin.
+UPDATE users SET role = 'admin' WHERE role <> 'user';
+
+ALTER TABLE users
+ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles(name);
+
+-- +migrate Down
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_fkey;
+
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
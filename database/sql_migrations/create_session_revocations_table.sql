@@ -0,0 +1,14 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS session_revocations (
+    id BIGSERIAL PRIMARY KEY,
+    session_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    revoked_by BIGINT,
+    reason VARCHAR(255) NOT NULL,
+    revoked_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS session_revocations_user_id_idx ON session_revocations (user_id);
+
+-- +migrate Down
+DROP TABLE IF EXISTS session_revocations;
@@ -0,0 +1,22 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id BIGSERIAL PRIMARY KEY,
+    item_id BIGINT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL,
+    delta INT NOT NULL,
+    stock_after INT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    actor_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    reference_id VARCHAR(255),
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS stock_movements_item_id_idx ON stock_movements (item_id, created_at);
+
+-- The ledger starts from whatever stock items have today.
+INSERT INTO stock_movements (item_id, kind, delta, stock_after, reason, created_at)
+SELECT id, 'initial', stock, stock, 'opening balance', NOW()
+FROM items;
+
+-- +migrate Down
+DROP TABLE IF EXISTS stock_movements;
@@ -0,0 +1,25 @@
+-- +migrate Up
+ALTER TABLE items ADD COLUMN IF NOT EXISTS reserved_stock INT NOT NULL DEFAULT 0;
+ALTER TABLE items ADD CONSTRAINT items_reserved_stock_check CHECK (reserved_stock >= 0);
+
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    id BIGSERIAL PRIMARY KEY,
+    order_id BIGINT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    item_id BIGINT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    status VARCHAR(50) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    closed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS stock_reservations_order_id_idx ON stock_reservations (order_id);
+CREATE INDEX IF NOT EXISTS stock_reservations_active_expires_at_idx
+ON stock_reservations (expires_at)
+WHERE status = 'active';
+
+-- +migrate Down
+DROP TABLE IF EXISTS stock_reservations;
+
+ALTER TABLE items DROP CONSTRAINT IF EXISTS items_reserved_stock_check;
+ALTER TABLE items DROP COLUMN IF EXISTS reserved_stock;
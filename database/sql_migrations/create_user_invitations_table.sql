@@ -0,0 +1,20 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS user_invitations (
+    id BIGINT PRIMARY KEY NOT NULL,
+    role VARCHAR(255) NOT NULL REFERENCES roles(name),
+    created_by BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    accepted_at TIMESTAMP
+);
+
+INSERT INTO permissions (id, name, description) VALUES
+    (11, 'users:invite', 'Invite admin and staff accounts');
+
+INSERT INTO role_permissions (role_id, permission_id) VALUES (1, 11);
+
+-- +migrate Down
+DELETE FROM permissions WHERE id = 11;
+
+DROP TABLE IF EXISTS user_invitations;
@@ -0,0 +1,61 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS warehouses (
+    id BIGINT PRIMARY KEY NOT NULL,
+    code VARCHAR(50) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    priority INT NOT NULL DEFAULT 0,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS warehouses_default_key ON warehouses (is_default) WHERE is_default;
+
+INSERT INTO warehouses (id, code, name, priority, is_default, created_at)
+VALUES (1, 'MAIN', 'Main warehouse', 0, TRUE, NOW());
+
+-- items.stock and items.reserved_stock stay as the totals over all
+-- warehouses, warehouse_stock holds the split.
+CREATE TABLE IF NOT EXISTS warehouse_stock (
+    warehouse_id BIGINT NOT NULL REFERENCES warehouses(id),
+    item_id BIGINT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    stock INT NOT NULL DEFAULT 0,
+    reserved_stock INT NOT NULL DEFAULT 0 CHECK (reserved_stock >= 0),
+    PRIMARY KEY (warehouse_id, item_id)
+);
+
+CREATE INDEX IF NOT EXISTS warehouse_stock_item_id_idx ON warehouse_stock (item_id);
+
+INSERT INTO warehouse_stock (warehouse_id, item_id, stock, reserved_stock)
+SELECT 1, id, stock, reserved_stock FROM items;
+
+ALTER TABLE stock_reservations ADD COLUMN IF NOT EXISTS warehouse_id BIGINT REFERENCES warehouses(id);
+UPDATE stock_reservations SET warehouse_id = 1;
+ALTER TABLE stock_reservations ALTER COLUMN warehouse_id SET NOT NULL;
+
+ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS warehouse_id BIGINT REFERENCES warehouses(id);
+UPDATE stock_movements SET warehouse_id = 1;
+
+CREATE TABLE IF NOT EXISTS order_line_allocations (
+    id BIGSERIAL PRIMARY KEY,
+    order_line_id BIGINT NOT NULL REFERENCES order_lines(id) ON DELETE CASCADE,
+    warehouse_id BIGINT NOT NULL REFERENCES warehouses(id),
+    quantity INT NOT NULL CHECK (quantity > 0)
+);
+
+CREATE INDEX IF NOT EXISTS order_line_allocations_order_line_id_idx ON order_line_allocations (order_line_id);
+
+-- Everything ordered so far was shipped from the one location there was.
+INSERT INTO order_line_allocations (order_line_id, warehouse_id, quantity)
+SELECT id, 1, quantity FROM order_lines;
+
+-- +migrate Down
+DROP TABLE IF EXISTS order_line_allocations;
+
+ALTER TABLE stock_movements DROP COLUMN IF EXISTS warehouse_id;
+ALTER TABLE stock_reservations DROP COLUMN IF EXISTS warehouse_id;
+
+DROP TABLE IF EXISTS warehouse_stock;
+DROP TABLE IF EXISTS warehouses;
@@ -0,0 +1,13 @@
+-- +migrate Up
+-- Kept apart from create_stock_movements_table.sql so the grant always runs
+-- after the permissions table is created
+INSERT INTO permissions (id, name, description) VALUES
+    (16, 'inventory:manage', 'Adjust stock and read the inventory ledger')
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id) VALUES
+    (1, 16)
+ON CONFLICT DO NOTHING;
+
+-- +migrate Down
+DELETE FROM permissions WHERE id = 16;
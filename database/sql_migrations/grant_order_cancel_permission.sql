@@ -0,0 +1,15 @@
+-- +migrate Up
+-- Kept apart from create_refunds_tables.sql, which runs before the
+-- permissions table exists on a fresh database
+INSERT INTO permissions (id, name, description) VALUES
+    (15, 'orders:cancel', 'Cancel own orders before fulfilment')
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id) VALUES
+    (1, 15),
+    (2, 15),
+    (3, 15)
+ON CONFLICT DO NOTHING;
+
+-- +migrate Down
+DELETE FROM permissions WHERE id = 15;
@@ -0,0 +1,14 @@
+-- +migrate Up
+INSERT INTO permissions (id, name, description) VALUES
+    (12, 'orders:read', 'Read own orders'),
+    (13, 'orders:read:any', 'Read orders of any user');
+
+INSERT INTO role_permissions (role_id, permission_id) VALUES
+    (1, 12),
+    (1, 13),
+    (2, 12),
+    (2, 13),
+    (3, 12);
+
+-- +migrate Down
+DELETE FROM permissions WHERE id IN (12, 13);
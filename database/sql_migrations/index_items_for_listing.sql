@@ -0,0 +1,21 @@
+-- +migrate Up
+-- One index per sort order of the item listing, each ending in id so the
+-- cursor comparison (sort value, id) can seek straight to the page.
+CREATE INDEX IF NOT EXISTS items_item_name_id_idx ON items (item_name, id);
+CREATE INDEX IF NOT EXISTS items_price_id_idx ON items (price, id);
+CREATE INDEX IF NOT EXISTS items_created_at_id_idx ON items (created_at, id);
+CREATE INDEX IF NOT EXISTS items_available_stock_id_idx ON items ((GREATEST(stock - reserved_stock, 0)), id);
+CREATE INDEX IF NOT EXISTS items_created_by_idx ON items (created_by);
+CREATE INDEX IF NOT EXISTS items_modified_at_idx ON items (modified_at);
+
+CREATE INDEX IF NOT EXISTS items_images_item_id_idx ON items_images (item_id);
+
+-- +migrate Down
+DROP INDEX IF EXISTS items_images_item_id_idx;
+
+DROP INDEX IF EXISTS items_modified_at_idx;
+DROP INDEX IF EXISTS items_created_by_idx;
+DROP INDEX IF EXISTS items_available_stock_id_idx;
+DROP INDEX IF EXISTS items_created_at_id_idx;
+DROP INDEX IF EXISTS items_price_id_idx;
+DROP INDEX IF EXISTS items_item_name_id_idx;
@@ -0,0 +1,21 @@
+-- +migrate Up
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Names weigh more than descriptions when ranking. The column is generated,
+-- so it can't fall behind the item it's computed from.
+ALTER TABLE items ADD COLUMN IF NOT EXISTS search_vector tsvector
+GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', item_name), 'A') ||
+    setweight(to_tsvector('english', COALESCE(description, '')), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS items_search_vector_idx ON items USING GIN (search_vector);
+
+-- Typo tolerant matching on the name with pg_trgm's % and <% operators.
+CREATE INDEX IF NOT EXISTS items_item_name_trgm_idx ON items USING GIN (item_name gin_trgm_ops);
+
+-- +migrate Down
+DROP INDEX IF EXISTS items_item_name_trgm_idx;
+DROP INDEX IF EXISTS items_search_vector_idx;
+
+ALTER TABLE items DROP COLUMN IF EXISTS search_vector;
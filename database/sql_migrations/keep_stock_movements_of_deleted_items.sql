@@ -0,0 +1,14 @@
+-- +migrate Up
+-- The ledger outlives the items it records, deleting an item only detaches
+-- its movements
+ALTER TABLE stock_movements ALTER COLUMN item_id DROP NOT NULL;
+ALTER TABLE stock_movements DROP CONSTRAINT IF EXISTS stock_movements_item_id_fkey;
+ALTER TABLE stock_movements
+    ADD CONSTRAINT stock_movements_item_id_fkey FOREIGN KEY (item_id) REFERENCES items(id) ON DELETE SET NULL;
+
+-- +migrate Down
+DELETE FROM stock_movements WHERE item_id IS NULL;
+ALTER TABLE stock_movements DROP CONSTRAINT IF EXISTS stock_movements_item_id_fkey;
+ALTER TABLE stock_movements
+    ADD CONSTRAINT stock_movements_item_id_fkey FOREIGN KEY (item_id) REFERENCES items(id) ON DELETE CASCADE;
+ALTER TABLE stock_movements ALTER COLUMN item_id SET NOT NULL;
@@ -0,0 +1,28 @@
+-- +migrate Up
+ALTER TABLE items_images ADD COLUMN IF NOT EXISTS position INT NOT NULL DEFAULT 0;
+ALTER TABLE items_images ADD COLUMN IF NOT EXISTS is_primary BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Existing images keep their upload order and the first one becomes primary
+UPDATE items_images ii SET position = ranked.position, is_primary = ranked.position = 0
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY item_id ORDER BY id) - 1 AS position
+    FROM items_images
+) ranked
+WHERE ranked.id = ii.id;
+
+CREATE INDEX IF NOT EXISTS items_images_item_id_position_idx ON items_images (item_id, position);
+CREATE UNIQUE INDEX IF NOT EXISTS items_images_primary_key ON items_images (item_id) WHERE is_primary;
+
+-- Deleting an item takes its images with it, the files are removed by the
+-- application
+ALTER TABLE items_images DROP CONSTRAINT IF EXISTS items_images_item_id_fkey;
+ALTER TABLE items_images ADD CONSTRAINT items_images_item_id_fkey FOREIGN KEY (item_id) REFERENCES items(id) ON DELETE CASCADE;
+
+-- +migrate Down
+ALTER TABLE items_images DROP CONSTRAINT IF EXISTS items_images_item_id_fkey;
+ALTER TABLE items_images ADD CONSTRAINT items_images_item_id_fkey FOREIGN KEY (item_id) REFERENCES items(id);
+
+DROP INDEX IF EXISTS items_images_primary_key;
+DROP INDEX IF EXISTS items_images_item_id_position_idx;
+ALTER TABLE items_images DROP COLUMN IF EXISTS is_primary;
+ALTER TABLE items_images DROP COLUMN IF EXISTS position;
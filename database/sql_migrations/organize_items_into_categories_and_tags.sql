@@ -0,0 +1,47 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS categories (
+    id BIGINT PRIMARY KEY NOT NULL,
+    parent_id BIGINT REFERENCES categories(id),
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(255) NOT NULL UNIQUE,
+    position INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    CHECK (parent_id <> id)
+);
+
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id, position);
+
+ALTER TABLE items ADD COLUMN IF NOT EXISTS category_id BIGINT REFERENCES categories(id);
+
+CREATE INDEX IF NOT EXISTS items_category_id_idx ON items (category_id);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id BIGINT PRIMARY KEY NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    slug VARCHAR(100) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS item_tags (
+    item_id BIGINT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    tag_id BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (item_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS item_tags_tag_id_idx ON item_tags (tag_id);
+
+INSERT INTO permissions (id, name, description) VALUES
+    (17, 'catalog:manage', 'Create, update and delete categories and tags');
+
+INSERT INTO role_permissions (role_id, permission_id) VALUES
+    (1, 17);
+
+-- +migrate Down
+DELETE FROM permissions WHERE id = 17;
+
+DROP TABLE IF EXISTS item_tags;
+DROP TABLE IF EXISTS tags;
+
+ALTER TABLE items DROP COLUMN IF EXISTS category_id;
+
+DROP TABLE IF EXISTS categories;
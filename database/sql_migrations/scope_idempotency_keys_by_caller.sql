@@ -0,0 +1,17 @@
+-- +migrate Up
+-- Requests made before signing in have no user, their keys are scoped by the
+-- client address instead
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS scope VARCHAR(255);
+UPDATE idempotency_keys SET scope = 'user:' || user_id WHERE scope IS NULL;
+ALTER TABLE idempotency_keys ALTER COLUMN scope SET NOT NULL;
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (scope, idempotency_key);
+
+-- +migrate Down
+DELETE FROM idempotency_keys WHERE user_id IS NULL;
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (user_id, idempotency_key);
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS scope;
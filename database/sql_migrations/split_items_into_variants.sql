@@ -0,0 +1,136 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS option_types (
+    id BIGINT PRIMARY KEY NOT NULL,
+    item_id BIGINT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    UNIQUE (item_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS option_values (
+    id BIGINT PRIMARY KEY NOT NULL,
+    option_type_id BIGINT NOT NULL REFERENCES option_types(id) ON DELETE CASCADE,
+    value VARCHAR(100) NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    UNIQUE (option_type_id, value)
+);
+
+-- A variant is what is actually sold and stocked. stock and reserved_stock
+-- are its totals over all warehouses, items keeps the totals over all of its
+-- variants. A NULL price sells the variant at the item's price.
+CREATE TABLE IF NOT EXISTS variants (
+    id BIGINT PRIMARY KEY NOT NULL,
+    item_id BIGINT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    sku VARCHAR(100) NOT NULL UNIQUE,
+    name VARCHAR(500) NOT NULL DEFAULT '',
+    price INT CHECK (price >= 0),
+    stock INT NOT NULL DEFAULT 0,
+    reserved_stock INT NOT NULL DEFAULT 0 CHECK (reserved_stock >= 0),
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    position INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS variants_item_id_idx ON variants (item_id);
+CREATE UNIQUE INDEX IF NOT EXISTS variants_default_key ON variants (item_id) WHERE is_default;
+
+CREATE TABLE IF NOT EXISTS variant_option_values (
+    variant_id BIGINT NOT NULL REFERENCES variants(id) ON DELETE CASCADE,
+    option_value_id BIGINT NOT NULL REFERENCES option_values(id) ON DELETE CASCADE,
+    PRIMARY KEY (variant_id, option_value_id)
+);
+
+-- Every existing item becomes its own single, default variant, sharing the
+-- item's ID so the references below can be backfilled directly.
+INSERT INTO variants (id, item_id, sku, price, stock, reserved_stock, is_default, created_at)
+SELECT id, id, 'ITEM-' || id, NULL, stock, reserved_stock, TRUE, created_at FROM items;
+
+ALTER TABLE items_images ADD COLUMN IF NOT EXISTS variant_id BIGINT REFERENCES variants(id) ON DELETE SET NULL;
+
+ALTER TABLE warehouse_stock ADD COLUMN IF NOT EXISTS variant_id BIGINT REFERENCES variants(id) ON DELETE CASCADE;
+UPDATE warehouse_stock SET variant_id = item_id;
+ALTER TABLE warehouse_stock ALTER COLUMN variant_id SET NOT NULL;
+ALTER TABLE warehouse_stock DROP CONSTRAINT IF EXISTS warehouse_stock_pkey;
+ALTER TABLE warehouse_stock ADD PRIMARY KEY (warehouse_id, variant_id);
+CREATE INDEX IF NOT EXISTS warehouse_stock_variant_id_idx ON warehouse_stock (variant_id);
+
+ALTER TABLE stock_reservations ADD COLUMN IF NOT EXISTS variant_id BIGINT REFERENCES variants(id) ON DELETE CASCADE;
+UPDATE stock_reservations SET variant_id = item_id;
+ALTER TABLE stock_reservations ALTER COLUMN variant_id SET NOT NULL;
+
+ALTER TABLE stock_movements ADD COLUMN IF NOT EXISTS variant_id BIGINT REFERENCES variants(id) ON DELETE SET NULL;
+UPDATE stock_movements SET variant_id = item_id;
+
+ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS variant_id BIGINT REFERENCES variants(id) ON DELETE CASCADE;
+UPDATE cart_items SET variant_id = item_id;
+ALTER TABLE cart_items ALTER COLUMN variant_id SET NOT NULL;
+DROP INDEX IF EXISTS cart_items_cart_id_item_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS cart_items_cart_id_variant_id_key ON cart_items (cart_id, variant_id);
+
+ALTER TABLE order_lines ADD COLUMN IF NOT EXISTS variant_id BIGINT REFERENCES variants(id) ON DELETE SET NULL;
+ALTER TABLE order_lines ADD COLUMN IF NOT EXISTS sku VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE order_lines ADD COLUMN IF NOT EXISTS variant_name VARCHAR(500) NOT NULL DEFAULT '';
+
+ALTER TABLE order_lines DISABLE TRIGGER order_lines_immutable;
+UPDATE order_lines SET variant_id = item_id, sku = 'ITEM-' || item_id WHERE item_id IS NOT NULL;
+ALTER TABLE order_lines ENABLE TRIGGER order_lines_immutable;
+
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION prevent_order_line_update() RETURNS trigger AS $$
+BEGIN
+    -- Deleting an item or variant only unlinks it, the snapshot itself stays
+    -- untouched
+    IF (NEW.item_id IS NULL OR NEW.item_id = OLD.item_id)
+        AND (NEW.variant_id IS NULL OR NEW.variant_id = OLD.variant_id)
+        AND (NEW.order_id, NEW.item_name, NEW.sku, NEW.variant_name, NEW.unit_price, NEW.quantity, NEW.subtotal)
+            = (OLD.order_id, OLD.item_name, OLD.sku, OLD.variant_name, OLD.unit_price, OLD.quantity, OLD.subtotal) THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'order lines cannot be changed once the order has been placed';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+-- +migrate Down
+-- Only reversible while every item still has a single variant, the stock of
+-- several variants can't be folded back into one warehouse_stock row.
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION prevent_order_line_update() RETURNS trigger AS $$
+BEGIN
+    -- Deleting an item only unlinks it, the snapshot itself stays untouched
+    IF NEW.item_id IS NULL AND OLD.item_id IS NOT NULL
+        AND (NEW.order_id, NEW.item_name, NEW.unit_price, NEW.quantity, NEW.subtotal)
+            = (OLD.order_id, OLD.item_name, OLD.unit_price, OLD.quantity, OLD.subtotal) THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'order lines cannot be changed once the order has been placed';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+ALTER TABLE order_lines DISABLE TRIGGER order_lines_immutable;
+ALTER TABLE order_lines DROP COLUMN IF EXISTS variant_name;
+ALTER TABLE order_lines DROP COLUMN IF EXISTS sku;
+ALTER TABLE order_lines DROP COLUMN IF EXISTS variant_id;
+ALTER TABLE order_lines ENABLE TRIGGER order_lines_immutable;
+
+DROP INDEX IF EXISTS cart_items_cart_id_variant_id_key;
+ALTER TABLE cart_items DROP COLUMN IF EXISTS variant_id;
+CREATE UNIQUE INDEX IF NOT EXISTS cart_items_cart_id_item_id_key ON cart_items (cart_id, item_id);
+
+ALTER TABLE stock_movements DROP COLUMN IF EXISTS variant_id;
+ALTER TABLE stock_reservations DROP COLUMN IF EXISTS variant_id;
+
+ALTER TABLE warehouse_stock DROP CONSTRAINT IF EXISTS warehouse_stock_pkey;
+ALTER TABLE warehouse_stock DROP COLUMN IF EXISTS variant_id;
+ALTER TABLE warehouse_stock ADD PRIMARY KEY (warehouse_id, item_id);
+
+ALTER TABLE items_images DROP COLUMN IF EXISTS variant_id;
+
+DROP TABLE IF EXISTS variant_option_values;
+DROP TABLE IF EXISTS variants;
+DROP TABLE IF EXISTS option_values;
+DROP TABLE IF EXISTS option_types;
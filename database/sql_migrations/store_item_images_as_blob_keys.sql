@@ -0,0 +1,7 @@
+-- +migrate Up
+-- items_images.image_url holds the blob store key of the image, the URL is
+-- built from it by the store when the image is served.
+UPDATE items_images SET image_url = substr(image_url, length('uploads/') + 1) WHERE image_url LIKE 'uploads/%';
+
+-- +migrate Down
+UPDATE items_images SET image_url = 'uploads/' || image_url WHERE image_url NOT LIKE 'uploads/%';
@@ -0,0 +1,16 @@
+-- +migrate Up
+-- Every uploaded image is kept in several sizes, the original rendition is
+-- also what items_images.image_url points to.
+CREATE TABLE IF NOT EXISTS item_image_renditions (
+    image_id BIGINT NOT NULL REFERENCES items_images(id) ON DELETE CASCADE,
+    name VARCHAR(20) NOT NULL,
+    storage_key VARCHAR(1024) NOT NULL UNIQUE,
+    content_type VARCHAR(100) NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    size INT NOT NULL,
+    PRIMARY KEY (image_id, name)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS item_image_renditions;
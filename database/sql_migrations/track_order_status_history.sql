@@ -0,0 +1,37 @@
+-- +migrate Up
+ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN (
+    'pending', 'awaiting_payment', 'paid', 'fulfilling',
+    'shipped', 'delivered', 'cancelled', 'refunded'
+));
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    order_id BIGINT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    from_status VARCHAR(50),
+    to_status VARCHAR(50) NOT NULL,
+    actor_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_id_idx ON order_status_history (order_id, created_at);
+
+-- Orders placed before history was kept start out with a single entry for the
+-- status they are in.
+INSERT INTO order_status_history (order_id, from_status, to_status, actor_id, reason, created_at)
+SELECT id, NULL, status, NULL, 'migrated', COALESCE(paid_at, created_at)
+FROM orders;
+
+INSERT INTO permissions (id, name, description) VALUES
+    (14, 'orders:fulfil', 'Move orders through fulfilment and cancel them');
+
+INSERT INTO role_permissions (role_id, permission_id) VALUES
+    (1, 14),
+    (2, 14);
+
+-- +migrate Down
+DELETE FROM permissions WHERE id = 14;
+
+DROP TABLE IF EXISTS order_status_history;
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
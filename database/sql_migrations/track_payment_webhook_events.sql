@@ -0,0 +1,31 @@
+-- +migrate Up
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN (
+    'pending', 'awaiting_payment', 'paid', 'fulfilling',
+    'shipped', 'delivered', 'cancelled', 'refunded', 'disputed'
+));
+
+CREATE TABLE IF NOT EXISTS payment_webhook_events (
+    id BIGSERIAL PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    event_id VARCHAR(255) NOT NULL,
+    event_type VARCHAR(100) NOT NULL,
+    reference VARCHAR(255),
+    status VARCHAR(50),
+    note TEXT,
+    payload JSONB NOT NULL,
+    received_at TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP,
+    UNIQUE (provider, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS payment_webhook_events_status_idx ON payment_webhook_events (status);
+
+-- +migrate Down
+DROP TABLE IF EXISTS payment_webhook_events;
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN (
+    'pending', 'awaiting_payment', 'paid', 'fulfilling',
+    'shipped', 'delivered', 'cancelled', 'refunded'
+));
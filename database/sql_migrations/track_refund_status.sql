@@ -0,0 +1,10 @@
+-- +migrate Up
+-- Refunds made so far have all gone through at the provider
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS status VARCHAR(50) NOT NULL DEFAULT 'succeeded';
+
+-- A pending refund is completed before another one of the order is started
+CREATE UNIQUE INDEX IF NOT EXISTS refunds_pending_order_key ON refunds (order_id) WHERE status = 'pending';
+
+-- +migrate Down
+DROP INDEX IF EXISTS refunds_pending_order_key;
+ALTER TABLE refunds DROP COLUMN IF EXISTS status;
@@ -2,7 +2,10 @@ module golang-final-project
 
 go 1.24.4
 
-require github.com/rubenv/sql-migrate v1.8.0
+require (
+	github.com/rubenv/sql-migrate v1.8.0
+	golang.org/x/image v0.29.0
+)
 
 require (
 	github.com/bytedance/sonic v1.11.6 // indirect
@@ -0,0 +1,203 @@
+// Package imaging turns uploaded images into what the shop serves. An
+// upload is identified by its content rather than its name or declared type,
+// checked against Limits and decoded; the pixels are then encoded again as
+// JPEG, or as PNG when the image has transparency, in its full size and in
+// every one of Sizes. Re-encoding drops EXIF and any other metadata, after
+// the EXIF orientation has been applied to the pixels.
+//
+// JPEG, PNG, GIF (its first frame) and WebP are accepted. HEIC and other
+// formats browsers can't show are refused, there is no pure Go decoder for
+// them.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format, upload a JPEG, PNG, GIF or WebP image")
+	ErrFileTooLarge      = errors.New("image file is too large")
+	ErrDimensions        = errors.New("image dimensions are too large")
+	ErrCorrupt           = errors.New("image could not be decoded")
+)
+
+const (
+	RenditionOriginal = "original"
+
+	jpegQuality = 85
+)
+
+var formats = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Size is a rendition that fits the image within MaxSide pixels on its
+// longest side. Images are never scaled up, a small upload gives renditions
+// of its own size.
+type Size struct {
+	Name    string
+	MaxSide int
+}
+
+var Sizes = []Size{
+	{Name: "large", MaxSide: 1280},
+	{Name: "medium", MaxSide: 640},
+	{Name: "small", MaxSide: 320},
+	{Name: "thumb", MaxSide: 160},
+}
+
+// Limits bound what is accepted. MaxPixels is checked from the image header
+// before decoding, so a small file can't unpack into a huge bitmap.
+type Limits struct {
+	MaxFileSize  int64
+	MaxDimension int
+	MaxPixels    int
+}
+
+// DefaultLimits reads the limits from UPLOAD_MAX_FILE_SIZE (bytes, 10 MiB by
+// default), UPLOAD_MAX_DIMENSION (8000 pixels per side by default) and
+// UPLOAD_MAX_PIXELS (40 megapixels by default).
+func DefaultLimits() Limits {
+	return Limits{
+		MaxFileSize:  int64(envInt("UPLOAD_MAX_FILE_SIZE", 10<<20)),
+		MaxDimension: envInt("UPLOAD_MAX_DIMENSION", 8000),
+		MaxPixels:    envInt("UPLOAD_MAX_PIXELS", 40_000_000),
+	}
+}
+
+func envInt(name string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// Rendition is one encoded version of an image.
+type Rendition struct {
+	Name        string
+	Width       int
+	Height      int
+	ContentType string
+	Extension   string
+	Data        []byte
+}
+
+// Process checks the upload and returns its original rendition followed by
+// one rendition per Size.
+func Process(r io.Reader, limits Limits) ([]Rendition, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limits.MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	} else if int64(len(data)) > limits.MaxFileSize {
+		return nil, fmt.Errorf("%w, the limit is %d bytes", ErrFileTooLarge, limits.MaxFileSize)
+	}
+
+	contentType := http.DetectContentType(data)
+	if !formats[contentType] {
+		return nil, ErrUnsupportedFormat
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrCorrupt
+	} else if config.Width <= 0 || config.Height <= 0 ||
+		config.Width > limits.MaxDimension || config.Height > limits.MaxDimension ||
+		config.Width*config.Height > limits.MaxPixels {
+		return nil, fmt.Errorf("%w, the limit is %d pixels per side and %d pixels in total", ErrDimensions, limits.MaxDimension, limits.MaxPixels)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	img := toNRGBA(decoded)
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+
+	renditions := []Rendition{}
+
+	original, err := encode(RenditionOriginal, img)
+	if err != nil {
+		return nil, err
+	}
+	renditions = append(renditions, original)
+
+	for _, size := range Sizes {
+		rendition, err := encode(size.Name, fit(img, size.MaxSide))
+		if err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, rendition)
+	}
+
+	return renditions, nil
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+	return nrgba
+}
+
+// fit scales the image down so its longest side is at most maxSide.
+func fit(img *image.NRGBA, maxSide int) *image.NRGBA {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width <= maxSide && height <= maxSide {
+		return img
+	}
+
+	if width >= height {
+		height = max(height*maxSide/width, 1)
+		width = maxSide
+	} else {
+		width = max(width*maxSide/height, 1)
+		height = maxSide
+	}
+
+	scaled := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return scaled
+}
+
+// encode stores opaque images as JPEG and keeps transparency in PNG.
+func encode(name string, img *image.NRGBA) (Rendition, error) {
+	rendition := Rendition{
+		Name:   name,
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+	}
+
+	var buf bytes.Buffer
+	var err error
+
+	if img.Opaque() {
+		rendition.ContentType, rendition.Extension = "image/jpeg", "jpg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		rendition.ContentType, rendition.Extension = "image/png", "png"
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	}
+
+	rendition.Data = buf.Bytes()
+	return rendition, err
+}
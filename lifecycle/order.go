@@ -0,0 +1,59 @@
+// Package lifecycle holds the order state machine. Every status change goes
+// through Transition, so the rules for what may follow what live only here.
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"golang-final-project/models"
+)
+
+var ErrIllegalTransition = errors.New("illegal order status transition")
+
+var orderTransitions = map[string][]string{
+	models.OrderStatusPending:         {models.OrderStatusAwaitingPayment, models.OrderStatusCancelled},
+	models.OrderStatusAwaitingPayment: {models.OrderStatusPaid, models.OrderStatusCancelled},
+	models.OrderStatusPaid:            {models.OrderStatusFulfilling, models.OrderStatusCancelled, models.OrderStatusRefunded, models.OrderStatusDisputed},
+	models.OrderStatusFulfilling:      {models.OrderStatusShipped, models.OrderStatusRefunded, models.OrderStatusDisputed},
+	models.OrderStatusShipped:         {models.OrderStatusDelivered, models.OrderStatusRefunded, models.OrderStatusDisputed},
+	models.OrderStatusDelivered:       {models.OrderStatusRefunded, models.OrderStatusDisputed},
+	models.OrderStatusDisputed:        {models.OrderStatusRefunded},
+	models.OrderStatusCancelled:       {},
+	models.OrderStatusRefunded:        {},
+}
+
+// manualStatuses are the statuses staff may set directly. Payment, refunds
+// and cancellation move money or stock, so they only happen through their own
+// endpoints.
+var manualStatuses = map[string]bool{
+	models.OrderStatusFulfilling: true,
+	models.OrderStatusShipped:    true,
+	models.OrderStatusDelivered:  true,
+}
+
+func IsOrderStatus(status string) bool {
+	_, exists := orderTransitions[status]
+	return exists
+}
+
+func CanTransition(from string, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition returns ErrIllegalTransition, naming both statuses, unless an
+// order in from may move to to.
+func Transition(from string, to string) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w from %s to %s", ErrIllegalTransition, from, to)
+	}
+	return nil
+}
+
+func IsManualStatus(status string) bool {
+	return manualStatuses[status]
+}
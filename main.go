@@ -2,17 +2,32 @@ package main
 
 import (
 	"database/sql"
+	"flag"
 	"fmt"
 	"golang-final-project/config"
 	"golang-final-project/database"
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+	"golang-final-project/repository"
 	"golang-final-project/router"
+	"golang-final-project/storage"
+	"golang-final-project/utils"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap-admin" {
+		bootstrapAdmin(os.Args[2:])
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == "gc-uploads" {
+		collectUploads(os.Args[2:])
+		return
+	}
+
 	startServer()
 }
 
@@ -56,5 +71,153 @@ func startServer() {
 	var PORT = os.Getenv("PORT")
 
 	connectToDB()
+
+	if err := middleware.LoadJWTKeys(); err != nil {
+		panic(err)
+	}
+
+	if err := storage.Load(); err != nil {
+		panic(err)
+	}
+
+	go sweepStockReservations()
+	go sweepOrphanedUploads()
+
 	router.StartServer().Run(":" + PORT)
 }
+
+// sweepStockReservations releases the stock held for orders that weren't
+// paid in time, every STOCK_RESERVATION_SWEEP_INTERVAL (one minute by
+// default).
+func sweepStockReservations() {
+	interval, err := time.ParseDuration(os.Getenv("STOCK_RESERVATION_SWEEP_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = time.Minute
+	}
+
+	for range time.Tick(interval) {
+		count, err := repository.ReleaseExpiredReservations()
+		if err != nil {
+			fmt.Println("Failed to release expired stock reservations:", err)
+		} else if count > 0 {
+			fmt.Printf("Released %d expired stock reservations\n", count)
+		}
+	}
+}
+
+// uploadGCGrace is how old an unreferenced upload has to be before it is
+// collected, UPLOAD_GC_GRACE or a day by default.
+func uploadGCGrace() time.Duration {
+	grace, err := time.ParseDuration(os.Getenv("UPLOAD_GC_GRACE"))
+	if err != nil || grace <= 0 {
+		grace = 24 * time.Hour
+	}
+	return grace
+}
+
+// sweepOrphanedUploads deletes the uploaded files nothing refers to anymore,
+// every UPLOAD_GC_INTERVAL (six hours by default). UPLOAD_GC_INTERVAL=off
+// turns it off, leaving collection to gc-uploads.
+func sweepOrphanedUploads() {
+	if os.Getenv("UPLOAD_GC_INTERVAL") == "off" {
+		return
+	}
+
+	interval, err := time.ParseDuration(os.Getenv("UPLOAD_GC_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 6 * time.Hour
+	}
+
+	for range time.Tick(interval) {
+		orphans, freed, err := repository.CollectOrphanedUploads(uploadGCGrace(), false)
+		if err != nil {
+			fmt.Println("Failed to collect orphaned uploads:", err)
+		} else if len(orphans) > 0 {
+			fmt.Printf("Deleted %d orphaned uploads, freeing %d bytes\n", len(orphans), freed)
+		}
+	}
+}
+
+// collectUploads deletes the uploaded files nothing refers to once, listing
+// each of them. With -dry-run it only lists what would be deleted.
+//
+//	go run . gc-uploads -dry-run -grace 48h
+func collectUploads(args []string) {
+	flags := flag.NewFlagSet("gc-uploads", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what would be deleted")
+	grace := flags.Duration("grace", uploadGCGrace(), "minimum age of an unreferenced file before it is deleted")
+	flags.Parse(args)
+
+	if *grace < 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	connectToDB()
+
+	if err := storage.Load(); err != nil {
+		panic(err)
+	}
+
+	orphans, freed, err := repository.CollectOrphanedUploads(*grace, *dryRun)
+	if err != nil {
+		panic(err)
+	}
+
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+
+	for _, orphan := range orphans {
+		fmt.Printf("%s %s (%d bytes, modified %s)\n", verb, orphan.Key, orphan.Size, orphan.ModifiedAt.Format(time.RFC3339))
+	}
+	fmt.Printf("%s %d orphaned uploads, freeing %d bytes\n", verb, len(orphans), freed)
+}
+
+// bootstrapAdmin creates the very first admin account, every admin after
+// that has to be invited through /api/admin/invitations.
+//
+//	go run . bootstrap-admin -username root -password secret
+//
+// The password can also be passed through BOOTSTRAP_ADMIN_PASSWORD to keep it
+// out of the shell history.
+func bootstrapAdmin(args []string) {
+	flags := flag.NewFlagSet("bootstrap-admin", flag.ExitOnError)
+	username := flags.String("username", "", "username of the admin account")
+	password := flags.String("password", os.Getenv("BOOTSTRAP_ADMIN_PASSWORD"), "password of the admin account")
+	flags.Parse(args)
+
+	if *username == "" || *password == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	connectToDB()
+
+	count, err := repository.CountUsersWithRole(models.RoleAdmin)
+	if err != nil {
+		panic(err)
+	} else if count > 0 {
+		fmt.Println("An admin account already exists, invite new admins through /api/admin/invitations")
+		os.Exit(1)
+	}
+
+	hashedPassword, err := middleware.HashPassword(*password)
+	if err != nil {
+		panic(err)
+	}
+
+	e := repository.CreateUser(models.User{
+		Id:       utils.IDGenerator(),
+		Username: *username,
+		Password: hashedPassword,
+		Role:     models.RoleAdmin,
+	})
+	if e != "" {
+		fmt.Println(e)
+		os.Exit(1)
+	}
+
+	fmt.Println("Admin account", *username, "has been created")
+}
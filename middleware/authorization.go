@@ -1,51 +1,213 @@
 package middleware
 
 import (
+	"database/sql"
+	"errors"
 	"golang-final-project/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func isAccessTokenAssigned(token string) bool {
-	var exists bool
+const principalKey = "principal"
+
+// Principal is the authenticated caller, put in the gin.Context by
+// Authenticate. Role and permissions are read from the database on every
+// request so a role change applies without waiting for the token to expire.
+type Principal struct {
+	UserId      int
+	Role        string
+	SessionId   int
+	Permissions map[string]bool
+}
+
+func (p *Principal) Can(permission string) bool {
+	return p.Permissions[permission]
+}
+
+// lastSeenInterval is how stale a session's last_seen_at may get before a
+// request updates it, so busy sessions aren't written to on every request.
+const lastSeenInterval = time.Minute
+
+// isAccessTokenAssigned reports whether the session the token was issued for
+// still belongs to the user and hasn't been revoked, and marks it as seen
+// when it was last seen more than lastSeenInterval ago.
+func isAccessTokenAssigned(sessionId string, userId string) (bool, error) {
+	var id int64
 
 	query := `
-	SELECT EXISTS (
-		SELECT 1 FROM users WHERE token = $1
-	)`
+	WITH session AS (
+		SELECT id, last_seen_at FROM sessions
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	), seen AS (
+		UPDATE sessions
+		SET last_seen_at = $3
+		WHERE id IN (SELECT id FROM session WHERE last_seen_at < $4)
+	)
+	SELECT id FROM session`
+
+	now := time.Now()
 
 	err := config.Db.QueryRow(
 		query,
-		token,
-	).Scan(&exists)
+		sessionId,
+		userId,
+		now,
+		now.Add(-lastSeenInterval),
+	).Scan(&id)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else {
+		return true, nil
+	}
+}
 
+func loadPrincipal(claims *Claims) (*Principal, error) {
+	query := `
+	SELECT u.role, p.name
+	FROM users u
+	LEFT JOIN roles r ON r.name = u.role
+	LEFT JOIN role_permissions rp ON rp.role_id = r.id
+	LEFT JOIN permissions p ON p.id = rp.permission_id
+	WHERE u.id = $1
+	`
+
+	rows, err := config.Db.Query(query, claims.Id)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var principal *Principal
+
+	for rows.Next() {
+		var (
+			role       string
+			permission sql.NullString
+		)
+
+		if err := rows.Scan(&role, &permission); err != nil {
+			return nil, err
+		}
+
+		if principal == nil {
+			userId, _ := strconv.Atoi(claims.Id)
+			sessionId, _ := strconv.Atoi(claims.SessionId)
+
+			principal = &Principal{
+				UserId:      userId,
+				Role:        role,
+				SessionId:   sessionId,
+				Permissions: map[string]bool{},
+			}
+		}
+
+		if permission.Valid {
+			principal.Permissions[permission.String] = true
+		}
+	}
+
+	if principal == nil {
+		return nil, sql.ErrNoRows
 	} else {
-		return exists
+		return principal, rows.Err()
 	}
 }
 
-func ValidateAccessToken(ctx *gin.Context) (id string, role string, error string) {
+// validateAccessToken returns the claims of the request's access token, or
+// why it was refused. err is set when the session couldn't be looked up.
+func validateAccessToken(ctx *gin.Context) (*Claims, string, error) {
 	authHeader := ctx.GetHeader("Authorization")
 
-	if authHeader == "" {
-		return "", "", "access token is required"
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, "access token is required", nil
 	} else {
 		tokenString := authHeader[len("Bearer "):]
 
-		exists := isAccessTokenAssigned(tokenString)
+		claims, err := ValidateJWT(tokenString)
 
-		if !exists {
-			return "", "", "access token is not assigned to any user"
+		if err != nil {
+			return nil, "invalid or expired access token", nil
 		} else {
-			userId, role, err := ValidateJWT(tokenString)
+			exists, err := isAccessTokenAssigned(claims.SessionId, claims.Id)
 
 			if err != nil {
-				return "", "", "invalid or expired access token"
+				return nil, "", err
+			} else if !exists {
+				return nil, "access token is not assigned to any active session", nil
 			} else {
-				return userId, role, ""
+				return claims, "", nil
 			}
 		}
 	}
 }
+
+// Authenticate rejects requests without a valid access token with 401 and
+// stores the caller's Principal in the context for the handlers after it.
+// When the session can't be checked the request is refused with 503.
+func Authenticate() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims, accessTokenValidation, err := validateAccessToken(ctx)
+
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":   "Couldn't verify the session, please try again",
+				"details": err.Error(),
+			})
+			return
+		} else if accessTokenValidation != "" {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": accessTokenValidation,
+			})
+			return
+		}
+
+		principal, err := loadPrincipal(claims)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "access token belongs to a user that no longer exists",
+			})
+		} else if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		} else {
+			ctx.Set(principalKey, principal)
+			ctx.Next()
+		}
+	}
+}
+
+// RequirePermission rejects callers missing any of the given permissions with
+// 403. It must be registered after Authenticate.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal := CurrentPrincipal(ctx)
+
+		for _, permission := range permissions {
+			if principal == nil || !principal.Can(permission) {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "You don't have permission to perform this action",
+				})
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
+
+func CurrentPrincipal(ctx *gin.Context) *Principal {
+	if value, exists := ctx.Get(principalKey); exists {
+		return value.(*Principal)
+	} else {
+		return nil
+	}
+}
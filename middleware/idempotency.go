@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"golang-final-project/config"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyKeyTTL = 24 * time.Hour
+)
+
+type idempotentResponse struct {
+	fingerprint string
+	status      sql.NullInt64
+	contentType string
+	body        []byte
+}
+
+// responseRecorder keeps a copy of everything the handler writes so it can
+// be stored for replays.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
+
+// Idempotency makes mutating requests carrying an Idempotency-Key header safe
+// to retry. The first request with a key runs normally and its response is
+// kept for IDEMPOTENCY_KEY_TTL (24h by default); repeating the key replays
+// that response instead of running the handler again, and reusing it for a
+// different request is rejected with 422. Keys are scoped to the caller, so
+// on authenticated routes it must be registered after Authenticate; requests
+// made before signing in are scoped by the client address. Responses are
+// stored as they are, so it must not wrap routes that issue tokens.
+func Idempotency() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(IdempotencyKeyHeader)
+
+		if key == "" || !isMutating(ctx.Request.Method) {
+			ctx.Next()
+			return
+		} else if len(key) > maxIdempotencyKeyLength {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Idempotency-Key must be at most 255 characters",
+			})
+			return
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope, userId := idempotencyScope(ctx)
+		fingerprint := requestFingerprint(ctx.Request.Method, ctx.Request.URL.Path, body)
+
+		claimed, err := claimIdempotencyKey(scope, userId, key, fingerprint)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if !claimed {
+			replayIdempotentResponse(ctx, scope, key, fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: ctx.Writer}
+		ctx.Writer = recorder
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				releaseIdempotencyKey(scope, key)
+				panic(recovered)
+			}
+		}()
+
+		ctx.Next()
+
+		// Server errors are not kept, the client should be able to retry them
+		if status := recorder.Status(); status >= http.StatusInternalServerError {
+			err = releaseIdempotencyKey(scope, key)
+		} else {
+			err = storeIdempotentResponse(scope, key, status, recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+		}
+		if err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// idempotencyScope keeps the keys of different callers apart, signed in
+// callers by their user and everybody else by their address.
+func idempotencyScope(ctx *gin.Context) (string, *int) {
+	if principal := CurrentPrincipal(ctx); principal != nil {
+		return "user:" + strconv.Itoa(principal.UserId), &principal.UserId
+	}
+	return "ip:" + ctx.ClientIP(), nil
+}
+
+func isMutating(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch || method == http.MethodDelete
+}
+
+func requestFingerprint(method string, path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func idempotencyKeyTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_TTL")); err == nil && ttl > 0 {
+		return ttl
+	} else {
+		return defaultIdempotencyKeyTTL
+	}
+}
+
+// claimIdempotencyKey reserves the key for this request. A key whose window
+// has passed is taken over as if it were new.
+func claimIdempotencyKey(scope string, userId *int, key string, fingerprint string) (bool, error) {
+	var claimedKey string
+
+	now := time.Now()
+
+	query := `
+	INSERT INTO idempotency_keys (scope, user_id, idempotency_key, fingerprint, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (scope, idempotency_key) DO UPDATE
+	SET fingerprint = EXCLUDED.fingerprint,
+		status_code = NULL,
+		content_type = NULL,
+		response_body = NULL,
+		created_at = EXCLUDED.created_at,
+		expires_at = EXCLUDED.expires_at
+	WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+	RETURNING idempotency_key
+	`
+
+	err := config.Db.QueryRow(query, scope, userId, key, fingerprint, now, now.Add(idempotencyKeyTTL())).Scan(&claimedKey)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else {
+		return true, nil
+	}
+}
+
+func replayIdempotentResponse(ctx *gin.Context, scope string, key string, fingerprint string) {
+	var (
+		response    idempotentResponse
+		contentType sql.NullString
+	)
+
+	err := config.Db.QueryRow(
+		`SELECT fingerprint, status_code, content_type, response_body FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2`,
+		scope,
+		key,
+	).Scan(&response.fingerprint, &response.status, &contentType, &response.body)
+
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+	} else if response.fingerprint != fingerprint {
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "Idempotency-Key was already used for a different request",
+		})
+	} else if !response.status.Valid {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "A request with this Idempotency-Key is still being processed",
+		})
+	} else {
+		ctx.Header(IdempotentReplayedHeader, "true")
+		ctx.Data(int(response.status.Int64), contentType.String, response.body)
+		ctx.Abort()
+	}
+}
+
+func storeIdempotentResponse(scope string, key string, status int, contentType string, body []byte) error {
+	_, err := config.Db.Exec(
+		`UPDATE idempotency_keys SET status_code = $3, content_type = $4, response_body = $5 WHERE scope = $1 AND idempotency_key = $2`,
+		scope,
+		key,
+		status,
+		contentType,
+		body,
+	)
+	return err
+}
+
+func releaseIdempotencyKey(scope string, key string) error {
+	_, err := config.Db.Exec(`DELETE FROM idempotency_keys WHERE scope = $1 AND idempotency_key = $2`, scope, key)
+	return err
+}
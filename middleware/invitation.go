@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var InvitationTTL = time.Hour * 72
+
+type InvitationClaims struct {
+	InvitationId string `json:"inv"`
+	Role         string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// invitationAudience keeps invitation tokens from being accepted as access
+// tokens and the other way around.
+func invitationAudience() string {
+	return jwtAudience + "/invitations"
+}
+
+func GenerateInvitationToken(invitationId string, role string, expiresAt time.Time) (string, error) {
+	now := time.Now()
+
+	claims := InvitationClaims{
+		InvitationId: invitationId,
+		Role:         role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{invitationAudience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	token.Header["kid"] = signingKey.Id
+
+	return token.SignedString(signingKey.Private)
+}
+
+func ValidateInvitationToken(tokenString string) (*InvitationClaims, error) {
+	var claims InvitationClaims
+
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		lookupVerificationKey,
+		jwt.WithValidMethods(jwtKeyMethods),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithAudience(invitationAudience()),
+		jwt.WithExpirationRequired(),
+	)
+
+	if err != nil {
+		return nil, err
+	} else if !token.Valid || claims.InvitationId == "" {
+		return nil, fmt.Errorf("invalid invitation")
+	} else {
+		return &claims, nil
+	}
+}
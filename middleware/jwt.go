@@ -7,37 +7,59 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte("jwt_confidential")
+// AccessTokenTTL is kept short since access tokens are renewed through
+// refresh tokens rather than by logging in again.
+var AccessTokenTTL = time.Minute * 15
 
-func GenerateJwt(id string, role string) (string, error) {
-	claims := jwt.MapClaims{
-		"id":   id,
-		"role": role,
-		"exp":  time.Now().Add(time.Hour * 1).Unix(),
+type Claims struct {
+	Id        string `json:"id"`
+	Role      string `json:"role"`
+	SessionId string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+func GenerateJwt(id string, role string, sessionId string) (string, error) {
+	now := time.Now()
+
+	claims := Claims{
+		Id:        id,
+		Role:      role,
+		SessionId: sessionId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Subject:   id,
+			Audience:  jwt.ClaimStrings{jwtAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	token.Header["kid"] = signingKey.Id
 
-	return token.SignedString(jwtKey)
+	return token.SignedString(signingKey.Private)
 }
 
-func ValidateJWT(tokenString string) (string, string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		} else {
-			return jwtKey, nil
-		}
-	})
+func ValidateJWT(tokenString string) (*Claims, error) {
+	var claims Claims
 
-	if err != nil {
-		return "", "", err
-	} else if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		id := claims["id"].(string)
-		role := claims["role"].(string)
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		lookupVerificationKey,
+		jwt.WithValidMethods(jwtKeyMethods),
+		jwt.WithIssuer(jwtIssuer),
+		jwt.WithAudience(jwtAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 
-		return id, role, nil
+	if err != nil {
+		return nil, err
+	} else if !token.Valid || claims.NotBefore == nil || claims.Id == "" || claims.SessionId == "" {
+		return nil, fmt.Errorf("invalid token")
 	} else {
-		return "", "", fmt.Errorf("invalid token")
+		return &claims, nil
 	}
 }
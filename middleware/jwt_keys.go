@@ -0,0 +1,271 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtKey is one entry of the key set. Keys without private material can only
+// verify tokens, which is how retired keys are kept around during a rotation.
+type jwtKey struct {
+	Id      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// minSecretLength is the shortest HMAC secret accepted, the size of the
+// SHA-256 output.
+const minSecretLength = 32
+
+// placeholderJWTSecret was once committed to config/.env, it is public and
+// never accepted.
+const placeholderJWTSecret = "change-me-to-a-long-random-secret"
+
+var (
+	jwtKeys       = map[string]*jwtKey{}
+	signingKey    *jwtKey
+	jwtIssuer     string
+	jwtAudience   string
+	jwtKeyMethods []string
+)
+
+// LoadJWTKeys reads the signing configuration from the environment:
+//
+//	JWT_KEYS_DIR        directory of keys named <kid>.pem (RSA, ECDSA or
+//	                    Ed25519, private or public) or <kid>.secret (HMAC)
+//	JWT_SIGNING_KEY_ID  kid of the key new tokens are signed with
+//	JWT_SECRET          HS256 secret used when no key directory is set, at
+//	                    least 32 bytes
+//	JWT_ISSUER          value of the iss claim
+//	JWT_AUDIENCE        value of the aud claim
+//
+// Every key in the directory is accepted for verification, so a key can be
+// rotated by adding the new one, switching JWT_SIGNING_KEY_ID and removing the
+// old file once the tokens signed with it have expired.
+func LoadJWTKeys() error {
+	keys := map[string]*jwtKey{}
+
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			ext := filepath.Ext(entry.Name())
+			if ext != ".pem" && ext != ".secret" {
+				continue
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return err
+			}
+
+			key, err := parseJWTKey(strings.TrimSuffix(entry.Name(), ext), ext, data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", entry.Name(), err)
+			}
+			keys[key.Id] = key
+		}
+	} else if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		if len(secret) < minSecretLength {
+			return fmt.Errorf("JWT_SECRET must be at least %d bytes", minSecretLength)
+		} else if secret == placeholderJWTSecret {
+			return errors.New("JWT_SECRET is still the published placeholder, set a random secret")
+		}
+
+		keys["default"] = &jwtKey{
+			Id:      "default",
+			Method:  jwt.SigningMethodHS256,
+			Private: []byte(secret),
+			Public:  []byte(secret),
+		}
+	}
+
+	if len(keys) == 0 {
+		return errors.New("no JWT keys configured, set JWT_KEYS_DIR or JWT_SECRET")
+	}
+
+	signingKeyId := os.Getenv("JWT_SIGNING_KEY_ID")
+	if signingKeyId == "" && len(keys) == 1 {
+		for kid := range keys {
+			signingKeyId = kid
+		}
+	}
+
+	signing, ok := keys[signingKeyId]
+	if !ok {
+		return fmt.Errorf("JWT signing key %q not found", signingKeyId)
+	} else if signing.Private == nil {
+		return fmt.Errorf("JWT signing key %q has no private key", signingKeyId)
+	}
+
+	methods := []string{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !seen[key.Method.Alg()] {
+			seen[key.Method.Alg()] = true
+			methods = append(methods, key.Method.Alg())
+		}
+	}
+
+	jwtKeys = keys
+	signingKey = signing
+	jwtKeyMethods = methods
+	jwtIssuer = getEnvOrDefault("JWT_ISSUER", "golang-final-project")
+	jwtAudience = getEnvOrDefault("JWT_AUDIENCE", "golang-final-project")
+
+	return nil
+}
+
+func parseJWTKey(kid string, ext string, data []byte) (*jwtKey, error) {
+	if ext == ".secret" {
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("HMAC secret must be at least %d bytes", minSecretLength)
+		}
+		return &jwtKey{Id: kid, Method: jwt.SigningMethodHS256, Private: secret, Public: secret}, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var (
+		private crypto.PrivateKey
+		public  crypto.PublicKey
+		err     error
+	)
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if private != nil {
+		public = private.(interface{ Public() crypto.PublicKey }).Public()
+	}
+
+	var method jwt.SigningMethod
+
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return nil, errors.New("unsupported elliptic curve")
+		}
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+
+	return &jwtKey{Id: kid, Method: method, Private: private, Public: public}, nil
+}
+
+// lookupVerificationKey is the jwt.Keyfunc used by ValidateJWT. It refuses a
+// token whose alg doesn't match the key named in its kid header.
+func lookupVerificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := jwtKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %q", kid)
+	} else if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	} else {
+		return key.Public, nil
+	}
+}
+
+// JWKS returns the public half of every asymmetric key, HMAC secrets are never
+// published.
+func JWKS() []JWK {
+	keys := []JWK{}
+
+	for _, key := range jwtKeys {
+		jwk := JWK{Kid: key.Id, Alg: key.Method.Alg(), Use: "sig"}
+
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+
+		keys = append(keys, jwk)
+	}
+
+	return keys
+}
+
+func getEnvOrDefault(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -4,32 +4,38 @@ import (
 	"time"
 )
 
+// Cart is the single mutable cart of a user. Checking it out turns its lines
+// into an Order and leaves the cart empty.
 type Cart struct {
-	Id            int        `json:"id"`
-	UserId        int        `json:"user_id"`
-	CreatedAt     time.Time  `json:"created_at"`
-	TotalPrice    int        `json:"total_price"`
-	CartItems     []CartItem `json:"items"`
-	PaymentMethod string     `json:"payment_method"`
-	PaymentStatus string     `json:"payment_status"`
+	Id         int            `json:"id"`
+	UserId     int            `json:"user_id"`
+	CreatedAt  time.Time      `json:"created_at"`
+	TotalPrice int            `json:"total_price"`
+	Pricing    PriceBreakdown `json:"pricing"`
+	CartItems  []CartItem     `json:"items"`
 }
 
+// CartItem is a cart line for one variant of an item. VariantId can be left
+// out when adding an item that has a single active variant.
 type CartItem struct {
-	Id       int   `json:"id"`
-	CartId   int   `json:"cart_id"`
-	ItemId   int   `json:"item_id"`
-	Quantity int   `json:"quantity"`
-	Item     *Item `json:"item"`
+	Id           int    `json:"id"`
+	CartId       int    `json:"cart_id"`
+	ItemId       int    `json:"item_id"`
+	VariantId    int    `json:"variant_id"`
+	Sku          string `json:"sku"`
+	VariantName  string `json:"variant_name"`
+	Quantity     int    `json:"quantity"`
+	UnitPrice    int    `json:"unit_price"`
+	Subtotal     int    `json:"subtotal"`
+	PriceChanged bool   `json:"price_changed"`
+	Item         *Item  `json:"item"`
 }
 
 type PostCartBody struct {
-	Id            int        `json:"id"`
-	UserId        int        `json:"user_id"`
-	CreatedAt     time.Time  `json:"created_at"`
-	Items         []CartItem `json:"items"`
-	TotalPrice    int        `json:"total_price"`
-	PaymentMethod string     `json:"payment_method"`
-	PaymentStatus string     `json:"payment_status"`
+	Id        int        `json:"id"`
+	UserId    int        `json:"user_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Items     []CartItem `json:"items"`
 }
 
 type CartResponse struct {
@@ -40,10 +46,33 @@ type CartResponse struct {
 }
 
 type CartItemUpdate struct {
-	ItemId   int64 `json:"item_id"`
-	Quantity int   `json:"quantity"`
+	ItemId    int64 `json:"item_id"`
+	VariantId int64 `json:"variant_id"`
+	Quantity  int   `json:"quantity"`
+}
+
+// CheckoutBody's ShipTo is where the order goes, used to pick the nearest
+// warehouse when allocating stock.
+type CheckoutBody struct {
+	PaymentMethod string    `json:"payment_method"`
+	ShipTo        *GeoPoint `json:"ship_to"`
+}
+
+// PriceBreakdown is always computed server-side from items.price, GrandTotal
+// is what the customer is charged.
+type PriceBreakdown struct {
+	Subtotal   int `json:"subtotal"`
+	Discount   int `json:"discount"`
+	Tax        int `json:"tax"`
+	Shipping   int `json:"shipping"`
+	GrandTotal int `json:"grand_total"`
 }
 
-type CartPayment struct {
-	PaymentToken string `json:"payment_token"`
+type PriceChange struct {
+	ItemId    int    `json:"item_id"`
+	VariantId int    `json:"variant_id"`
+	Sku       string `json:"sku"`
+	ItemName  string `json:"item_name"`
+	OldPrice  int    `json:"old_price"`
+	NewPrice  int    `json:"new_price"`
 }
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// Category is a node of the category tree. Siblings are ordered by
+// Position, then name.
+type Category struct {
+	Id        int        `json:"id"`
+	ParentId  *int       `json:"parent_id"`
+	Name      string     `json:"name"`
+	Slug      string     `json:"slug"`
+	Position  int        `json:"position"`
+	Children  []Category `json:"children"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type CategoryBody struct {
+	ParentId *int   `json:"parent_id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Position int    `json:"position"`
+}
+
+type Tag struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TagBody struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type ItemCategoryBody struct {
+	CategoryId *int `json:"category_id"`
+}
+
+type ItemTagsBody struct {
+	Tags []string `json:"tags"`
+}
+
+// ItemFacets counts the items matching a listing per category and tag. Each
+// facet ignores its own filter, so the counts show what picking another
+// category or adding a tag would return. A category counts the items of its
+// subcategories as well.
+type ItemFacets struct {
+	Categories []CategoryFacet `json:"categories"`
+	Tags       []TagFacet      `json:"tags"`
+}
+
+type CategoryFacet struct {
+	Id       int    `json:"id"`
+	ParentId *int   `json:"parent_id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	Count    int    `json:"count"`
+}
+
+type TagFacet struct {
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int    `json:"count"`
+}
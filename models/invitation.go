@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type Invitation struct {
+	Id         int        `json:"id"`
+	Role       string     `json:"role"`
+	CreatedBy  int        `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedBy *int       `json:"accepted_by,omitempty"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+type InvitationBody struct {
+	Role string `json:"role"`
+}
+
+type InvitationResponse struct {
+	Invitation
+	Token string `json:"token"`
+}
+
+type AcceptInvitationBody struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
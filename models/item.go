@@ -2,21 +2,137 @@ package models
 
 import "time"
 
+// Item's PrimaryImage is the image shown for it in lists and carts, nil when
+// it has no images.
 type Item struct {
-	Id          int          `json:"id"`
-	ItemName    string       `json:"item_name"`
-	Images      []ItemImages `json:"images"`
-	Description string       `json:"desc,omitempty"`
-	Price       int          `json:"price"`
-	Stock       int          `json:"stock,omitempty"`
-	CreatedBy   string       `json:"created_by,omitempty"`
-	CreatedAt   *time.Time   `json:"created_at,omitempty"`
-	ModifiedBy  string       `json:"modified_by,omitempty"`
-	ModifiedAt  *time.Time   `json:"modified_at,omitempty"`
+	Id           int            `json:"id"`
+	ItemName     string         `json:"item_name"`
+	Images       []ItemImages   `json:"images"`
+	PrimaryImage *ItemImages    `json:"primary_image"`
+	Description  string         `json:"desc,omitempty"`
+	Price        int            `json:"price"`
+	Stock        int            `json:"stock,omitempty"`
+	Reserved     int            `json:"reserved_stock"`
+	Available    int            `json:"available_stock"`
+	CategoryId   *int           `json:"category_id"`
+	Tags         []string       `json:"tags"`
+	Variants     []Variant      `json:"variants,omitempty"`
+	Locations    []ItemLocation `json:"locations,omitempty"`
+	CreatedBy    string         `json:"created_by,omitempty"`
+	CreatedAt    *time.Time     `json:"created_at,omitempty"`
+	ModifiedBy   string         `json:"modified_by,omitempty"`
+	ModifiedAt   *time.Time     `json:"modified_at,omitempty"`
 }
 
+// ItemImages' ImageUrl is the full size image. Images uploaded before
+// renditions were generated have none. An item's images are shown in
+// Position order.
 type ItemImages struct {
-	Id       int    `json:"id"`
-	ItemId   int    `json:"item_id"`
-	ImageUrl string `json:"image_url"`
+	Id         int              `json:"id"`
+	ItemId     int              `json:"item_id"`
+	VariantId  *int             `json:"variant_id,omitempty"`
+	ImageUrl   string           `json:"image_url"`
+	Position   int              `json:"position"`
+	IsPrimary  bool             `json:"is_primary"`
+	Renditions []ImageRendition `json:"renditions"`
+}
+
+// ItemImagesOrderBody lists every image of the item in the order they should
+// be shown.
+type ItemImagesOrderBody struct {
+	ImageIds []int `json:"image_ids"`
+}
+
+// ImageRendition is one of the sizes an image is served in. Key is where the
+// store keeps it.
+type ImageRendition struct {
+	Name        string `json:"name"`
+	Url         string `json:"url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Key         string `json:"-"`
+}
+
+const (
+	ItemSortName      = "name"
+	ItemSortNameDesc  = "-name"
+	ItemSortPrice     = "price"
+	ItemSortPriceDesc = "-price"
+	ItemSortNewest    = "newest"
+	ItemSortOldest    = "oldest"
+	ItemSortStock     = "stock"
+	ItemSortStockDesc = "-stock"
+)
+
+// ItemQuery selects a page of items. A page is either Offset items in, or the
+// items right After or Before a cursor taken from an earlier page; cursors
+// stay stable while items are added or removed.
+type ItemQuery struct {
+	Sort           string
+	Limit          int
+	Offset         int
+	After          string
+	Before         string
+	MinPrice       *int
+	MaxPrice       *int
+	InStock        *bool
+	CreatedBy      string
+	Category       string
+	Tags           []string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	ModifiedAfter  *time.Time
+	ModifiedBefore *time.Time
+}
+
+// ItemPage is one page of items. Total counts every item matching the
+// filters, HasNext and HasPrev tell whether there is anything past either
+// end of the page.
+type ItemPage struct {
+	Items      []Item     `json:"items"`
+	Total      int        `json:"total"`
+	Limit      int        `json:"limit"`
+	Offset     *int       `json:"offset,omitempty"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	PrevCursor string     `json:"prev_cursor,omitempty"`
+	HasNext    bool       `json:"-"`
+	HasPrev    bool       `json:"-"`
+	Links      PageLinks  `json:"links"`
+	Facets     ItemFacets `json:"facets"`
+}
+
+type PageLinks struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+type ItemSearchQuery struct {
+	ItemQuery
+	Text string
+}
+
+// ItemSearchResult is an item matching a search. Highlight holds the name
+// and a snippet of the description as HTML, escaped, with the matched words
+// wrapped in <mark> tags; items only found through a misspelling have
+// nothing marked.
+type ItemSearchResult struct {
+	Item
+	Rank      float64       `json:"rank"`
+	Highlight ItemHighlight `json:"highlight"`
+}
+
+type ItemHighlight struct {
+	ItemName    string `json:"item_name"`
+	Description string `json:"desc,omitempty"`
+}
+
+type ItemSearchPage struct {
+	Query   string             `json:"query"`
+	Results []ItemSearchResult `json:"results"`
+	Total   int                `json:"total"`
+	Limit   int                `json:"limit"`
+	Offset  int                `json:"offset"`
+	Links   PageLinks          `json:"links"`
 }
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+const (
+	OrderStatusPending         = "pending"
+	OrderStatusAwaitingPayment = "awaiting_payment"
+	OrderStatusPaid            = "paid"
+	OrderStatusFulfilling      = "fulfilling"
+	OrderStatusShipped         = "shipped"
+	OrderStatusDelivered       = "delivered"
+	OrderStatusCancelled       = "cancelled"
+	OrderStatusRefunded        = "refunded"
+	OrderStatusDisputed        = "disputed"
+)
+
+// Order is the immutable record of a checkout. Its lines keep the item name
+// and price as they were at checkout, whatever happens to the item later.
+type Order struct {
+	Id            int            `json:"id"`
+	UserId        int            `json:"user_id"`
+	Status        string         `json:"status"`
+	PaymentMethod string         `json:"payment_method"`
+	TotalPrice    int            `json:"total_price"`
+	Pricing       PriceBreakdown `json:"pricing"`
+	Lines         []OrderLine    `json:"lines"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	PaidAt        *time.Time     `json:"paid_at,omitempty"`
+}
+
+type OrderLine struct {
+	Id          int                   `json:"id"`
+	OrderId     int                   `json:"order_id"`
+	ItemId      *int                  `json:"item_id"`
+	VariantId   *int                  `json:"variant_id"`
+	Sku         string                `json:"sku"`
+	ItemName    string                `json:"item_name"`
+	VariantName string                `json:"variant_name"`
+	UnitPrice   int                   `json:"unit_price"`
+	Quantity    int                   `json:"quantity"`
+	Subtotal    int                   `json:"subtotal"`
+	Allocations []OrderLineAllocation `json:"allocations"`
+}
+
+// OrderStatusChange is one row of an order's history. FromStatus is nil for
+// the entry written when the order is placed, ActorId is nil for changes made
+// by the system rather than a user.
+type OrderStatusChange struct {
+	Id         int       `json:"id"`
+	OrderId    int       `json:"order_id"`
+	FromStatus *string   `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	ActorId    *int      `json:"actor_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type OrderStatusBody struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
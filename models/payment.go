@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Payment statuses mirror the provider's, plus pending before the provider
+// has answered, capturing while the capture is in flight and failed when the
+// provider never answered.
+const (
+	PaymentStatusPending        = "pending"
+	PaymentStatusRequiresAction = "requires_action"
+	PaymentStatusAuthorized     = "authorized"
+	PaymentStatusCapturing      = "capturing"
+	PaymentStatusCaptured       = "captured"
+	PaymentStatusDeclined       = "declined"
+	PaymentStatusVoided         = "voided"
+	PaymentStatusRefunded       = "refunded"
+	PaymentStatusDisputed       = "disputed"
+	PaymentStatusFailed         = "failed"
+)
+
+// Payment is one attempt at paying an order. An order collects a new row for
+// every attempt, at most one of them ends up captured.
+type Payment struct {
+	Id        int       `json:"id"`
+	OrderId   int       `json:"order_id"`
+	Provider  string    `json:"provider"`
+	Reference string    `json:"reference"`
+	Status    string    `json:"status"`
+	Amount    int       `json:"amount"`
+	ActionURL string    `json:"action_url,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type PaymentBody struct {
+	PaymentToken string `json:"payment_token"`
+}
+
+// Outcomes of a received payment webhook event. Anything other than processed
+// is kept around so it can be looked at by hand.
+const (
+	PaymentEventProcessed   = "processed"
+	PaymentEventUnmatched   = "unmatched"
+	PaymentEventOutOfOrder  = "out_of_order"
+	PaymentEventUnknownType = "unknown_type"
+	PaymentEventFailed      = "failed"
+)
+
+type PaymentEvent struct {
+	Id          int             `json:"id"`
+	Provider    string          `json:"provider"`
+	EventId     string          `json:"event_id"`
+	Type        string          `json:"type"`
+	Reference   string          `json:"reference"`
+	Status      string          `json:"status"`
+	Note        string          `json:"note,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+const (
+	RefundStatusPending   = "pending"
+	RefundStatusSucceeded = "succeeded"
+	RefundStatusFailed    = "failed"
+)
+
+// Refund is money given back for some or all of an order's lines, together
+// with the operator who issued it. A refund is pending until the payment
+// provider has returned the money, and failed when the provider refused to.
+type Refund struct {
+	Id         int          `json:"id"`
+	OrderId    int          `json:"order_id"`
+	PaymentId  int          `json:"payment_id"`
+	Amount     int          `json:"amount"`
+	Reason     string       `json:"reason"`
+	Status     string       `json:"status"`
+	OperatorId *int         `json:"operator_id"`
+	Lines      []RefundLine `json:"lines"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// RefundLine is the quantity of one order line covered by a refund. Restocked
+// is false for goods that can't be sold again, e.g. when they came back
+// damaged.
+type RefundLine struct {
+	Id          int  `json:"id"`
+	RefundId    int  `json:"refund_id"`
+	OrderLineId int  `json:"order_line_id"`
+	Quantity    int  `json:"quantity"`
+	Amount      int  `json:"amount"`
+	Restocked   bool `json:"restocked"`
+}
+
+// RefundBody refunds the listed lines, or everything not refunded yet when
+// Lines is empty. Restock applies to every line that doesn't set its own and
+// defaults to true.
+type RefundBody struct {
+	Reason  string           `json:"reason"`
+	Restock *bool            `json:"restock"`
+	Lines   []RefundLineBody `json:"lines"`
+}
+
+type RefundLineBody struct {
+	OrderLineId int   `json:"order_line_id"`
+	Quantity    int   `json:"quantity"`
+	Restock     *bool `json:"restock"`
+}
+
+type CancelOrderBody struct {
+	Reason string `json:"reason"`
+}
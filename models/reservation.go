@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	ReservationStatusActive   = "active"
+	ReservationStatusConsumed = "consumed"
+	ReservationStatusReleased = "released"
+)
+
+// StockReservation holds stock for an order between checkout and payment.
+// It is consumed when the order is paid and released when the order is
+// cancelled or ExpiresAt passes first.
+type StockReservation struct {
+	Id        int        `json:"id"`
+	OrderId   int        `json:"order_id"`
+	ItemId    int        `json:"item_id"`
+	Quantity  int        `json:"quantity"`
+	Status    string     `json:"status"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	ClosedAt  *time.Time `json:"closed_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+const (
+	PermissionItemsRead       = "items:read"
+	PermissionItemsWrite      = "items:write"
+	PermissionCartsRead       = "carts:read"
+	PermissionCartsWrite      = "carts:write"
+	PermissionCartsReadAny    = "carts:read:any"
+	PermissionCartsWriteAny   = "carts:write:any"
+	PermissionOrdersRead      = "orders:read"
+	PermissionOrdersReadAny   = "orders:read:any"
+	PermissionOrdersPay       = "orders:pay"
+	PermissionOrdersFulfil    = "orders:fulfil"
+	PermissionOrdersRefund    = "orders:refund"
+	PermissionOrdersCancel    = "orders:cancel"
+	PermissionSessionsRevoke  = "sessions:revoke"
+	PermissionRolesManage     = "roles:manage"
+	PermissionUsersInvite     = "users:invite"
+	PermissionInventoryManage = "inventory:manage"
+	PermissionCatalogManage   = "catalog:manage"
+)
+
+const (
+	RoleAdmin = "admin"
+	RoleStaff = "staff"
+	RoleUser  = "user"
+)
+
+type Role struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type Permission struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type RolePermissionsBody struct {
+	Permissions []string `json:"permissions"`
+}
+
+type UserRoleBody struct {
+	Role string `json:"role"`
+}
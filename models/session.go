@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+type Session struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IpAddress  string     `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type RefreshToken struct {
+	Id        int        `json:"id"`
+	SessionId int        `json:"session_id"`
+	TokenHash string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+}
+
+type RefreshTokenBody struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenPair struct {
+	AccessToken                string    `json:"access_token"`
+	TokenExpirationTime        time.Time `json:"token_expiration_time"`
+	RefreshToken               string    `json:"refresh_token"`
+	RefreshTokenExpirationTime time.Time `json:"refresh_token_expiration_time"`
+}
+
+const (
+	RevocationReasonLogout            = "logout"
+	RevocationReasonLogoutAll         = "logout_all"
+	RevocationReasonAdmin             = "revoked_by_admin"
+	RevocationReasonRefreshTokenReuse = "refresh_token_reuse"
+)
+
+type SessionRevocation struct {
+	Id        int       `json:"id"`
+	SessionId int       `json:"session_id"`
+	UserId    int       `json:"user_id"`
+	RevokedBy *int      `json:"revoked_by"`
+	Reason    string    `json:"reason"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
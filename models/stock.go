@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+const (
+	StockMovementInitial    = "initial"
+	StockMovementSale       = "sale"
+	StockMovementRefund     = "refund"
+	StockMovementAdjustment = "adjustment"
+	StockMovementStocktake  = "stocktake"
+	StockMovementReceipt    = "receipt"
+	StockMovementTransfer   = "transfer"
+)
+
+// StockMovement is one entry of an item's inventory ledger. items.stock is
+// always the sum of the item's deltas, StockAfter is that sum right after
+// this entry. VariantId and WarehouseId are the variant and location whose
+// stock changed.
+type StockMovement struct {
+	Id          int       `json:"id"`
+	ItemId      int       `json:"item_id"`
+	VariantId   *int      `json:"variant_id"`
+	WarehouseId *int      `json:"warehouse_id"`
+	Kind        string    `json:"kind"`
+	Delta       int       `json:"delta"`
+	StockAfter  int       `json:"stock_after"`
+	Reason      string    `json:"reason"`
+	ActorId     *int      `json:"actor_id"`
+	ReferenceId string    `json:"reference_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// StockAdjustmentBody changes stock by Delta for adjustments and supplier
+// receipts. A stocktake sets CountedStock instead and the delta is whatever
+// brings the stock to the counted quantity. Without VariantId or WarehouseId
+// the item's default variant at the default warehouse is adjusted.
+type StockAdjustmentBody struct {
+	VariantId    *int   `json:"variant_id"`
+	WarehouseId  *int   `json:"warehouse_id"`
+	Kind         string `json:"kind"`
+	Delta        int    `json:"delta"`
+	CountedStock *int   `json:"counted_stock"`
+	Reason       string `json:"reason"`
+	ReferenceId  string `json:"reference_id"`
+}
+
+type StockLedger struct {
+	ItemId        int             `json:"item_id"`
+	Stock         int             `json:"stock"`
+	LedgerBalance int             `json:"ledger_balance"`
+	Reconciled    bool            `json:"reconciled"`
+	Movements     []StockMovement `json:"movements"`
+}
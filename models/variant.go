@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+// OptionType is a way an item's variants differ, such as size or colour,
+// with the values it can take.
+type OptionType struct {
+	Id       int           `json:"id"`
+	ItemId   int           `json:"item_id"`
+	Name     string        `json:"name"`
+	Position int           `json:"position"`
+	Values   []OptionValue `json:"values"`
+}
+
+type OptionValue struct {
+	Id       int    `json:"id"`
+	Value    string `json:"value"`
+	Position int    `json:"position"`
+}
+
+// Variant is the unit that is sold and stocked. Every item has a default
+// variant, the only one until option types are added. Price is what the
+// variant sells for; PriceOverride is nil when that is the item's price.
+type Variant struct {
+	Id            int             `json:"id"`
+	ItemId        int             `json:"item_id"`
+	Sku           string          `json:"sku"`
+	Name          string          `json:"name"`
+	Price         int             `json:"price"`
+	PriceOverride *int            `json:"price_override"`
+	Stock         int             `json:"stock"`
+	Reserved      int             `json:"reserved_stock"`
+	Available     int             `json:"available_stock"`
+	Options       []VariantOption `json:"options"`
+	Images        []ItemImages    `json:"images"`
+	IsDefault     bool            `json:"is_default"`
+	Active        bool            `json:"active"`
+	Position      int             `json:"position"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+type VariantOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type OptionTypeBody struct {
+	Name     string   `json:"name"`
+	Position int      `json:"position"`
+	Values   []string `json:"values"`
+}
+
+type OptionValueBody struct {
+	Value    string `json:"value"`
+	Position int    `json:"position"`
+}
+
+// VariantBody creates or updates a variant. Options maps every option type
+// of the item to one of its values; they can only be given on creation.
+type VariantBody struct {
+	Sku      string            `json:"sku"`
+	Price    *int              `json:"price"`
+	Options  map[string]string `json:"options"`
+	Active   *bool             `json:"active"`
+	Position int               `json:"position"`
+}
+
+type VariantImagesBody struct {
+	ImageIds []int `json:"image_ids"`
+}
+
+type ItemVariants struct {
+	ItemId   int          `json:"item_id"`
+	Options  []OptionType `json:"options"`
+	Variants []Variant    `json:"variants"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Warehouse is a location stock is kept and shipped from. Stock without an
+// explicit location, such as the opening stock of a new item, goes to the
+// default warehouse.
+type Warehouse struct {
+	Id        int       `json:"id"`
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	Location  *GeoPoint `json:"location"`
+	Priority  int       `json:"priority"`
+	IsDefault bool      `json:"is_default"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WarehouseBody struct {
+	Code     string    `json:"code"`
+	Name     string    `json:"name"`
+	Location *GeoPoint `json:"location"`
+	Priority int       `json:"priority"`
+	Active   *bool     `json:"active"`
+}
+
+// ItemLocation is an item's stock at one warehouse.
+type ItemLocation struct {
+	WarehouseId   int    `json:"warehouse_id"`
+	WarehouseCode string `json:"warehouse_code"`
+	Stock         int    `json:"stock"`
+	Reserved      int    `json:"reserved_stock"`
+	Available     int    `json:"available_stock"`
+}
+
+// OrderLineAllocation is the quantity of an order line a warehouse fulfils.
+type OrderLineAllocation struct {
+	OrderLineId int `json:"order_line_id"`
+	WarehouseId int `json:"warehouse_id"`
+	Quantity    int `json:"quantity"`
+}
+
+// StockTransferBody moves stock of one of the item's variants, its default
+// variant when VariantId is nil.
+type StockTransferBody struct {
+	ItemId          int    `json:"item_id"`
+	VariantId       *int   `json:"variant_id"`
+	FromWarehouseId int    `json:"from_warehouse_id"`
+	ToWarehouseId   int    `json:"to_warehouse_id"`
+	Quantity        int    `json:"quantity"`
+	Reason          string `json:"reason"`
+}
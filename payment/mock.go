@@ -0,0 +1,169 @@
+package payment
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+const MockProviderName = "mock"
+
+// Payment tokens understood by the mock provider. Any other token is
+// authorized straight away.
+const (
+	MockTokenDecline        = "tok_decline"
+	MockTokenTimeout        = "tok_timeout"
+	MockTokenRequiresAction = "tok_3ds"
+	MockTokenActionDecline  = "tok_3ds_decline"
+)
+
+type mockPayment struct {
+	token    string
+	status   string
+	amount   int
+	captured int
+	refunded int
+	// refundKeys are the keys of the refunds made so far
+	refundKeys map[string]bool
+}
+
+// MockProvider keeps its payments in memory so every path of the payment flow
+// can be driven offline. Payments that require action are considered verified
+// the next time their status is looked up, unless they were made with
+// MockTokenActionDecline.
+type MockProvider struct {
+	mu       sync.Mutex
+	payments map[string]*mockPayment
+}
+
+func NewMockProvider() *MockProvider {
+	return &MockProvider{payments: make(map[string]*mockPayment)}
+}
+
+func (m *MockProvider) Name() string {
+	return MockProviderName
+}
+
+func (m *MockProvider) Authorize(request AuthorizeRequest) (Result, error) {
+	if request.Token == MockTokenTimeout {
+		return Result{}, ErrTimeout
+	}
+
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return Result{}, err
+	}
+	reference := "mock_" + hex.EncodeToString(buf)
+
+	p := &mockPayment{token: request.Token, amount: request.Amount}
+
+	switch request.Token {
+	case MockTokenDecline:
+		p.status = StatusDeclined
+	case MockTokenRequiresAction, MockTokenActionDecline:
+		p.status = StatusRequiresAction
+	default:
+		p.status = StatusAuthorized
+	}
+
+	m.mu.Lock()
+	m.payments[reference] = p
+	m.mu.Unlock()
+
+	return m.result(reference, p), nil
+}
+
+func (m *MockProvider) Capture(reference string, amount int) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, exists := m.payments[reference]
+	if !exists {
+		return Result{}, ErrUnknownPayment
+	} else if p.status != StatusAuthorized {
+		return m.result(reference, p), fmt.Errorf("cannot capture a %s payment", p.status)
+	} else if amount > p.amount {
+		return m.result(reference, p), fmt.Errorf("cannot capture more than the authorized %d", p.amount)
+	}
+
+	p.status = StatusCaptured
+	p.captured = amount
+	return m.result(reference, p), nil
+}
+
+func (m *MockProvider) Void(reference string) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, exists := m.payments[reference]
+	if !exists {
+		return Result{}, ErrUnknownPayment
+	} else if p.status != StatusAuthorized && p.status != StatusRequiresAction {
+		return m.result(reference, p), fmt.Errorf("cannot void a %s payment", p.status)
+	}
+
+	p.status = StatusVoided
+	return m.result(reference, p), nil
+}
+
+func (m *MockProvider) Refund(reference string, amount int, key string) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, exists := m.payments[reference]
+	if !exists {
+		return Result{}, ErrUnknownPayment
+	} else if p.refundKeys[key] {
+		return m.result(reference, p), nil
+	} else if p.status != StatusCaptured && p.status != StatusRefunded {
+		return m.result(reference, p), fmt.Errorf("cannot refund a %s payment", p.status)
+	} else if p.refunded+amount > p.captured {
+		return m.result(reference, p), fmt.Errorf("cannot refund more than the captured %d", p.captured)
+	}
+
+	if p.refundKeys == nil {
+		p.refundKeys = make(map[string]bool)
+	}
+	p.refundKeys[key] = true
+
+	p.refunded += amount
+	if p.refunded == p.captured {
+		p.status = StatusRefunded
+	}
+	return m.result(reference, p), nil
+}
+
+func (m *MockProvider) Status(reference string) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, exists := m.payments[reference]
+	if !exists {
+		return Result{}, ErrUnknownPayment
+	}
+
+	if p.status == StatusRequiresAction {
+		if p.token == MockTokenActionDecline {
+			p.status = StatusDeclined
+		} else {
+			p.status = StatusAuthorized
+		}
+	}
+
+	return m.result(reference, p), nil
+}
+
+func (m *MockProvider) result(reference string, p *mockPayment) Result {
+	result := Result{Reference: reference, Status: p.status}
+
+	switch p.status {
+	case StatusRequiresAction:
+		result.ActionURL = "https://mock-payments.invalid/3ds/" + reference
+		result.Message = "Additional verification required"
+	case StatusDeclined:
+		result.Message = "Card declined"
+	}
+
+	return result
+}
@@ -0,0 +1,81 @@
+// Package payment talks to payment gateways. Each gateway is a Provider; the
+// one used for new payments is picked with PAYMENT_PROVIDER and defaults to
+// the built-in mock.
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrTimeout         = errors.New("payment provider timed out")
+	ErrUnknownProvider = errors.New("unknown payment provider")
+	ErrUnknownPayment  = errors.New("payment provider doesn't know this payment")
+)
+
+const (
+	StatusAuthorized     = "authorized"
+	StatusRequiresAction = "requires_action"
+	StatusDeclined       = "declined"
+	StatusCaptured       = "captured"
+	StatusVoided         = "voided"
+	StatusRefunded       = "refunded"
+)
+
+type AuthorizeRequest struct {
+	OrderId int
+	Amount  int
+	Token   string
+}
+
+// Result is the provider's view of a payment after a call. ActionURL is only
+// set for StatusRequiresAction and is where the customer completes the extra
+// verification step.
+type Result struct {
+	Reference string
+	Status    string
+	ActionURL string
+	Message   string
+}
+
+type Provider interface {
+	Name() string
+	Authorize(request AuthorizeRequest) (Result, error)
+	Capture(reference string, amount int) (Result, error)
+	Void(reference string) (Result, error)
+	// Refund gives amount of a captured payment back. A refund is only made
+	// once per key, repeating the call with the same key returns the current
+	// state of the payment without refunding again.
+	Refund(reference string, amount int, key string) (Result, error)
+	Status(reference string) (Result, error)
+}
+
+var providers = map[string]Provider{}
+
+func Register(provider Provider) {
+	providers[provider.Name()] = provider
+}
+
+func init() {
+	Register(NewMockProvider())
+}
+
+// Get returns the provider a payment was made with, so follow-up calls go to
+// the same gateway even after PAYMENT_PROVIDER changes.
+func Get(name string) (Provider, error) {
+	provider, exists := providers[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+func Default() (Provider, error) {
+	name := os.Getenv("PAYMENT_PROVIDER")
+	if name == "" {
+		name = MockProviderName
+	}
+	return Get(name)
+}
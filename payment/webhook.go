@@ -0,0 +1,136 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookSignatureHeader carries "t=<unix seconds>,v1=<hex signature>", where
+// the signature is the HMAC-SHA256 of "<t>.<raw body>" keyed with the
+// provider's webhook secret.
+const WebhookSignatureHeader = "X-Payment-Signature"
+
+// WebhookTolerance is how far the signed timestamp may be from now. Together
+// with deduplication by event ID it keeps captured requests from being
+// replayed later.
+var WebhookTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature  = errors.New("invalid webhook signature")
+	ErrStaleWebhook      = errors.New("webhook timestamp is outside the allowed window")
+	ErrNoWebhookSecret   = errors.New("no webhook secret configured for provider")
+	ErrWeakWebhookSecret = errors.New("webhook secret is shorter than 32 bytes or still a placeholder for provider")
+)
+
+// minWebhookSecretLength is the shortest webhook secret accepted.
+const minWebhookSecretLength = 32
+
+// placeholderWebhookSecrets were once committed to config/.env, they are
+// public and never accepted.
+var placeholderWebhookSecrets = map[string]bool{
+	"change-me-to-the-mock-webhook-secret": true,
+}
+
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentFailed    = "payment.failed"
+	EventPaymentRefunded  = "payment.refunded"
+	EventPaymentDisputed  = "payment.disputed"
+)
+
+type Event struct {
+	Id        string `json:"id"`
+	Type      string `json:"type"`
+	Reference string `json:"reference"`
+	Message   string `json:"message"`
+}
+
+// WebhookSecret reads PAYMENT_WEBHOOK_SECRET_<PROVIDER>, e.g.
+// PAYMENT_WEBHOOK_SECRET_MOCK. Secrets anyone could guess are refused.
+func WebhookSecret(provider string) (string, error) {
+	secret := os.Getenv("PAYMENT_WEBHOOK_SECRET_" + strings.ToUpper(provider))
+	if secret == "" {
+		return "", fmt.Errorf("%w %s", ErrNoWebhookSecret, provider)
+	} else if len(secret) < minWebhookSecretLength || placeholderWebhookSecrets[secret] {
+		return "", fmt.Errorf("%w %s", ErrWeakWebhookSecret, provider)
+	}
+	return secret, nil
+}
+
+// WebhookProviders returns the names of the providers whose webhook secret
+// is usable, in order.
+func WebhookProviders() []string {
+	names := []string{}
+	for name := range providers {
+		if _, err := WebhookSecret(name); err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SignWebhook returns the signature header value for body sent at timestamp.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + webhookSignature(secret, t, body)
+}
+
+func VerifyWebhook(secret string, header string, body []byte, now time.Time) error {
+	var t, signature string
+
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	seconds, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || signature == "" {
+		return ErrInvalidSignature
+	}
+
+	expected := webhookSignature(secret, t, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > WebhookTolerance || age < -WebhookTolerance {
+		return ErrStaleWebhook
+	}
+
+	return nil
+}
+
+func ParseEvent(body []byte) (Event, error) {
+	var event Event
+
+	if err := json.Unmarshal(body, &event); err != nil {
+		return event, err
+	} else if event.Id == "" || event.Type == "" {
+		return event, errors.New("event id and type are required")
+	}
+
+	return event, nil
+}
+
+func webhookSignature(secret string, t string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,27 @@
+// Package policy decides whether a principal may act on a resource it has
+// already been authenticated for. The router only checks that a permission is
+// held at all; ownership of the specific record is checked here.
+package policy
+
+import (
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+)
+
+func CanReadCart(principal *middleware.Principal, ownerId int) bool {
+	return principal.UserId == ownerId || principal.Can(models.PermissionCartsReadAny)
+}
+
+func CanWriteCart(principal *middleware.Principal, ownerId int) bool {
+	return principal.UserId == ownerId || principal.Can(models.PermissionCartsWriteAny)
+}
+
+// CanCheckoutCart only lets customers check out their own carts, nobody
+// places orders on somebody else's behalf.
+func CanCheckoutCart(principal *middleware.Principal, ownerId int) bool {
+	return principal.UserId == ownerId
+}
+
+func CanListUserCarts(principal *middleware.Principal, userId int) bool {
+	return principal.UserId == userId || principal.Can(models.PermissionCartsReadAny)
+}
@@ -0,0 +1,12 @@
+package policy
+
+import "testing"
+
+func TestCartPolicies(t *testing.T) {
+	checkPolicies(t, []policyCase{
+		{"CanReadCart", CanReadCart, []string{"owner", "admin", "staff", "owner-admin"}},
+		{"CanWriteCart", CanWriteCart, []string{"owner", "admin", "owner-admin"}},
+		{"CanCheckoutCart", CanCheckoutCart, []string{"owner", "owner-admin"}},
+		{"CanListUserCarts", CanListUserCarts, []string{"owner", "admin", "staff", "owner-admin"}},
+	})
+}
@@ -0,0 +1,12 @@
+package policy
+
+import (
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+)
+
+// CanSeeItemLocations decides whether item responses include the stock held
+// at each warehouse, which only matters to whoever manages the inventory.
+func CanSeeItemLocations(principal *middleware.Principal) bool {
+	return principal.Can(models.PermissionInventoryManage)
+}
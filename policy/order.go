@@ -0,0 +1,26 @@
+package policy
+
+import (
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+)
+
+func CanReadOrder(principal *middleware.Principal, ownerId int) bool {
+	return principal.UserId == ownerId || principal.Can(models.PermissionOrdersReadAny)
+}
+
+// CanPayOrder follows CanCheckoutCart, only the customer who placed the order
+// pays for it.
+func CanPayOrder(principal *middleware.Principal, ownerId int) bool {
+	return principal.UserId == ownerId
+}
+
+func CanListUserOrders(principal *middleware.Principal, userId int) bool {
+	return principal.UserId == userId || principal.Can(models.PermissionOrdersReadAny)
+}
+
+// CanCancelOrder lets customers cancel their own orders, staff who can refund
+// may cancel anybody's.
+func CanCancelOrder(principal *middleware.Principal, ownerId int) bool {
+	return principal.UserId == ownerId || principal.Can(models.PermissionOrdersRefund)
+}
@@ -0,0 +1,12 @@
+package policy
+
+import "testing"
+
+func TestOrderPolicies(t *testing.T) {
+	checkPolicies(t, []policyCase{
+		{"CanReadOrder", CanReadOrder, []string{"owner", "admin", "staff", "owner-admin"}},
+		{"CanPayOrder", CanPayOrder, []string{"owner", "owner-admin"}},
+		{"CanListUserOrders", CanListUserOrders, []string{"owner", "admin", "staff", "owner-admin"}},
+		{"CanCancelOrder", CanCancelOrder, []string{"owner", "admin", "owner-admin"}},
+	})
+}
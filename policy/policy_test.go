@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+	"testing"
+)
+
+const (
+	ownerId = 1
+	otherId = 2
+)
+
+func principal(userId int, role string, permissions ...string) *middleware.Principal {
+	granted := make(map[string]bool)
+	for _, permission := range permissions {
+		granted[permission] = true
+	}
+
+	return &middleware.Principal{
+		UserId:      userId,
+		Role:        role,
+		Permissions: granted,
+	}
+}
+
+var (
+	customerPermissions = []string{
+		models.PermissionCartsRead,
+		models.PermissionCartsWrite,
+		models.PermissionOrdersRead,
+		models.PermissionOrdersPay,
+		models.PermissionOrdersCancel,
+	}
+
+	adminPermissions = []string{
+		models.PermissionCartsReadAny,
+		models.PermissionCartsWriteAny,
+		models.PermissionOrdersReadAny,
+		models.PermissionOrdersRefund,
+	}
+)
+
+// principals is everyone a policy is checked against: the owner of the
+// record, a customer who doesn't own it, an admin and a staff member who may
+// only read other people's records, neither owning it, an admin who owns it,
+// and an account stripped of every permission.
+var principals = []struct {
+	name      string
+	principal *middleware.Principal
+}{
+	{"owner", principal(ownerId, "user", customerPermissions...)},
+	{"non-owner", principal(otherId, "user", customerPermissions...)},
+	{"admin", principal(otherId, "admin", adminPermissions...)},
+	{"staff", principal(otherId, "staff", models.PermissionCartsReadAny, models.PermissionOrdersReadAny)},
+	{"owner-admin", principal(ownerId, "admin", append(customerPermissions, adminPermissions...)...)},
+	{"unprivileged", principal(otherId, "user")},
+}
+
+// policyCase names the principals check allows on a record of ownerId,
+// every other one must be denied.
+type policyCase struct {
+	name    string
+	check   func(*middleware.Principal, int) bool
+	allowed []string
+}
+
+func checkPolicies(t *testing.T, policies []policyCase) {
+	for _, policy := range policies {
+		allowed := make(map[string]bool)
+		for _, name := range policy.allowed {
+			allowed[name] = true
+		}
+
+		for _, p := range principals {
+			t.Run(policy.name+"/"+p.name, func(t *testing.T) {
+				if got := policy.check(p.principal, ownerId); got != allowed[p.name] {
+					t.Errorf("%s(%s) = %v, want %v", policy.name, p.name, got, allowed[p.name])
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Package pricing turns cart lines into the amounts a customer is charged.
+package pricing
+
+import (
+	"golang-final-project/models"
+	"os"
+	"strconv"
+)
+
+// Quote fills in each line's subtotal and returns the cart totals. Tax and
+// shipping are configured through the environment:
+//
+//	TAX_RATE_PERCENT       tax applied to the discounted subtotal
+//	SHIPPING_FEE           flat shipping fee per cart
+//	FREE_SHIPPING_MINIMUM  subtotal from which shipping is free, 0 disables it
+func Quote(lines []models.CartItem) models.PriceBreakdown {
+	var breakdown models.PriceBreakdown
+
+	for i := range lines {
+		lines[i].Subtotal = lines[i].UnitPrice * lines[i].Quantity
+		breakdown.Subtotal += lines[i].Subtotal
+	}
+
+	taxable := breakdown.Subtotal - breakdown.Discount
+	breakdown.Tax = taxable * envInt("TAX_RATE_PERCENT") / 100
+
+	freeShippingMinimum := envInt("FREE_SHIPPING_MINIMUM")
+	if len(lines) > 0 && (freeShippingMinimum == 0 || breakdown.Subtotal < freeShippingMinimum) {
+		breakdown.Shipping = envInt("SHIPPING_FEE")
+	}
+
+	breakdown.GrandTotal = taxable + breakdown.Tax + breakdown.Shipping
+
+	return breakdown
+}
+
+func envInt(key string) int {
+	value, _ := strconv.Atoi(os.Getenv(key))
+	return value
+}
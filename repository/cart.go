@@ -2,31 +2,76 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"golang-final-project/config"
 	"golang-final-project/models"
-	"log"
+	"golang-final-project/pricing"
+	"golang-final-project/storage"
 	"time"
 )
 
-func CreateCart(body models.PostCartBody) {
-	var cart models.PostCartBody
+var (
+	ErrItemNotFound      = errors.New("item doesn't exist")
+	ErrCartEmpty         = errors.New("cart has no items")
+	ErrCartItemNotFound  = errors.New("item is not in the cart")
+	ErrInsufficientStock = errors.New("not enough stock")
+)
+
+// PriceChangeError is returned by Checkout when an item's price changed after
+// it was added to the cart. The cart has been repriced by then, so checking
+// out again charges the new Pricing.
+type PriceChangeError struct {
+	Changes []models.PriceChange
+	Pricing models.PriceBreakdown
+}
+
+func (e *PriceChangeError) Error() string {
+	return "the price of one or more items has changed"
+}
 
+// ReplaceCart sets the lines of the user's active cart to body.Items, creating
+// the cart with body.Id if the user doesn't have one yet.
+func ReplaceCart(body models.PostCartBody) (*models.Cart, error) {
 	tx, err := config.Db.Begin()
 	if err != nil {
-		log.Fatal("Failed to begin transaction:", err)
+		return nil, err
 	}
 
-	defer func() {
+	available := make(map[int]int)
+
+	for i, item := range body.Items {
+		variant, err := resolveVariant(tx, item.ItemId, item.VariantId)
 		if err != nil {
 			tx.Rollback()
+			return nil, err
 		}
-	}()
+
+		body.Items[i].ItemId = variant.itemId
+		body.Items[i].VariantId = variant.id
+		body.Items[i].UnitPrice = variant.price
+		available[variant.id] = variant.available
+	}
+
+	lines := mergeCartItems(body.Items)
+	for i, line := range lines {
+		if line.Quantity > available[line.VariantId] {
+			tx.Rollback()
+			return nil, fmt.Errorf("%w for item %d", ErrInsufficientStock, line.ItemId)
+		}
+
+		lines[i].CartId = body.Id
+	}
+
+	breakdown := pricing.Quote(lines)
 
 	cartQuery := `
-	INSERT INTO carts (id, user_id, created_at, total_price, payment_method, payment_status)
-	VALUES ($1, $2, $3, $4, $5, $6)
-	RETURNING *
+	INSERT INTO carts (id, user_id, created_at, total_price, subtotal, discount, tax, shipping)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (user_id) DO UPDATE
+	SET total_price = EXCLUDED.total_price, subtotal = EXCLUDED.subtotal, discount = EXCLUDED.discount,
+		tax = EXCLUDED.tax, shipping = EXCLUDED.shipping
+	RETURNING id, created_at
 	`
 
 	err = tx.QueryRow(
@@ -34,88 +79,145 @@ func CreateCart(body models.PostCartBody) {
 		body.Id,
 		body.UserId,
 		body.CreatedAt,
-		body.TotalPrice,
-		body.PaymentMethod,
-		body.PaymentStatus,
-	).Scan(
-		&cart.Id,
-		&cart.UserId,
-		&cart.CreatedAt,
-		&cart.TotalPrice,
-		&cart.PaymentMethod,
-		&cart.PaymentStatus,
-	)
+		breakdown.GrandTotal,
+		breakdown.Subtotal,
+		breakdown.Discount,
+		breakdown.Tax,
+		breakdown.Shipping,
+	).Scan(&body.Id, &body.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 
+	_, err = tx.Exec(`DELETE FROM cart_items WHERE cart_id = $1`, body.Id)
 	if err != nil {
-		panic(err)
-	} else {
-		cartItemQuery := `
-		INSERT INTO cart_items (id, cart_id, item_id, quantity)
-		VALUES ($1, $2, $3, $4)
-		`
-
-		for _, item := range body.Items {
-			_, err = tx.Exec(
-				cartItemQuery,
-				item.Id,
-				body.Id,
-				item.ItemId,
-				item.Quantity,
-			)
-			if err != nil {
-				panic(err)
-			}
+		tx.Rollback()
+		return nil, err
+	}
+
+	cartItemQuery := `
+	INSERT INTO cart_items (id, cart_id, item_id, variant_id, quantity, unit_price)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	for _, line := range lines {
+		_, err = tx.Exec(
+			cartItemQuery,
+			line.Id,
+			body.Id,
+			line.ItemId,
+			line.VariantId,
+			line.Quantity,
+			line.UnitPrice,
+		)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
 		}
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		panic(err)
+	if err = tx.Commit(); err != nil {
+		return nil, err
 	}
+
+	return GetCartById(int64(body.Id))
 }
 
 func GetCarts() ([]models.Cart, error) {
-	var results []models.Cart
+	return queryCarts("")
+}
+
+func GetCartById(id int64) (*models.Cart, error) {
+	carts, err := queryCarts("WHERE i.id = $1", id)
 
+	if err != nil {
+		return nil, err
+	} else if len(carts) == 0 {
+		return nil, sql.ErrNoRows
+	} else {
+		return &carts[0], nil
+	}
+}
+
+// GetActiveCart returns the user's cart, creating an empty one with newCartId
+// on first use.
+func GetActiveCart(userId int, newCartId int) (*models.Cart, error) {
 	query := `
+	INSERT INTO carts (id, user_id, created_at, total_price)
+	VALUES ($1, $2, $3, 0)
+	ON CONFLICT (user_id) DO NOTHING
+	`
+
+	_, err := config.Db.Exec(query, newCartId, userId, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	carts, err := queryCarts("WHERE i.user_id = $1", userId)
+	if err != nil {
+		return nil, err
+	} else if len(carts) == 0 {
+		return nil, sql.ErrNoRows
+	} else {
+		return &carts[0], nil
+	}
+}
+
+func GetCartsByUserId(id int64) ([]models.Cart, error) {
+	return queryCarts("WHERE i.user_id = $1", id)
+}
+
+// queryCarts loads the carts matching condition together with their lines,
+// the lines' items and the items' primary images, newest cart first.
+func queryCarts(condition string, args ...interface{}) ([]models.Cart, error) {
+	results := []models.Cart{}
+
+	query := fmt.Sprintf(`
 	SELECT
-		i.id, i.user_id, i.created_at, i.total_price, i.payment_method, i.payment_status,
-		ii.id, ii.cart_id, ii.item_id, ii.quantity,
-		iii.id, iii.item_name, iii.price,
+		i.id, i.user_id, i.created_at, i.total_price,
+		i.subtotal, i.discount, i.tax, i.shipping,
+		ii.id, ii.cart_id, ii.item_id, ii.variant_id, ii.quantity, ii.unit_price,
+		iii.item_name, COALESCE(v.price, iii.price), v.sku, v.name,
 		iiii.id, iiii.item_id, iiii.image_url
 	FROM carts i
 	LEFT JOIN cart_items ii ON i.id = ii.cart_id
 	LEFT JOIN items iii ON iii.id = ii.item_id
-	LEFT JOIN items_images iiii ON iiii.item_id = iii.id
-	`
-
-	rows, err := config.Db.Query(query)
+	LEFT JOIN variants v ON v.id = ii.variant_id
+	LEFT JOIN items_images iiii ON iiii.item_id = iii.id AND iiii.is_primary
+	%s
+	ORDER BY i.created_at DESC, i.id, ii.id, iiii.id
+	`, condition)
 
+	rows, err := config.Db.Query(query, args...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer rows.Close()
 
-	// Maps to track grouping
-	cartMap := make(map[int]*models.Cart)
-	cartItemMap := make(map[int]*models.CartItem)
+	// Positions in results and in each cart's lines, to keep the SQL order
+	cartIndex := make(map[int]int)
+	cartItemIndex := make(map[int]int)
 
 	for rows.Next() {
 		var (
-			cartID, userID, cartItemID, cartItemCartID, cartItemItemID, quantity int
-			itemID, price                                                        int
-			itemName                                                             string
-			paymentMethod, paymentStatus                                         string
-			totalPrice                                                           sql.NullInt64
-			imageID, imageItemID                                                 sql.NullInt64
-			imageURL                                                             sql.NullString
-			createdAt                                                            time.Time
+			cartID, userID                             int
+			subtotal, discount, tax, shipping          int
+			totalPrice                                 sql.NullInt64
+			createdAt                                  time.Time
+			cartItemID, cartItemCartID, cartItemItemID sql.NullInt64
+			variantID                                  sql.NullInt64
+			quantity, unitPrice, price                 sql.NullInt64
+			itemName, sku, variantName                 sql.NullString
+			imageID, imageItemID                       sql.NullInt64
+			imageURL                                   sql.NullString
 		)
 
 		err := rows.Scan(
-			&cartID, &userID, &createdAt, &totalPrice, &paymentMethod, &paymentStatus,
-			&cartItemID, &cartItemCartID, &cartItemItemID, &quantity,
-			&itemID, &itemName, &price,
+			&cartID, &userID, &createdAt, &totalPrice,
+			&subtotal, &discount, &tax, &shipping,
+			&cartItemID, &cartItemCartID, &cartItemItemID, &variantID, &quantity, &unitPrice,
+			&itemName, &price, &sku, &variantName,
 			&imageID, &imageItemID, &imageURL,
 		)
 		if err != nil {
@@ -123,307 +225,305 @@ func GetCarts() ([]models.Cart, error) {
 		}
 
 		// Handle Cart
-		cart, exists := cartMap[cartID]
+		index, exists := cartIndex[cartID]
 		if !exists {
-			cart = &models.Cart{
-				Id:            cartID,
-				UserId:        userID,
-				CreatedAt:     createdAt,
-				TotalPrice:    int(totalPrice.Int64),
-				CartItems:     []models.CartItem{},
-				PaymentMethod: paymentMethod,
-				PaymentStatus: paymentStatus,
-			}
-			cartMap[cartID] = cart
+			results = append(results, models.Cart{
+				Id:         cartID,
+				UserId:     userID,
+				CreatedAt:  createdAt,
+				TotalPrice: int(totalPrice.Int64),
+				Pricing: models.PriceBreakdown{
+					Subtotal:   subtotal,
+					Discount:   discount,
+					Tax:        tax,
+					Shipping:   shipping,
+					GrandTotal: int(totalPrice.Int64),
+				},
+				CartItems: []models.CartItem{},
+			})
+			index = len(results) - 1
+			cartIndex[cartID] = index
 		}
+		cart := &results[index]
 
-		// Handle CartItem
-		cartItem, exists := cartItemMap[cartItemID]
-		if !exists && cartItemID != 0 {
-			item := &models.Item{
-				Id:       itemID,
-				ItemName: itemName,
-				Price:    price,
-				Images:   []models.ItemImages{},
-			}
-
-			cartItem = &models.CartItem{
-				Id:       cartItemID,
-				CartId:   cartItemCartID,
-				ItemId:   cartItemItemID,
-				Quantity: quantity,
-				Item:     item,
-			}
+		if !cartItemID.Valid {
+			continue
+		}
 
-			cart.CartItems = append(cart.CartItems, *cartItem)
-			cartItemMap[cartItemID] = cartItem
+		// Handle CartItem
+		lineIndex, exists := cartItemIndex[int(cartItemID.Int64)]
+		if !exists {
+			cart.CartItems = append(cart.CartItems, models.CartItem{
+				Id:           int(cartItemID.Int64),
+				CartId:       int(cartItemCartID.Int64),
+				ItemId:       int(cartItemItemID.Int64),
+				VariantId:    int(variantID.Int64),
+				Sku:          sku.String,
+				VariantName:  variantName.String,
+				Quantity:     int(quantity.Int64),
+				UnitPrice:    int(unitPrice.Int64),
+				Subtotal:     int(unitPrice.Int64 * quantity.Int64),
+				PriceChanged: unitPrice.Int64 != price.Int64,
+				Item: &models.Item{
+					Id:       int(cartItemItemID.Int64),
+					ItemName: itemName.String,
+					Price:    int(price.Int64),
+					Images:   []models.ItemImages{},
+				},
+			})
+			lineIndex = len(cart.CartItems) - 1
+			cartItemIndex[int(cartItemID.Int64)] = lineIndex
 		}
+		cartItem := &cart.CartItems[lineIndex]
 
 		// Handle Images
-		if cartItem != nil && imageID.Valid && imageURL.Valid {
+		if imageID.Valid && imageURL.Valid {
 			cartItem.Item.Images = append(cartItem.Item.Images, models.ItemImages{
-				Id:       int(imageID.Int64),
-				ItemId:   int(imageItemID.Int64),
-				ImageUrl: imageURL.String,
+				Id:        int(imageID.Int64),
+				ItemId:    int(imageItemID.Int64),
+				ImageUrl:  storage.AssetURL(imageURL.String),
+				IsPrimary: true,
 			})
 		}
 	}
 
-	for _, cart := range cartMap {
-		results = append(results, *cart)
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return results, nil
+	images := []*models.ItemImages{}
+	for i := range results {
+		for j := range results[i].CartItems {
+			item := results[i].CartItems[j].Item
+			for k := range item.Images {
+				images = append(images, &item.Images[k])
+			}
+			setPrimaryImage(item)
+		}
+	}
+
+	return results, loadImageRenditions(images)
 }
 
-func GetCartById(id int64) (*models.Cart, error) {
-	var cart *models.Cart
+// mergeCartItems collapses lines for the same variant into one, keeping the
+// first line's ID.
+func mergeCartItems(items []models.CartItem) []models.CartItem {
+	merged := []models.CartItem{}
+	index := make(map[int]int)
+
+	for _, item := range items {
+		if i, exists := index[item.VariantId]; exists {
+			merged[i].Quantity += item.Quantity
+		} else {
+			index[item.VariantId] = len(merged)
+			merged = append(merged, item)
+		}
+	}
+
+	return merged
+}
+
+// AddCartItem adds quantity of a variant to the cart, merging it into the
+// existing line for that variant and refreshing its price snapshot.
+func AddCartItem(cartId int64, line models.CartItem) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = lockCart(tx, cartId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	variant, err := resolveVariant(tx, line.ItemId, line.VariantId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var current int
+	err = tx.QueryRow(
+		`SELECT quantity FROM cart_items WHERE cart_id = $1 AND variant_id = $2`,
+		cartId,
+		variant.id,
+	).Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return err
+	}
+
+	if current+line.Quantity > variant.available {
+		tx.Rollback()
+		return ErrInsufficientStock
+	}
 
 	query := `
-	SELECT
-		i.id, i.user_id, i.created_at, i.total_price, i.payment_method, i.payment_status,
-		ii.id, ii.cart_id, ii.item_id, ii.quantity,
-		iii.id, iii.item_name, iii.price,
-		iiii.id, iiii.item_id, iiii.image_url
-	FROM carts i
-	LEFT JOIN cart_items ii ON i.id = ii.cart_id
-	LEFT JOIN items iii ON iii.id = ii.item_id
-	LEFT JOIN items_images iiii ON iiii.item_id = iii.id
-	WHERE i.id = $1
+	INSERT INTO cart_items (id, cart_id, item_id, variant_id, quantity, unit_price)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (cart_id, variant_id) DO UPDATE
+	SET quantity = cart_items.quantity + EXCLUDED.quantity, unit_price = EXCLUDED.unit_price
 	`
 
-	rows, err := config.Db.Query(query, id)
-
+	_, err = tx.Exec(query, line.Id, cartId, variant.itemId, variant.id, line.Quantity, variant.price)
 	if err != nil {
-		panic(err)
+		tx.Rollback()
+		return err
 	}
-	defer rows.Close()
 
-	cartItemMap := make(map[int]*models.CartItem)
+	if err = repriceCart(tx, cartId); err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	for rows.Next() {
-		var (
-			cartID, userID, cartItemID, cartItemCartID, cartItemItemID, quantity int
-			itemID, price                                                        int
-			itemName                                                             string
-			paymentMethod, paymentStatus                                         string
-			totalPrice                                                           sql.NullInt64
-			imageID, imageItemID                                                 sql.NullInt64
-			imageURL                                                             sql.NullString
-			createdAt                                                            time.Time
-		)
+	return tx.Commit()
+}
 
-		err := rows.Scan(
-			&cartID, &userID, &createdAt, &totalPrice, &paymentMethod, &paymentStatus,
-			&cartItemID, &cartItemCartID, &cartItemItemID, &quantity,
-			&itemID, &itemName, &price,
-			&imageID, &imageItemID, &imageURL,
-		)
-		if err != nil {
-			return nil, err
-		}
+// UpdateCartItemQuantity sets the quantity of the cart's line for the item.
+// variantId picks the line when the cart holds several variants of the
+// item, it can be 0 otherwise.
+func UpdateCartItemQuantity(cartId int64, itemId int64, variantId int64, quantity int) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
 
-		// Handle Cart
-		cart = &models.Cart{
-			Id:            cartID,
-			UserId:        userID,
-			CreatedAt:     createdAt,
-			TotalPrice:    int(totalPrice.Int64),
-			CartItems:     []models.CartItem{},
-			PaymentMethod: paymentMethod,
-			PaymentStatus: paymentStatus,
-		}
+	if err = lockCart(tx, cartId); err != nil {
+		tx.Rollback()
+		return err
+	}
 
-		// Handle CartItem
-		cartItem, exists := cartItemMap[cartItemID]
-		if !exists && cartItemID != 0 {
-			item := &models.Item{
-				Id:       itemID,
-				ItemName: itemName,
-				Price:    price,
-				Images:   []models.ItemImages{},
-			}
+	lineVariantId, err := cartLineVariant(tx, cartId, itemId, variantId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-			cartItem = &models.CartItem{
-				Id:       cartItemID,
-				CartId:   cartItemCartID,
-				ItemId:   cartItemItemID,
-				Quantity: quantity,
-				Item:     item,
-			}
+	variant, err := resolveVariant(tx, int(itemId), lineVariantId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-			cart.CartItems = append(cart.CartItems, *cartItem)
-			cartItemMap[cartItemID] = cartItem
-		}
+	if quantity > variant.available {
+		tx.Rollback()
+		return ErrInsufficientStock
+	}
 
-		// Handle Images
-		if cartItem != nil && imageID.Valid && imageURL.Valid {
-			cartItem.Item.Images = append(cartItem.Item.Images, models.ItemImages{
-				Id:       int(imageID.Int64),
-				ItemId:   int(imageItemID.Int64),
-				ImageUrl: imageURL.String,
-			})
-		}
+	_, err = tx.Exec(
+		`UPDATE cart_items SET quantity = $3, unit_price = $4 WHERE cart_id = $1 AND variant_id = $2`,
+		cartId,
+		variant.id,
+		quantity,
+		variant.price,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	if cart == nil {
-		return nil, sql.ErrNoRows
-	} else {
-		return cart, nil
+	if err = repriceCart(tx, cartId); err != nil {
+		tx.Rollback()
+		return err
 	}
+
+	return tx.Commit()
 }
 
-func GetCartsByUserId(id int64) ([]models.Cart, error) {
-	var results []models.Cart
+func RemoveCartItem(cartId int64, itemId int64, variantId int64) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
 
-	query := `
-	SELECT
-		i.id, i.user_id, i.created_at, i.total_price, i.payment_method, i.payment_status,
-		ii.id, ii.cart_id, ii.item_id, ii.quantity,
-		iii.id, iii.item_name, iii.price,
-		iiii.id, iiii.item_id, iiii.image_url
-	FROM carts i
-	LEFT JOIN cart_items ii ON i.id = ii.cart_id
-	LEFT JOIN items iii ON iii.id = ii.item_id
-	LEFT JOIN items_images iiii ON iiii.item_id = iii.id
-	WHERE i.user_id = $1
-	`
+	if err = lockCart(tx, cartId); err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	rows, err := config.Db.Query(query, id)
+	lineVariantId, err := cartLineVariant(tx, cartId, itemId, variantId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
+	_, err = tx.Exec(`DELETE FROM cart_items WHERE cart_id = $1 AND variant_id = $2`, cartId, lineVariantId)
 	if err != nil {
-		panic(err)
+		tx.Rollback()
+		return err
 	}
-	defer rows.Close()
 
-	// Maps to track grouping
-	cartMap := make(map[int]*models.Cart)
-	cartItemMap := make(map[int]*models.CartItem)
+	if err = repriceCart(tx, cartId); err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	for rows.Next() {
-		var (
-			cartID, userID, cartItemID, cartItemCartID, cartItemItemID, quantity int
-			itemID, price                                                        int
-			itemName                                                             string
-			paymentMethod, paymentStatus                                         string
-			totalPrice                                                           sql.NullInt64
-			imageID, imageItemID                                                 sql.NullInt64
-			imageURL                                                             sql.NullString
-			createdAt                                                            time.Time
-		)
+	return tx.Commit()
+}
 
-		err := rows.Scan(
-			&cartID, &userID, &createdAt, &totalPrice, &paymentMethod, &paymentStatus,
-			&cartItemID, &cartItemCartID, &cartItemItemID, &quantity,
-			&itemID, &itemName, &price,
-			&imageID, &imageItemID, &imageURL,
-		)
-		if err != nil {
-			return nil, err
-		}
+// cartLineVariant returns the variant of the cart's line for the item,
+// narrowed down to variantId unless that is 0.
+func cartLineVariant(tx *sql.Tx, cartId, itemId, variantId int64) (int, error) {
+	rows, err := tx.Query(
+		`SELECT variant_id FROM cart_items WHERE cart_id = $1 AND item_id = $2 AND ($3 = 0 OR variant_id = $3)`,
+		cartId,
+		itemId,
+		variantId,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
 
-		// Handle Cart
-		cart, exists := cartMap[cartID]
-		if !exists {
-			cart = &models.Cart{
-				Id:            cartID,
-				UserId:        userID,
-				CreatedAt:     createdAt,
-				TotalPrice:    int(totalPrice.Int64),
-				CartItems:     []models.CartItem{},
-				PaymentMethod: paymentMethod,
-				PaymentStatus: paymentStatus,
-			}
-			cartMap[cartID] = cart
+	variants := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
 		}
+		variants = append(variants, id)
+	}
 
-		// Handle CartItem
-		cartItem, exists := cartItemMap[cartItemID]
-		if !exists && cartItemID != 0 {
-			item := &models.Item{
-				Id:       itemID,
-				ItemName: itemName,
-				Price:    price,
-				Images:   []models.ItemImages{},
-			}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	} else if len(variants) == 0 {
+		return 0, ErrCartItemNotFound
+	} else if len(variants) > 1 {
+		return 0, ErrVariantRequired
+	} else {
+		return variants[0], nil
+	}
+}
 
-			cartItem = &models.CartItem{
-				Id:       cartItemID,
-				CartId:   cartItemCartID,
-				ItemId:   cartItemItemID,
-				Quantity: quantity,
-				Item:     item,
-			}
+// lockCart locks the cart row for the rest of tx, so concurrent edits and a
+// checkout of the same cart are applied one after the other.
+func lockCart(tx *sql.Tx, cartId int64) error {
+	var id int64
 
-			cart.CartItems = append(cart.CartItems, *cartItem)
-			cartItemMap[cartItemID] = cartItem
-		}
+	return tx.QueryRow(`SELECT id FROM carts WHERE id = $1 FOR UPDATE`, cartId).Scan(&id)
+}
 
-		// Handle Images
-		if cartItem != nil && imageID.Valid && imageURL.Valid {
-			cartItem.Item.Images = append(cartItem.Item.Images, models.ItemImages{
-				Id:       int(imageID.Int64),
-				ItemId:   int(imageItemID.Int64),
-				ImageUrl: imageURL.String,
-			})
-		}
+// repriceCart recomputes the cart totals from its lines' price snapshots.
+func repriceCart(tx *sql.Tx, cartId int64) error {
+	rows, err := tx.Query(`SELECT id, quantity, unit_price FROM cart_items WHERE cart_id = $1`, cartId)
+	if err != nil {
+		return err
 	}
 
-	for _, cart := range cartMap {
-		results = append(results, *cart)
+	lines := []models.CartItem{}
+	for rows.Next() {
+		var line models.CartItem
+		if err := rows.Scan(&line.Id, &line.Quantity, &line.UnitPrice); err != nil {
+			rows.Close()
+			return err
+		}
+		lines = append(lines, line)
 	}
+	rows.Close()
 
-	return results, nil
+	return updateCartPricing(tx, cartId, pricing.Quote(lines))
 }
 
-// func UpdateCart(cartId int64, updates []models.CartItemUpdate) error {
-// 	if len(updates) == 0 {
-// 		return nil
-// 	} else {
-// 		query := `UPDATE cart_items SET quantity = CASE item_id`
-// 		args := []interface{}{cartId}
-// 		idPlaceholders := ""
-
-// 		for i, u := range updates {
-// 			argPos := i*2 + 2
-// 			query += fmt.Sprintf(" WHEN $%d THEN $%d", argPos, argPos+1)
-// 			args = append(args, u.ItemId, u.Quantity)
-
-// 			if i > 0 {
-// 				idPlaceholders += ", "
-// 			}
-// 			idPlaceholders += fmt.Sprintf("$%d", argPos)
-// 		}
-
-// 		query += fmt.Sprintf(" ELSE quantity END WHERE cart_id = $1 AND id IN (%s)", idPlaceholders)
-
-// 		fmt.Println("Final query:", query)
-// 		fmt.Println("Args:", args)
-
-// 		_, err := config.Db.Exec(query, args...)
-// 		return err
-// 	}
-// }
-
-// func DeleteCartItems(cartId int64, itemIds []int) error {
-// 	query := `DELETE FROM cart_items WHERE cart_id = $1 AND item_id IN (`
-// 	args := []interface{}{cartId}
-// 	placeholders := ""
-
-// 	for i, id := range itemIds {
-// 		if i > 0 {
-// 			placeholders += ", "
-// 		}
-// 		placeholders += fmt.Sprintf("$%d", i+2)
-// 		args = append(args, id)
-// 	}
-
-// 	query += placeholders + ")"
-
-// 	_, err := config.Db.Exec(query, args...)
-// 	return err
-// }
-
 func DeleteCart(id int64) (int64, error) {
 	sqlStatement := `DELETE from carts WHERE id = $1`
 
@@ -440,63 +540,28 @@ func DeleteCart(id int64) (int64, error) {
 	}
 }
 
-func PayCart(id int64) (int64, error) {
-	tx, err := config.Db.Begin()
-	if err != nil {
-		return 0, err
-	}
-
-	updateCartQuery := `
+func updateCartPricing(tx *sql.Tx, cartId int64, breakdown models.PriceBreakdown) error {
+	query := `
 	UPDATE carts
-	SET payment_status = $2
+	SET total_price = $2, subtotal = $3, discount = $4, tax = $5, shipping = $6
 	WHERE id = $1
 	`
 
-	_, err = tx.Exec(
-		updateCartQuery,
-		id,
-		"Paid",
+	_, err := tx.Exec(
+		query,
+		cartId,
+		breakdown.GrandTotal,
+		breakdown.Subtotal,
+		breakdown.Discount,
+		breakdown.Tax,
+		breakdown.Shipping,
 	)
-	if err != nil {
-		tx.Rollback()
-		return 0, err
-	}
-
-	updateStockQuery := `
-	UPDATE items
-	SET stock = stock - ci.quantity
-	FROM cart_items ci
-	WHERE items.id = ci.item_id AND ci.cart_id = $1
-	`
-
-	_, err = tx.Exec(updateStockQuery, id)
-	if err != nil {
-		tx.Rollback()
-		return 0, err
-	}
-
-	// Check if any stock went negative (business rule check)
-	stockCheckQuery := `
-	SELECT COUNT(*) FROM items
-	WHERE stock < 0
-	`
-
-	var negativeCount int
-	err = tx.QueryRow(stockCheckQuery).Scan(&negativeCount)
-	if err != nil {
-		tx.Rollback()
-		return 0, err
-	}
-
-	if negativeCount > 0 {
-		tx.Rollback()
-		return 0, fmt.Errorf("not enough stock for one or more items")
-	}
+	return err
+}
 
-	err = tx.Commit()
-	if err != nil {
-		return 0, err
-	}
+func GetCartOwner(id int64) (int, error) {
+	var userId int
 
-	return 1, nil
+	err := config.Db.QueryRow(`SELECT user_id FROM carts WHERE id = $1`, id).Scan(&userId)
+	return userId, err
 }
@@ -0,0 +1,424 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/config"
+	"golang-final-project/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrCategoryNotFound       = errors.New("category doesn't exist")
+	ErrParentCategoryNotFound = errors.New("parent category doesn't exist")
+	ErrCategoryCycle          = errors.New("a category cannot be moved under itself or one of its subcategories")
+	ErrCategoryInUse          = errors.New("category still has subcategories or items")
+	ErrTagNotFound            = errors.New("tag doesn't exist")
+	ErrUnknownTag             = errors.New("one or more tags don't exist")
+)
+
+// GetCategoryTree returns the root categories with their subcategories
+// nested under them.
+func GetCategoryTree() ([]models.Category, error) {
+	rows, err := config.Db.Query(`
+	SELECT id, parent_id, name, slug, position, created_at
+	FROM categories
+	ORDER BY position, name, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+
+	for rows.Next() {
+		var (
+			category models.Category
+			parentId sql.NullInt64
+		)
+
+		if err := rows.Scan(&category.Id, &parentId, &category.Name, &category.Slug, &category.Position, &category.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		if parentId.Valid {
+			parent := int(parentId.Int64)
+			category.ParentId = &parent
+		}
+		categories = append(categories, category)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	children := make(map[int][]models.Category)
+	for _, category := range categories {
+		if category.ParentId != nil {
+			children[*category.ParentId] = append(children[*category.ParentId], category)
+		}
+	}
+
+	var build func(category models.Category) models.Category
+	build = func(category models.Category) models.Category {
+		category.Children = []models.Category{}
+		for _, child := range children[category.Id] {
+			category.Children = append(category.Children, build(child))
+		}
+		return category
+	}
+
+	tree := []models.Category{}
+	for _, category := range categories {
+		if category.ParentId == nil {
+			tree = append(tree, build(category))
+		}
+	}
+
+	return tree, nil
+}
+
+func GetCategoryById(id int64) (*models.Category, error) {
+	var (
+		category models.Category
+		parentId sql.NullInt64
+	)
+
+	err := config.Db.QueryRow(
+		`SELECT id, parent_id, name, slug, position, created_at FROM categories WHERE id = $1`,
+		id,
+	).Scan(&category.Id, &parentId, &category.Name, &category.Slug, &category.Position, &category.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if parentId.Valid {
+		parent := int(parentId.Int64)
+		category.ParentId = &parent
+	}
+	category.Children = []models.Category{}
+
+	return &category, nil
+}
+
+// CategorySlugTaken reports whether a category other than exceptId uses
+// slug.
+func CategorySlugTaken(slug string, exceptId int64) (bool, error) {
+	var taken bool
+
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM categories WHERE slug = $1 AND id <> $2)`, slug, exceptId).Scan(&taken)
+	return taken, err
+}
+
+func CreateCategory(category models.Category) error {
+	if category.ParentId != nil {
+		if err := categoryExists(int64(*category.ParentId), ErrParentCategoryNotFound); err != nil {
+			return err
+		}
+	}
+
+	_, err := config.Db.Exec(
+		`INSERT INTO categories (id, parent_id, name, slug, position, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		category.Id,
+		category.ParentId,
+		category.Name,
+		category.Slug,
+		category.Position,
+		category.CreatedAt,
+	)
+	return err
+}
+
+// UpdateCategory renames, reorders or moves the category. Moving it under
+// one of its own subcategories would cut the branch off the tree and is
+// refused with ErrCategoryCycle.
+func UpdateCategory(id int64, body models.CategoryBody) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRow(`SELECT TRUE FROM categories WHERE id = $1 FOR UPDATE`, id).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return ErrCategoryNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if body.ParentId != nil {
+		var inBranch bool
+
+		query := `
+		WITH RECURSIVE branch AS (
+			SELECT id FROM categories WHERE id = $1
+			UNION ALL
+			SELECT c.id FROM categories c JOIN branch b ON c.parent_id = b.id
+		)
+		SELECT EXISTS (SELECT 1 FROM branch WHERE id = $2)
+		`
+
+		if err = tx.QueryRow(query, id, *body.ParentId).Scan(&inBranch); err != nil {
+			tx.Rollback()
+			return err
+		} else if inBranch {
+			tx.Rollback()
+			return ErrCategoryCycle
+		}
+
+		err = tx.QueryRow(`SELECT TRUE FROM categories WHERE id = $1`, *body.ParentId).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			tx.Rollback()
+			return ErrParentCategoryNotFound
+		} else if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		`UPDATE categories SET parent_id = $2, name = $3, slug = $4, position = $5 WHERE id = $1`,
+		id,
+		body.ParentId,
+		body.Name,
+		body.Slug,
+		body.Position,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteCategory removes a category that is empty. Subcategories and items
+// have to be moved elsewhere first, so nothing silently loses its place in
+// the tree.
+func DeleteCategory(id int64) error {
+	var inUse bool
+
+	query := `
+	SELECT
+		EXISTS (SELECT 1 FROM categories WHERE parent_id = c.id) OR
+		EXISTS (SELECT 1 FROM items WHERE category_id = c.id)
+	FROM categories c
+	WHERE c.id = $1
+	`
+
+	err := config.Db.QueryRow(query, id).Scan(&inUse)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrCategoryNotFound
+	} else if err != nil {
+		return err
+	} else if inUse {
+		return ErrCategoryInUse
+	}
+
+	_, err = config.Db.Exec(`DELETE FROM categories WHERE id = $1`, id)
+	return err
+}
+
+func categoryExists(id int64, notFound error) error {
+	var exists bool
+
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	} else if !exists {
+		return notFound
+	} else {
+		return nil
+	}
+}
+
+// SetItemCategory files the item under categoryId, or takes it out of any
+// category when categoryId is nil.
+func SetItemCategory(itemId int64, categoryId *int) error {
+	if categoryId != nil {
+		if err := categoryExists(int64(*categoryId), ErrCategoryNotFound); err != nil {
+			return err
+		}
+	}
+
+	res, err := config.Db.Exec(`UPDATE items SET category_id = $2 WHERE id = $1`, itemId, categoryId)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return ErrItemNotFound
+	} else {
+		return nil
+	}
+}
+
+func GetTags() ([]models.Tag, error) {
+	results := []models.Tag{}
+
+	rows, err := config.Db.Query(`SELECT id, name, slug, created_at FROM tags ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag models.Tag
+
+		if err := rows.Scan(&tag.Id, &tag.Name, &tag.Slug, &tag.CreatedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, tag)
+	}
+
+	return results, rows.Err()
+}
+
+// TagSlugTaken reports whether a tag other than exceptId uses slug.
+func TagSlugTaken(slug string, exceptId int64) (bool, error) {
+	var taken bool
+
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM tags WHERE slug = $1 AND id <> $2)`, slug, exceptId).Scan(&taken)
+	return taken, err
+}
+
+func CreateTag(tag models.Tag) error {
+	_, err := config.Db.Exec(
+		`INSERT INTO tags (id, name, slug, created_at) VALUES ($1, $2, $3, $4)`,
+		tag.Id,
+		tag.Name,
+		tag.Slug,
+		tag.CreatedAt,
+	)
+	return err
+}
+
+func UpdateTag(id int64, body models.TagBody) error {
+	res, err := config.Db.Exec(`UPDATE tags SET name = $2, slug = $3 WHERE id = $1`, id, body.Name, body.Slug)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return ErrTagNotFound
+	} else {
+		return nil
+	}
+}
+
+// DeleteTag removes the tag, and with it, from every item tagged with it.
+func DeleteTag(id int64) error {
+	res, err := config.Db.Exec(`DELETE FROM tags WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return ErrTagNotFound
+	} else {
+		return nil
+	}
+}
+
+// SetItemTags replaces the item's tags with the tags having the given
+// slugs.
+func SetItemTags(itemId int64, slugs []string) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRow(`SELECT TRUE FROM items WHERE id = $1 FOR UPDATE`, itemId).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return ErrItemNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM item_tags WHERE item_id = $1`, itemId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	unique := make(map[string]bool)
+	for _, slug := range slugs {
+		unique[slug] = true
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO item_tags (item_id, tag_id) SELECT $1, id FROM tags WHERE slug = ANY($2)`,
+		itemId,
+		pq.Array(slugs),
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if count, err := res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return err
+	} else if int(count) != len(unique) {
+		tx.Rollback()
+		return ErrUnknownTag
+	}
+
+	return tx.Commit()
+}
+
+// loadItemTags fills in the slugs of the items' tags.
+func loadItemTags(items []models.Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(items))
+	index := make(map[int]int)
+	for i := range items {
+		ids[i] = items[i].Id
+		index[items[i].Id] = i
+		items[i].Tags = []string{}
+	}
+
+	rows, err := config.Db.Query(`
+	SELECT it.item_id, t.slug
+	FROM item_tags it
+	JOIN tags t ON t.id = it.tag_id
+	WHERE it.item_id = ANY($1)
+	ORDER BY t.slug
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			itemId int
+			slug   string
+		)
+
+		if err := rows.Scan(&itemId, &slug); err != nil {
+			return err
+		}
+		items[index[itemId]].Tags = append(items[index[itemId]].Tags, slug)
+	}
+
+	return rows.Err()
+}
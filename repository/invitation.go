@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/config"
+	"golang-final-project/models"
+	"time"
+)
+
+var (
+	ErrInvitationNotFound = errors.New("invitation doesn't exist")
+	ErrInvitationAccepted = errors.New("invitation has already been accepted")
+	ErrInvitationExpired  = errors.New("invitation has expired")
+	ErrUsernameTaken      = errors.New("username has been taken")
+)
+
+func CreateInvitation(invitation models.Invitation) error {
+	query := `
+	INSERT INTO user_invitations (id, role, created_by, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := config.Db.Exec(
+		query,
+		invitation.Id,
+		invitation.Role,
+		invitation.CreatedBy,
+		invitation.CreatedAt,
+		invitation.ExpiresAt,
+	)
+	return err
+}
+
+// AcceptInvitation creates user with the role the invitation was issued for
+// and marks the invitation as used, so it can only be redeemed once.
+func AcceptInvitation(invitationId int, user models.User) (*models.User, error) {
+	var (
+		role       string
+		expiresAt  time.Time
+		acceptedAt sql.NullTime
+		exists     bool
+	)
+
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	selectQuery := `
+	SELECT role, expires_at, accepted_at
+	FROM user_invitations
+	WHERE id = $1
+	FOR UPDATE
+	`
+
+	err = tx.QueryRow(selectQuery, invitationId).Scan(&role, &expiresAt, &acceptedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return nil, ErrInvitationNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if acceptedAt.Valid {
+		tx.Rollback()
+		return nil, ErrInvitationAccepted
+	} else if time.Now().After(expiresAt) {
+		tx.Rollback()
+		return nil, ErrInvitationExpired
+	}
+
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)`, user.Username).Scan(&exists)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if exists {
+		tx.Rollback()
+		return nil, ErrUsernameTaken
+	}
+
+	user.Role = role
+
+	_, err = tx.Exec(
+		`INSERT INTO users (id, username, password, role) VALUES ($1, $2, $3, $4)`,
+		user.Id,
+		user.Username,
+		user.Password,
+		user.Role,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE user_invitations SET accepted_by = $2, accepted_at = $3 WHERE id = $1`,
+		invitationId,
+		user.Id,
+		time.Now(),
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
@@ -2,10 +2,19 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"golang-final-project/config"
 	"golang-final-project/models"
+	"golang-final-project/storage"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 func CreateItem(i models.Item) {
@@ -43,12 +52,51 @@ func CreateItem(i models.Item) {
 		panic(err)
 	}
 
-	// Insert item images
-	for _, img := range i.Images {
+	// Opening stock goes to the item's default variant
+	variantId, err := createDefaultVariant(tx, insertedId, i.Stock, *i.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		panic(err)
+	}
+
+	// and is kept at the default warehouse
+	var warehouseId int
+	err = tx.QueryRow(
+		`INSERT INTO warehouse_stock (warehouse_id, item_id, variant_id, stock) SELECT id, $1, $2, $3 FROM warehouses WHERE is_default RETURNING warehouse_id`,
+		insertedId,
+		variantId,
+		i.Stock,
+	).Scan(&warehouseId)
+
+	if err != nil {
+		tx.Rollback()
+		panic(err)
+	}
+
+	// Opening entry of the item's inventory ledger
+	movement := models.StockMovement{
+		ItemId:      insertedId,
+		VariantId:   &variantId,
+		WarehouseId: &warehouseId,
+		Kind:        models.StockMovementInitial,
+		Delta:       i.Stock,
+		StockAfter:  i.Stock,
+		Reason:      "item created",
+	}
+	if actorId, err := strconv.Atoi(i.CreatedBy); err == nil {
+		movement.ActorId = &actorId
+	}
+	if err := recordStockMovement(tx, &movement); err != nil {
+		tx.Rollback()
+		panic(err)
+	}
+
+	// Insert item images, the first one uploaded is primary
+	for position, img := range i.Images {
 		img.ItemId = insertedId
-		query := `INSERT INTO items_images (id, item_id, image_url) VALUES ($1, $2, $3)`
-		_, err := tx.Exec(query, img.Id, img.ItemId, img.ImageUrl)
-		if err != nil {
+		img.Position = position
+		img.IsPrimary = position == 0
+		if err := insertItemImage(tx, img); err != nil {
 			tx.Rollback()
 			panic(err)
 		}
@@ -61,89 +109,396 @@ func CreateItem(i models.Item) {
 	fmt.Printf("Inserted item with ID %d and %d images\n", insertedId, len(i.Images))
 }
 
-func GetItems() ([]models.Item, error) {
-	var results []models.Item
+var ErrInvalidCursor = errors.New("cursor is invalid or belongs to a different sort order")
 
-	sqlStatement := `
+type itemSort struct {
+	column string
+	cast   string
+	desc   bool
+	value  func(models.Item) string
+}
+
+var itemSorts = map[string]itemSort{
+	models.ItemSortName:      {"i.item_name", "text", false, itemNameValue},
+	models.ItemSortNameDesc:  {"i.item_name", "text", true, itemNameValue},
+	models.ItemSortPrice:     {"i.price", "int", false, itemPriceValue},
+	models.ItemSortPriceDesc: {"i.price", "int", true, itemPriceValue},
+	models.ItemSortNewest:    {"i.created_at", "timestamp", true, itemCreatedValue},
+	models.ItemSortOldest:    {"i.created_at", "timestamp", false, itemCreatedValue},
+	models.ItemSortStock:     {"GREATEST(i.stock - i.reserved_stock, 0)", "int", false, itemStockValue},
+	models.ItemSortStockDesc: {"GREATEST(i.stock - i.reserved_stock, 0)", "int", true, itemStockValue},
+}
+
+func itemNameValue(item models.Item) string  { return item.ItemName }
+func itemPriceValue(item models.Item) string { return strconv.Itoa(item.Price) }
+func itemStockValue(item models.Item) string { return strconv.Itoa(item.Available) }
+func itemCreatedValue(item models.Item) string {
+	return item.CreatedAt.Format(time.RFC3339Nano)
+}
+
+func IsItemSort(sort string) bool {
+	_, ok := itemSorts[sort]
+	return ok
+}
+
+// itemCursor points at the item a page ended on, by the value it was sorted
+// on and its ID to break ties.
+type itemCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Id    int    `json:"id"`
+}
+
+func encodeItemCursor(sort string, item models.Item) string {
+	data, _ := json.Marshal(itemCursor{Sort: sort, Value: itemSorts[sort].value(item), Id: item.Id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeItemCursor(sort, encoded string) (*itemCursor, error) {
+	var cursor itemCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || json.Unmarshal(data, &cursor) != nil || cursor.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// itemConditions turns the query's filters into WHERE conditions, numbering
+// their placeholders after the args already given.
+func itemConditions(query models.ItemQuery, args []interface{}) ([]string, []interface{}) {
+	conditions := []string{}
+
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if query.MinPrice != nil {
+		add("i.price >= $%d", *query.MinPrice)
+	}
+	if query.MaxPrice != nil {
+		add("i.price <= $%d", *query.MaxPrice)
+	}
+	if query.InStock != nil && *query.InStock {
+		conditions = append(conditions, "i.stock - i.reserved_stock > 0")
+	} else if query.InStock != nil {
+		conditions = append(conditions, "i.stock - i.reserved_stock <= 0")
+	}
+	if query.CreatedBy != "" {
+		add("i.created_by = $%d", query.CreatedBy)
+	}
+	if query.Category != "" {
+		add(`i.category_id IN (
+			WITH RECURSIVE branch AS (
+				SELECT id FROM categories WHERE slug = $%[1]d OR id::text = $%[1]d
+				UNION ALL
+				SELECT c.id FROM categories c JOIN branch b ON c.parent_id = b.id
+			)
+			SELECT id FROM branch
+		)`, query.Category)
+	}
+	if len(query.Tags) > 0 {
+		add(`i.id IN (
+			SELECT it.item_id FROM item_tags it JOIN tags t ON t.id = it.tag_id
+			WHERE t.slug = ANY($%[1]d)
+			GROUP BY it.item_id
+			HAVING COUNT(*) = cardinality($%[1]d::text[])
+		)`, pq.Array(query.Tags))
+	}
+	if query.CreatedAfter != nil {
+		add("i.created_at >= $%d", *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		add("i.created_at < $%d", *query.CreatedBefore)
+	}
+	if query.ModifiedAfter != nil {
+		add("i.modified_at >= $%d", *query.ModifiedAfter)
+	}
+	if query.ModifiedBefore != nil {
+		add("i.modified_at < $%d", *query.ModifiedBefore)
+	}
+
+	return conditions, args
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+// GetItems returns one page of the items matching query's filters. Only the
+// page's rows are read; the total is counted separately and images are
+// loaded for the page's items alone.
+func GetItems(query models.ItemQuery) (*models.ItemPage, error) {
+	sort := itemSorts[query.Sort]
+
+	page := models.ItemPage{
+		Items: []models.Item{},
+		Limit: query.Limit,
+	}
+
+	conditions, args := itemConditions(query, nil)
+
+	countQuery := `SELECT COUNT(*) FROM items i ` + whereClause(conditions)
+	if err := config.Db.QueryRow(countQuery, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	// Paging backwards reads the items before the cursor in reverse and
+	// flips them afterwards.
+	desc := sort.desc
+	cursorParam := query.After
+	if query.Before != "" {
+		desc = !desc
+		cursorParam = query.Before
+	}
+
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if cursorParam != "" {
+		cursor, err := decodeItemCursor(query.Sort, cursorParam)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, cursor.Value, cursor.Id)
+		conditions = append(conditions, fmt.Sprintf(
+			"(%s, i.id) %s ($%d::%s, $%d)",
+			sort.column, comparison, len(args)-1, sort.cast, len(args),
+		))
+	}
+
+	args = append(args, query.Limit+1, query.Offset)
+
+	pageQuery := fmt.Sprintf(`
 	SELECT
-		i.id, i.item_name, i.description, i.price, i.stock,
-		i.created_at, i.created_by, i.modified_at, i.modified_by,
-		ii.id, ii.item_id, ii.image_url
+		i.id, i.item_name, COALESCE(i.description, ''), i.price, i.stock, i.reserved_stock,
+		i.created_at, i.created_by, i.modified_at, i.modified_by, i.category_id
 	FROM items i
-	LEFT JOIN items_images ii ON i.id = ii.item_id;
-	`
-
-	rows, err := config.Db.Query(sqlStatement)
+	%s
+	ORDER BY %s %s, i.id %s
+	LIMIT $%d OFFSET $%d
+	`, whereClause(conditions), sort.column, direction, direction, len(args)-1, len(args))
 
+	rows, err := config.Db.Query(pageQuery, args...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer rows.Close()
 
-	itemMap := make(map[int]*models.Item)
-
 	for rows.Next() {
 		var (
-			itemId                int
-			itemName, description string
-			price, stock          int
+			item                  models.Item
 			createdAt, modifiedAt time.Time
-			createdBy, modifiedBy string
-			imageId, imageItemId  sql.NullInt64  // use NullInt64
-			imageUrl              sql.NullString // already correct
+			categoryId            sql.NullInt64
 		)
 
 		err := rows.Scan(
-			&itemId,
-			&itemName,
-			&description,
-			&price,
-			&stock,
-			&createdAt,
-			&createdBy,
-			&modifiedAt,
-			&modifiedBy,
-			&imageId,
-			&imageItemId,
-			&imageUrl,
+			&item.Id, &item.ItemName, &item.Description, &item.Price, &item.Stock, &item.Reserved,
+			&createdAt, &item.CreatedBy, &modifiedAt, &item.ModifiedBy, &categoryId,
 		)
 		if err != nil {
 			return nil, err
 		}
 
-		item, exists := itemMap[itemId]
-		if !exists {
-			item = &models.Item{
-				Id:          itemId,
-				ItemName:    itemName,
-				Description: description,
-				Price:       price,
-				Stock:       stock,
-				CreatedAt:   &createdAt,
-				CreatedBy:   createdBy,
-				ModifiedAt:  &modifiedAt,
-				ModifiedBy:  modifiedBy,
-				Images:      []models.ItemImages{},
-			}
-			itemMap[itemId] = item
+		item.CategoryId = nullableInt(categoryId)
+		item.Available = max(item.Stock-item.Reserved, 0)
+		item.CreatedAt = &createdAt
+		item.ModifiedAt = &modifiedAt
+		item.Images = []models.ItemImages{}
+
+		page.Items = append(page.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	more := len(page.Items) > query.Limit
+	if more {
+		page.Items = page.Items[:query.Limit]
+	}
+
+	if query.Before != "" {
+		slices.Reverse(page.Items)
+		page.HasPrev, page.HasNext = more, true
+	} else if query.After != "" {
+		page.HasPrev, page.HasNext = true, more
+	} else {
+		page.HasPrev, page.HasNext = query.Offset > 0, more
+		page.Offset = &query.Offset
+	}
+
+	if len(page.Items) > 0 {
+		if page.HasNext {
+			page.NextCursor = encodeItemCursor(query.Sort, page.Items[len(page.Items)-1])
 		}
+		if page.HasPrev {
+			page.PrevCursor = encodeItemCursor(query.Sort, page.Items[0])
+		}
+	}
 
-		if imageId.Valid && imageItemId.Valid && imageUrl.Valid {
-			imageUrl := config.BaseUrl + imageUrl.String
-			image := models.ItemImages{
-				Id:       int(imageId.Int64),
-				ItemId:   int(imageItemId.Int64),
-				ImageUrl: imageUrl,
-			}
-			item.Images = append(item.Images, image)
+	if err = loadItemImages(page.Items); err != nil {
+		return nil, err
+	}
+
+	if err = loadItemTags(page.Items); err != nil {
+		return nil, err
+	}
+
+	if page.Facets, err = getItemFacets(query); err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+func nullableInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	number := int(value.Int64)
+	return &number
+}
+
+// getItemFacets counts the items matching query per category and tag, each
+// facet with every filter applied but its own.
+func getItemFacets(query models.ItemQuery) (models.ItemFacets, error) {
+	facets := models.ItemFacets{
+		Categories: []models.CategoryFacet{},
+		Tags:       []models.TagFacet{},
+	}
+
+	withoutCategory := query
+	withoutCategory.Category = ""
+	conditions, args := itemConditions(withoutCategory, nil)
+
+	// ancestry pairs every category with itself and each of its ancestors,
+	// so an item counts towards the whole path up to the root.
+	categoryQuery := fmt.Sprintf(`
+	WITH RECURSIVE ancestry AS (
+		SELECT id, id AS ancestor_id FROM categories
+		UNION ALL
+		SELECT a.id, c.parent_id FROM ancestry a JOIN categories c ON c.id = a.ancestor_id
+		WHERE c.parent_id IS NOT NULL
+	)
+	SELECT c.id, c.parent_id, c.name, c.slug, COUNT(*)
+	FROM items i
+	JOIN ancestry a ON a.id = i.category_id
+	JOIN categories c ON c.id = a.ancestor_id
+	%s
+	GROUP BY c.id
+	ORDER BY c.position, c.name, c.id
+	`, whereClause(conditions))
+
+	rows, err := config.Db.Query(categoryQuery, args...)
+	if err != nil {
+		return facets, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			facet    models.CategoryFacet
+			parentId sql.NullInt64
+		)
+
+		if err := rows.Scan(&facet.Id, &parentId, &facet.Name, &facet.Slug, &facet.Count); err != nil {
+			return facets, err
 		}
+
+		facet.ParentId = nullableInt(parentId)
+		facets.Categories = append(facets.Categories, facet)
+	}
+
+	if err = rows.Err(); err != nil {
+		return facets, err
+	}
+
+	withoutTags := query
+	withoutTags.Tags = nil
+	conditions, args = itemConditions(withoutTags, nil)
+
+	tagQuery := fmt.Sprintf(`
+	SELECT t.name, t.slug, COUNT(*)
+	FROM items i
+	JOIN item_tags it ON it.item_id = i.id
+	JOIN tags t ON t.id = it.tag_id
+	%s
+	GROUP BY t.id
+	ORDER BY COUNT(*) DESC, t.slug
+	`, whereClause(conditions))
+
+	tagRows, err := config.Db.Query(tagQuery, args...)
+	if err != nil {
+		return facets, err
 	}
+	defer tagRows.Close()
+
+	for tagRows.Next() {
+		var facet models.TagFacet
 
-	for _, item := range itemMap {
-		results = append(results, *item)
+		if err := tagRows.Scan(&facet.Name, &facet.Slug, &facet.Count); err != nil {
+			return facets, err
+		}
+		facets.Tags = append(facets.Tags, facet)
 	}
 
-	return results, nil
+	return facets, tagRows.Err()
+}
+
+func loadItemImages(items []models.Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(items))
+	index := make(map[int]int)
+	for i, item := range items {
+		ids[i] = item.Id
+		index[item.Id] = i
+	}
+
+	rows, err := config.Db.Query(`SELECT id, item_id, variant_id, image_url, position, is_primary FROM items_images WHERE item_id = ANY($1) ORDER BY position, id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			image     models.ItemImages
+			variantId sql.NullInt64
+		)
+
+		if err := rows.Scan(&image.Id, &image.ItemId, &variantId, &image.ImageUrl, &image.Position, &image.IsPrimary); err != nil {
+			return err
+		}
+
+		image.VariantId = nullableInt(variantId)
+		image.ImageUrl = storage.AssetURL(image.ImageUrl)
+		items[index[image.ItemId]].Images = append(items[index[image.ItemId]].Images, image)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	images := []*models.ItemImages{}
+	for i := range items {
+		for j := range items[i].Images {
+			images = append(images, &items[i].Images[j])
+		}
+		setPrimaryImage(&items[i])
+	}
+
+	return loadImageRenditions(images)
 }
 
 func GetItemById(id int64) (*models.Item, error) {
@@ -151,12 +506,13 @@ func GetItemById(id int64) (*models.Item, error) {
 
 	sqlStatement := `
 	SELECT
-		i.id, i.item_name, i.description, i.price, i.stock,
-		i.created_at, i.created_by, i.modified_at, i.modified_by,
-		ii.id, ii.item_id, ii.image_url
+		i.id, i.item_name, i.description, i.price, i.stock, i.reserved_stock,
+		i.created_at, i.created_by, i.modified_at, i.modified_by, i.category_id,
+		ii.id, ii.item_id, ii.variant_id, ii.image_url, ii.position, ii.is_primary
 	FROM items i
 	LEFT JOIN items_images ii ON i.id = ii.item_id
-	WHERE i.id = $1;
+	WHERE i.id = $1
+	ORDER BY ii.position, ii.id;
 	`
 
 	rows, err := config.Db.Query(sqlStatement, id)
@@ -171,10 +527,15 @@ func GetItemById(id int64) (*models.Item, error) {
 			itemId                int
 			itemName, description string
 			price, stock          int
+			reserved              int
 			createdAt, modifiedAt time.Time
 			createdBy, modifiedBy string
+			categoryId            sql.NullInt64
 			imageId, imageItemId  sql.NullInt64
+			imageVariantId        sql.NullInt64
 			imageUrl              sql.NullString
+			imagePosition         sql.NullInt64
+			imagePrimary          sql.NullBool
 		)
 
 		err := rows.Scan(
@@ -183,13 +544,18 @@ func GetItemById(id int64) (*models.Item, error) {
 			&description,
 			&price,
 			&stock,
+			&reserved,
 			&createdAt,
 			&createdBy,
 			&modifiedAt,
 			&modifiedBy,
+			&categoryId,
 			&imageId,
 			&imageItemId,
+			&imageVariantId,
 			&imageUrl,
+			&imagePosition,
+			&imagePrimary,
 		)
 		if err != nil {
 			return nil, err
@@ -202,20 +568,26 @@ func GetItemById(id int64) (*models.Item, error) {
 				Description: description,
 				Price:       price,
 				Stock:       stock,
+				Reserved:    reserved,
+				Available:   max(stock-reserved, 0),
 				CreatedAt:   &createdAt,
 				CreatedBy:   createdBy,
 				ModifiedAt:  &modifiedAt,
 				ModifiedBy:  modifiedBy,
+				CategoryId:  nullableInt(categoryId),
 				Images:      []models.ItemImages{},
 			}
 		}
 
 		if imageId.Valid && imageItemId.Valid && imageUrl.Valid {
-			imageUrl := config.BaseUrl + imageUrl.String
+			imageUrl := storage.AssetURL(imageUrl.String)
 			image := models.ItemImages{
-				Id:       int(imageId.Int64),
-				ItemId:   int(imageItemId.Int64),
-				ImageUrl: imageUrl,
+				Id:        int(imageId.Int64),
+				ItemId:    int(imageItemId.Int64),
+				VariantId: nullableInt(imageVariantId),
+				ImageUrl:  imageUrl,
+				Position:  int(imagePosition.Int64),
+				IsPrimary: imagePrimary.Bool,
 			}
 			result.Images = append(result.Images, image)
 		}
@@ -223,15 +595,37 @@ func GetItemById(id int64) (*models.Item, error) {
 
 	if result == nil {
 		return nil, sql.ErrNoRows
-	} else {
-		return result, nil
 	}
+
+	items := []models.Item{*result}
+	if err := loadItemTags(items); err != nil {
+		return nil, err
+	}
+
+	images := []*models.ItemImages{}
+	for i := range items[0].Images {
+		images = append(images, &items[0].Images[i])
+	}
+	if err := loadImageRenditions(images); err != nil {
+		return nil, err
+	}
+	setPrimaryImage(&items[0])
+
+	variants, err := queryVariants("v.item_id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+	items[0].Variants = variants
+
+	return &items[0], nil
 }
 
+// UpdateItem changes the item's details. Stock is left alone, it only changes
+// through the inventory ledger.
 func UpdateItem(id int64, item models.Item) (int64, error) {
 	sqlStatement := `
 	UPDATE items
-	SET item_name = $2, description = $3, price = $4, stock = $5, modified_by = $6, modified_at = $7
+	SET item_name = $2, description = $3, price = $4, modified_by = $5, modified_at = $6
 	WHERE id = $1;`
 
 	res, err := config.Db.Exec(
@@ -240,7 +634,6 @@ func UpdateItem(id int64, item models.Item) (int64, error) {
 		item.ItemName,
 		item.Description,
 		item.Price,
-		item.Stock,
 		item.ModifiedBy,
 		item.ModifiedAt,
 	)
@@ -256,18 +649,35 @@ func UpdateItem(id int64, item models.Item) (int64, error) {
 	}
 }
 
+// DeleteItem removes the item together with its images and their files.
 func DeleteItem(id int64) (int64, error) {
-	sqlStatement := `DELETE from items WHERE id = $1`
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
 
-	res, err := config.Db.Exec(sqlStatement, id)
+	keys, err := imageKeys(tx, `ii.item_id = $1`, id)
 	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	res, err := tx.Exec(`DELETE FROM items WHERE id = $1`, id)
+	if err != nil {
+		tx.Rollback()
 		return 0, err
 	}
 
 	count, err := res.RowsAffected()
 	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
 		return 0, err
-	} else {
-		return count, nil
 	}
+
+	removeBlobs(keys)
+	return count, nil
 }
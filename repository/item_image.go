@@ -0,0 +1,373 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"golang-final-project/config"
+	"golang-final-project/models"
+	"golang-final-project/storage"
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrImageNotFound      = errors.New("image doesn't exist")
+	ErrImageOrderMismatch = errors.New("image_ids must list every image of the item exactly once")
+)
+
+// setPrimaryImage points the item's PrimaryImage at its primary image.
+func setPrimaryImage(item *models.Item) {
+	item.PrimaryImage = nil
+	for i := range item.Images {
+		if item.Images[i].IsPrimary {
+			item.PrimaryImage = &item.Images[i]
+			return
+		}
+	}
+}
+
+// loadImageRenditions fills in the renditions of the images, smallest last.
+func loadImageRenditions(images []*models.ItemImages) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(images))
+	index := make(map[int][]*models.ItemImages)
+	for i, image := range images {
+		ids[i] = image.Id
+		index[image.Id] = append(index[image.Id], image)
+		image.Renditions = []models.ImageRendition{}
+	}
+
+	query := `
+	SELECT image_id, name, storage_key, content_type, width, height, size
+	FROM item_image_renditions
+	WHERE image_id = ANY($1)
+	ORDER BY image_id, width * height DESC, name
+	`
+
+	rows, err := config.Db.Query(query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			imageId   int
+			rendition models.ImageRendition
+		)
+
+		err := rows.Scan(
+			&imageId, &rendition.Name, &rendition.Key, &rendition.ContentType,
+			&rendition.Width, &rendition.Height, &rendition.Size,
+		)
+		if err != nil {
+			return err
+		}
+
+		rendition.Url = storage.AssetURL(rendition.Key)
+		for _, image := range index[imageId] {
+			image.Renditions = append(image.Renditions, rendition)
+		}
+	}
+
+	return rows.Err()
+}
+
+// insertItemImage records an uploaded image of the item and its renditions,
+// which have to be in the store already.
+func insertItemImage(tx *sql.Tx, image models.ItemImages) error {
+	_, err := tx.Exec(
+		`INSERT INTO items_images (id, item_id, image_url, position, is_primary) VALUES ($1, $2, $3, $4, $5)`,
+		image.Id,
+		image.ItemId,
+		image.ImageUrl,
+		image.Position,
+		image.IsPrimary,
+	)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO item_image_renditions (image_id, name, storage_key, content_type, width, height, size)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	for _, rendition := range image.Renditions {
+		_, err = tx.Exec(
+			query,
+			image.Id,
+			rendition.Name,
+			rendition.Key,
+			rendition.ContentType,
+			rendition.Width,
+			rendition.Height,
+			rendition.Size,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func GetItemImages(itemId int64) ([]models.ItemImages, error) {
+	items := []models.Item{{Id: int(itemId), Images: []models.ItemImages{}}}
+
+	var exists bool
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM items WHERE id = $1)`, itemId).Scan(&exists)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, ErrItemNotFound
+	}
+
+	if err = loadItemImages(items); err != nil {
+		return nil, err
+	}
+	return items[0].Images, nil
+}
+
+// AddItemImages appends the images to the item's, the first of them becoming
+// primary when the item had no images yet.
+func AddItemImages(itemId int64, images []models.ItemImages) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = lockItem(tx, itemId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var (
+		next       int
+		hasPrimary bool
+	)
+
+	err = tx.QueryRow(
+		`SELECT COALESCE(MAX(position) + 1, 0), COALESCE(BOOL_OR(is_primary), FALSE) FROM items_images WHERE item_id = $1`,
+		itemId,
+	).Scan(&next, &hasPrimary)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for i, image := range images {
+		image.ItemId = int(itemId)
+		image.Position = next + i
+		image.IsPrimary = !hasPrimary && i == 0
+
+		if err = insertItemImage(tx, image); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteItemImage removes the image and its files. The images after it move
+// up, and when it was primary the first remaining image takes its place.
+func DeleteItemImage(itemId, imageId int64) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = lockItem(tx, itemId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var (
+		position int
+		primary  bool
+	)
+
+	err = tx.QueryRow(
+		`SELECT position, is_primary FROM items_images WHERE id = $1 AND item_id = $2`,
+		imageId,
+		itemId,
+	).Scan(&position, &primary)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return ErrImageNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	keys, err := imageKeys(tx, `ii.id = $1`, imageId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM items_images WHERE id = $1`, imageId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE items_images SET position = position - 1 WHERE item_id = $1 AND position > $2`, itemId, position)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if primary {
+		_, err = tx.Exec(`
+		UPDATE items_images SET is_primary = TRUE
+		WHERE id = (SELECT id FROM items_images WHERE item_id = $1 ORDER BY position, id LIMIT 1)
+		`, itemId)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	removeBlobs(keys)
+	return nil
+}
+
+// ReorderItemImages shows the item's images in the order of imageIds.
+func ReorderItemImages(itemId int64, imageIds []int) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = lockItem(tx, itemId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT id FROM items_images WHERE item_id = $1`, itemId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	current := []int{}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		current = append(current, id)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	ordered := slices.Clone(imageIds)
+	slices.Sort(current)
+	slices.Sort(ordered)
+	if !slices.Equal(current, ordered) {
+		tx.Rollback()
+		return ErrImageOrderMismatch
+	}
+
+	for position, imageId := range imageIds {
+		if _, err = tx.Exec(`UPDATE items_images SET position = $2 WHERE id = $1`, imageId, position); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetPrimaryImage makes the image the one shown for the item in lists and
+// carts.
+func SetPrimaryImage(itemId, imageId int64) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = lockItem(tx, itemId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM items_images WHERE id = $1 AND item_id = $2)`, imageId, itemId).Scan(&exists)
+	if err != nil {
+		tx.Rollback()
+		return err
+	} else if !exists {
+		tx.Rollback()
+		return ErrImageNotFound
+	}
+
+	// The old primary is cleared first, only one image of an item can be
+	// primary at any time
+	_, err = tx.Exec(`UPDATE items_images SET is_primary = FALSE WHERE item_id = $1 AND is_primary AND id <> $2`, itemId, imageId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec(`UPDATE items_images SET is_primary = TRUE WHERE id = $1`, imageId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// imageKeys returns the store keys of the images matching condition and of
+// all their renditions.
+func imageKeys(tx *sql.Tx, condition string, args ...interface{}) ([]string, error) {
+	query := `
+	SELECT ii.image_url FROM items_images ii WHERE ` + condition + `
+	UNION
+	SELECT r.storage_key FROM item_image_renditions r JOIN items_images ii ON ii.id = r.image_id WHERE ` + condition
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// removeBlobs deletes files whose rows are gone. A file that can't be
+// deleted now is left for the orphaned upload collector.
+func removeBlobs(keys []string) {
+	store := storage.Default()
+
+	for _, key := range keys {
+		if err := store.Delete(key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			fmt.Printf("Failed to delete %s from storage: %s\n", key, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"golang-final-project/config"
+	"golang-final-project/models"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var searchWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// SearchTerms returns the tsquery for text, every word of it as a prefix so
+// a half typed query already matches, or an empty string when text has no
+// words to search for.
+func SearchTerms(text string) string {
+	words := searchWord.FindAllString(strings.ToLower(text), -1)
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// escapeHTML wraps the SQL text expression so it comes out HTML escaped. The
+// highlights are escaped before the <mark> tags go in, so markup typed into
+// an item's name or description reaches clients as text. Entities aren't
+// words to the text search parser, they never match or get marked.
+func escapeHTML(expr string) string {
+	for _, replacement := range [][2]string{{"&", "&amp;"}, {"<", "&lt;"}, {">", "&gt;"}, {`"`, "&quot;"}} {
+		expr = fmt.Sprintf("replace(%s, '%s', '%s')", expr, replacement[0], replacement[1])
+	}
+	return expr
+}
+
+// SearchItems ranks the items matching query.Text by full-text relevance
+// over name and description. Names within trigram distance of the text
+// match as well, so a misspelt query still finds the item. The listing's
+// filters narrow the search the same way they narrow GetItems.
+func SearchItems(query models.ItemSearchQuery) (*models.ItemSearchPage, error) {
+	page := models.ItemSearchPage{
+		Query:   query.Text,
+		Results: []models.ItemSearchResult{},
+		Limit:   query.Limit,
+		Offset:  query.Offset,
+	}
+
+	args := []interface{}{SearchTerms(query.Text), query.Text}
+	conditions, args := itemConditions(query.ItemQuery, args)
+	conditions = append(conditions, "(i.search_vector @@ q.terms OR i.item_name % $2 OR $2 <% i.item_name)")
+
+	from := fmt.Sprintf(`
+	FROM items i, to_tsquery('english', $1) AS q(terms)
+	%s
+	`, whereClause(conditions))
+
+	if err := config.Db.QueryRow(`SELECT COUNT(*) `+from, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	args = append(args, query.Limit, query.Offset)
+
+	searchQuery := fmt.Sprintf(`
+	SELECT
+		i.id, i.item_name, COALESCE(i.description, ''), i.price, i.stock, i.reserved_stock,
+		i.created_at, i.created_by, i.modified_at, i.modified_by, i.category_id,
+		ts_rank_cd(i.search_vector, q.terms) + GREATEST(similarity(i.item_name, $2), word_similarity($2, i.item_name)) AS rank,
+		ts_headline('english', %s, q.terms, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+		ts_headline('english', %s, q.terms, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5')
+	%s
+	ORDER BY rank DESC, i.id
+	LIMIT $%d OFFSET $%d
+	`, escapeHTML("i.item_name"), escapeHTML("COALESCE(i.description, '')"), from, len(args)-1, len(args))
+
+	rows, err := config.Db.Query(searchQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.Item{}
+
+	for rows.Next() {
+		var (
+			result                models.ItemSearchResult
+			createdAt, modifiedAt time.Time
+			categoryId            sql.NullInt64
+		)
+
+		err := rows.Scan(
+			&result.Id, &result.ItemName, &result.Description, &result.Price, &result.Stock, &result.Reserved,
+			&createdAt, &result.CreatedBy, &modifiedAt, &result.ModifiedBy, &categoryId,
+			&result.Rank, &result.Highlight.ItemName, &result.Highlight.Description,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result.CategoryId = nullableInt(categoryId)
+		result.Available = max(result.Stock-result.Reserved, 0)
+		result.CreatedAt = &createdAt
+		result.ModifiedAt = &modifiedAt
+		result.Images = []models.ItemImages{}
+
+		page.Results = append(page.Results, result)
+		items = append(items, result.Item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = loadItemImages(items); err != nil {
+		return nil, err
+	}
+	if err = loadItemTags(items); err != nil {
+		return nil, err
+	}
+	for i := range page.Results {
+		page.Results[i].Images = items[i].Images
+		page.Results[i].Tags = items[i].Tags
+	}
+
+	return &page, nil
+}
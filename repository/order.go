@@ -0,0 +1,588 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"golang-final-project/config"
+	"golang-final-project/lifecycle"
+	"golang-final-project/models"
+	"golang-final-project/pricing"
+	"golang-final-project/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrOrderNotFound = errors.New("order doesn't exist")
+
+// Checkout turns the cart into a pending order and empties the cart. Prices
+// are taken from the variants at this moment; if any of them moved since the
+// variant was added, the cart is repriced and a PriceChangeError is returned
+// without placing the order.
+func Checkout(cartId int64, order models.Order, shipTo *models.GeoPoint) (*models.Order, error) {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lockCart(tx, cartId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	linesQuery := `
+	SELECT ci.id, ci.item_id, ci.variant_id, ci.quantity, ci.unit_price, i.item_name, COALESCE(v.price, i.price), v.sku, v.name, v.active
+	FROM cart_items ci
+	JOIN items i ON i.id = ci.item_id
+	JOIN variants v ON v.id = ci.variant_id
+	WHERE ci.cart_id = $1
+	ORDER BY ci.id
+	`
+
+	rows, err := tx.Query(linesQuery, cartId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	lines := []models.CartItem{}
+	names := make(map[int]string)
+	changes := []models.PriceChange{}
+	inactive := []string{}
+
+	for rows.Next() {
+		var (
+			line     models.CartItem
+			itemName string
+			price    int
+			active   bool
+		)
+
+		err = rows.Scan(&line.Id, &line.ItemId, &line.VariantId, &line.Quantity, &line.UnitPrice, &itemName, &price, &line.Sku, &line.VariantName, &active)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, err
+		}
+
+		if line.UnitPrice != price {
+			changes = append(changes, models.PriceChange{
+				ItemId:    line.ItemId,
+				VariantId: line.VariantId,
+				Sku:       line.Sku,
+				ItemName:  itemName,
+				OldPrice:  line.UnitPrice,
+				NewPrice:  price,
+			})
+			line.UnitPrice = price
+		}
+		if !active {
+			inactive = append(inactive, line.Sku)
+		}
+
+		names[line.ItemId] = itemName
+		lines = append(lines, line)
+	}
+	rows.Close()
+
+	if len(inactive) > 0 {
+		tx.Rollback()
+		return nil, fmt.Errorf("%w: %s", ErrVariantInactive, strings.Join(inactive, ", "))
+	}
+
+	if len(lines) == 0 {
+		tx.Rollback()
+		return nil, ErrCartEmpty
+	}
+
+	breakdown := pricing.Quote(lines)
+
+	if len(changes) > 0 {
+		for _, line := range lines {
+			_, err = tx.Exec(`UPDATE cart_items SET unit_price = $2 WHERE id = $1`, line.Id, line.UnitPrice)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+
+		if err = updateCartPricing(tx, cartId, breakdown); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, &PriceChangeError{Changes: changes, Pricing: breakdown}
+	}
+
+	orderQuery := `
+	INSERT INTO orders (
+		id, user_id, status, payment_method,
+		subtotal, discount, tax, shipping, total_price,
+		created_at, updated_at
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+	`
+
+	_, err = tx.Exec(
+		orderQuery,
+		order.Id,
+		order.UserId,
+		models.OrderStatusPending,
+		order.PaymentMethod,
+		breakdown.Subtotal,
+		breakdown.Discount,
+		breakdown.Tax,
+		breakdown.Shipping,
+		breakdown.GrandTotal,
+		order.CreatedAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = recordOrderStatus(tx, int64(order.Id), nil, models.OrderStatusPending, &order.UserId, "checkout", order.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	lineQuery := `
+	INSERT INTO order_lines (id, order_id, item_id, variant_id, sku, item_name, variant_name, unit_price, quantity, subtotal)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	orderLines := []models.OrderLine{}
+
+	for _, line := range lines {
+		itemId, variantId := line.ItemId, line.VariantId
+		orderLine := models.OrderLine{
+			Id:          utils.IDGenerator(),
+			OrderId:     order.Id,
+			ItemId:      &itemId,
+			VariantId:   &variantId,
+			Sku:         line.Sku,
+			ItemName:    names[line.ItemId],
+			VariantName: line.VariantName,
+			UnitPrice:   line.UnitPrice,
+			Quantity:    line.Quantity,
+			Subtotal:    line.Subtotal,
+		}
+
+		_, err = tx.Exec(
+			lineQuery,
+			orderLine.Id,
+			orderLine.OrderId,
+			itemId,
+			variantId,
+			orderLine.Sku,
+			orderLine.ItemName,
+			orderLine.VariantName,
+			orderLine.UnitPrice,
+			orderLine.Quantity,
+			orderLine.Subtotal,
+		)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		orderLines = append(orderLines, orderLine)
+	}
+
+	if err = reserveOrderStock(tx, int64(order.Id), orderLines, shipTo, order.CreatedAt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM cart_items WHERE cart_id = $1`, cartId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = updateCartPricing(tx, cartId, pricing.Quote(nil)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetOrderById(int64(order.Id))
+}
+
+func GetOrdersByUserId(userId int64) ([]models.Order, error) {
+	return queryOrders("WHERE o.user_id = $1", userId)
+}
+
+func GetOrderById(id int64) (*models.Order, error) {
+	orders, err := queryOrders("WHERE o.id = $1", id)
+
+	if err != nil {
+		return nil, err
+	} else if len(orders) == 0 {
+		return nil, sql.ErrNoRows
+	} else {
+		return &orders[0], nil
+	}
+}
+
+func GetOrderOwner(id int64) (int, error) {
+	var userId int
+
+	err := config.Db.QueryRow(`SELECT user_id FROM orders WHERE id = $1`, id).Scan(&userId)
+	return userId, err
+}
+
+func queryOrders(condition string, args ...interface{}) ([]models.Order, error) {
+	results := []models.Order{}
+
+	query := fmt.Sprintf(`
+	SELECT
+		o.id, o.user_id, o.status, o.payment_method,
+		o.subtotal, o.discount, o.tax, o.shipping, o.total_price,
+		o.created_at, o.updated_at, o.paid_at,
+		ol.id, ol.item_id, ol.variant_id, ol.sku, ol.item_name, ol.variant_name, ol.unit_price, ol.quantity, ol.subtotal
+	FROM orders o
+	LEFT JOIN order_lines ol ON ol.order_id = o.id
+	%s
+	ORDER BY o.created_at DESC, o.id, ol.id
+	`, condition)
+
+	rows, err := config.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orderIndex := make(map[int]int)
+
+	for rows.Next() {
+		var (
+			order         models.Order
+			paymentMethod sql.NullString
+			paidAt        sql.NullTime
+			lineId        sql.NullInt64
+			itemId        sql.NullInt64
+			variantId     sql.NullInt64
+			sku           sql.NullString
+			itemName      sql.NullString
+			variantName   sql.NullString
+			unitPrice     sql.NullInt64
+			quantity      sql.NullInt64
+			subtotal      sql.NullInt64
+		)
+
+		err := rows.Scan(
+			&order.Id, &order.UserId, &order.Status, &paymentMethod,
+			&order.Pricing.Subtotal, &order.Pricing.Discount, &order.Pricing.Tax, &order.Pricing.Shipping, &order.TotalPrice,
+			&order.CreatedAt, &order.UpdatedAt, &paidAt,
+			&lineId, &itemId, &variantId, &sku, &itemName, &variantName, &unitPrice, &quantity, &subtotal,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		index, exists := orderIndex[order.Id]
+		if !exists {
+			order.PaymentMethod = paymentMethod.String
+			order.Pricing.GrandTotal = order.TotalPrice
+			order.Lines = []models.OrderLine{}
+			if paidAt.Valid {
+				order.PaidAt = &paidAt.Time
+			}
+
+			results = append(results, order)
+			index = len(results) - 1
+			orderIndex[order.Id] = index
+		}
+
+		if lineId.Valid {
+			line := models.OrderLine{
+				Id:          int(lineId.Int64),
+				OrderId:     order.Id,
+				ItemId:      nullableInt(itemId),
+				VariantId:   nullableInt(variantId),
+				Sku:         sku.String,
+				ItemName:    itemName.String,
+				VariantName: variantName.String,
+				UnitPrice:   int(unitPrice.Int64),
+				Quantity:    int(quantity.Int64),
+				Subtotal:    int(subtotal.Int64),
+			}
+
+			results[index].Lines = append(results[index].Lines, line)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = loadLineAllocations(results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// loadLineAllocations fills in which warehouses fulfil each of the orders'
+// lines.
+func loadLineAllocations(orders []models.Order) error {
+	lineIds := []int{}
+	for _, order := range orders {
+		for _, line := range order.Lines {
+			lineIds = append(lineIds, line.Id)
+		}
+	}
+
+	if len(lineIds) == 0 {
+		return nil
+	}
+
+	rows, err := config.Db.Query(
+		`SELECT order_line_id, warehouse_id, quantity FROM order_line_allocations WHERE order_line_id = ANY($1) ORDER BY id`,
+		pq.Array(lineIds),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	allocations := make(map[int][]models.OrderLineAllocation)
+
+	for rows.Next() {
+		var allocation models.OrderLineAllocation
+
+		if err := rows.Scan(&allocation.OrderLineId, &allocation.WarehouseId, &allocation.Quantity); err != nil {
+			return err
+		}
+		allocations[allocation.OrderLineId] = append(allocations[allocation.OrderLineId], allocation)
+	}
+
+	for i := range orders {
+		for j := range orders[i].Lines {
+			line := &orders[i].Lines[j]
+			line.Allocations = allocations[line.Id]
+			if line.Allocations == nil {
+				line.Allocations = []models.OrderLineAllocation{}
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+// deductOrderStock takes the order's quantities out of stock at the
+// warehouses they were allocated to, turning its active reservations into
+// sales. Quantities that are no longer held, because the reservation expired
+// before payment, are only deducted while enough unreserved stock is left
+// there, so a sold out item fails the payment instead of going negative.
+func deductOrderStock(tx *sql.Tx, id int64, actorId *int) error {
+	held, err := lockOrderReservations(tx, id)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	SELECT ol.item_id, ol.variant_id, a.warehouse_id, SUM(a.quantity)
+	FROM order_line_allocations a
+	JOIN order_lines ol ON ol.id = a.order_line_id
+	WHERE ol.order_id = $1 AND ol.variant_id IS NOT NULL
+	GROUP BY ol.item_id, ol.variant_id, a.warehouse_id
+	ORDER BY ol.variant_id, a.warehouse_id
+	`
+
+	rows, err := tx.Query(query, id)
+	if err != nil {
+		return err
+	}
+
+	keys := []stockKey{}
+	items := make(map[stockKey]int)
+	quantities := make(map[stockKey]int)
+	for rows.Next() {
+		var (
+			key      stockKey
+			itemId   int
+			quantity int
+		)
+		if err = rows.Scan(&itemId, &key.variantId, &key.warehouseId, &quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+		items[key] = itemId
+		quantities[key] = quantity
+	}
+	rows.Close()
+
+	for _, key := range keys {
+		quantity := quantities[key]
+		variantId, warehouseId := int(key.variantId), int(key.warehouseId)
+
+		movement := models.StockMovement{
+			ItemId:      items[key],
+			VariantId:   &variantId,
+			WarehouseId: &warehouseId,
+			Kind:        models.StockMovementSale,
+			Delta:       -quantity,
+			ActorId:     actorId,
+			ReferenceId: strconv.FormatInt(id, 10),
+		}
+
+		res, err := tx.Exec(
+			`UPDATE warehouse_stock SET stock = stock - $3, reserved_stock = reserved_stock - $4 WHERE warehouse_id = $1 AND variant_id = $2 AND stock - reserved_stock >= $3 - $4`,
+			key.warehouseId,
+			key.variantId,
+			quantity,
+			held[key],
+		)
+		if err != nil {
+			return err
+		}
+
+		if count, err := res.RowsAffected(); err != nil {
+			return err
+		} else if count == 0 {
+			return fmt.Errorf("%w for variant %d", ErrInsufficientStock, key.variantId)
+		}
+
+		movement.StockAfter, err = updateStockTotals(tx, key.variantId, -quantity, -held[key])
+		if err != nil {
+			return err
+		}
+
+		if err = recordStockMovement(tx, &movement); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		`UPDATE stock_reservations SET status = $2, closed_at = $3 WHERE order_id = $1 AND status = $4`,
+		id,
+		models.ReservationStatusConsumed,
+		time.Now(),
+		models.ReservationStatusActive,
+	)
+	return err
+}
+
+// TransitionOrder moves the order to status if the lifecycle allows it from
+// the order's current status.
+func TransitionOrder(id int64, status string, actorId *int, reason string) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	current, err := lockOrder(tx, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = transitionOrder(tx, id, current, status, actorId, reason, time.Now()); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func GetOrderHistory(id int64) ([]models.OrderStatusChange, error) {
+	results := []models.OrderStatusChange{}
+
+	query := `
+	SELECT id, order_id, from_status, to_status, actor_id, reason, created_at
+	FROM order_status_history
+	WHERE order_id = $1
+	ORDER BY created_at, id
+	`
+
+	rows, err := config.Db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			change     models.OrderStatusChange
+			fromStatus sql.NullString
+			actorId    sql.NullInt64
+		)
+
+		err := rows.Scan(&change.Id, &change.OrderId, &fromStatus, &change.ToStatus, &actorId, &change.Reason, &change.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if fromStatus.Valid {
+			change.FromStatus = &fromStatus.String
+		}
+		if actorId.Valid {
+			actor := int(actorId.Int64)
+			change.ActorId = &actor
+		}
+
+		results = append(results, change)
+	}
+
+	return results, rows.Err()
+}
+
+func lockOrder(tx *sql.Tx, id int64) (string, error) {
+	var status string
+
+	err := tx.QueryRow(`SELECT status FROM orders WHERE id = $1 FOR UPDATE`, id).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrOrderNotFound
+	} else {
+		return status, err
+	}
+}
+
+// transitionOrder moves an order already locked by tx from one status to the
+// next and records the change. It is the only place orders.status is updated.
+func transitionOrder(tx *sql.Tx, id int64, from string, to string, actorId *int, reason string, at time.Time) error {
+	if err := lifecycle.Transition(from, to); err != nil {
+		return err
+	}
+
+	var paidAt *time.Time
+	if to == models.OrderStatusPaid {
+		paidAt = &at
+	}
+
+	_, err := tx.Exec(
+		`UPDATE orders SET status = $2, updated_at = $3, paid_at = COALESCE($4::TIMESTAMP, paid_at) WHERE id = $1`,
+		id,
+		to,
+		at,
+		paidAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return recordOrderStatus(tx, id, &from, to, actorId, reason, at)
+}
+
+func recordOrderStatus(tx *sql.Tx, id int64, from *string, to string, actorId *int, reason string, at time.Time) error {
+	query := `
+	INSERT INTO order_status_history (order_id, from_status, to_status, actor_id, reason, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := tx.Exec(query, id, from, to, actorId, reason, at)
+	return err
+}
@@ -0,0 +1,418 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"golang-final-project/config"
+	"golang-final-project/lifecycle"
+	"golang-final-project/models"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrPaymentNotFound   = errors.New("payment doesn't exist")
+	ErrPaymentOutOfOrder = errors.New("payment is not in a state this change applies to")
+	ErrPaymentCaptured   = errors.New("payment was captured but the order couldn't be marked as paid")
+)
+
+// CreatePayment records a new attempt at paying the order before the provider
+// is called, moving a pending order to awaiting_payment first. The amount is
+// always the order total.
+func CreatePayment(payment models.Payment, actorId *int) (*models.Payment, error) {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	orderId := int64(payment.OrderId)
+
+	status, err := lockOrder(tx, orderId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	now := time.Now()
+
+	if status == models.OrderStatusPending {
+		err = transitionOrder(tx, orderId, status, models.OrderStatusAwaitingPayment, actorId, "payment started", now)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		status = models.OrderStatusAwaitingPayment
+	}
+
+	if err = lifecycle.Transition(status, models.OrderStatusPaid); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.QueryRow(`SELECT total_price FROM orders WHERE id = $1`, orderId).Scan(&payment.Amount)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	payment.Status = models.PaymentStatusPending
+	payment.CreatedAt = now
+	payment.UpdatedAt = now
+
+	query := `
+	INSERT INTO payments (id, order_id, provider, status, amount, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $6)
+	`
+
+	_, err = tx.Exec(query, payment.Id, payment.OrderId, payment.Provider, payment.Status, payment.Amount, now)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+// UpdatePayment stores what the provider answered for the attempt.
+func UpdatePayment(payment *models.Payment) error {
+	payment.UpdatedAt = time.Now()
+
+	query := `
+	UPDATE payments
+	SET reference = NULLIF($2, ''), status = $3, action_url = NULLIF($4, ''), message = NULLIF($5, ''), updated_at = $6
+	WHERE id = $1
+	`
+
+	_, err := config.Db.Exec(
+		query,
+		payment.Id,
+		payment.Reference,
+		payment.Status,
+		payment.ActionURL,
+		payment.Message,
+		payment.UpdatedAt,
+	)
+	return err
+}
+
+// CompletePayment lets capture take the money for the attempt, then deducts
+// the order's stock and marks both the payment and the order as paid. The
+// payment is recorded as capturing and committed before capture calls the
+// provider, so no transaction is held open across the call and a capture
+// that can't be recorded is never lost. capture is nil when the provider has
+// already taken the money by itself.
+//
+// If capture fails the payment goes back to the status it had. If the money
+// was taken but the order can't be marked as paid, it is given back through
+// refund and ErrPaymentCaptured is returned along with the reason.
+func CompletePayment(paymentId int64, actorId *int, capture func(models.Payment) error, refund func(models.Payment, int, string) error) error {
+	payment, err := beginCapture(paymentId)
+	if err != nil {
+		return err
+	}
+
+	if capture != nil {
+		if err = capture(*payment); err != nil {
+			_, restoreErr := config.Db.Exec(
+				`UPDATE payments SET status = $2, updated_at = $3 WHERE id = $1 AND status = $4`,
+				paymentId,
+				payment.Status,
+				time.Now(),
+				models.PaymentStatusCapturing,
+			)
+			if restoreErr != nil {
+				fmt.Printf("Failed to restore payment %d after a failed capture: %s\n", paymentId, restoreErr)
+			}
+			return err
+		}
+	}
+
+	if err = finishCapture(paymentId, int64(payment.OrderId), actorId); err != nil {
+		return undoCapture(payment, refund, err)
+	}
+
+	return nil
+}
+
+// beginCapture records the payment as capturing, after checking its order
+// can still be paid. It returns the payment as it was before.
+func beginCapture(paymentId int64) (*models.Payment, error) {
+	payment, err := GetPaymentById(paymentId)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := lockOrder(tx, int64(payment.OrderId))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if err = lifecycle.Transition(status, models.OrderStatusPaid); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	query := `
+	UPDATE payments
+	SET status = $2, updated_at = $3
+	WHERE id = $1 AND status IN ($4, $5, $6)
+	`
+
+	res, err := tx.Exec(
+		query,
+		paymentId,
+		models.PaymentStatusCapturing,
+		time.Now(),
+		models.PaymentStatusPending,
+		models.PaymentStatusRequiresAction,
+		models.PaymentStatusAuthorized,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if count, err := res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if count == 0 {
+		tx.Rollback()
+		return nil, ErrPaymentOutOfOrder
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// finishCapture deducts the order's stock and marks the capturing payment as
+// captured and its order as paid.
+func finishCapture(paymentId int64, orderId int64, actorId *int) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	status, err := lockOrder(tx, orderId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	} else if err = lifecycle.Transition(status, models.OrderStatusPaid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = deductOrderStock(tx, orderId, actorId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	now := time.Now()
+
+	res, err := tx.Exec(
+		`UPDATE payments SET status = $2, message = NULL, updated_at = $3 WHERE id = $1 AND status = $4`,
+		paymentId,
+		models.PaymentStatusCaptured,
+		now,
+		models.PaymentStatusCapturing,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if count, err := res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return err
+	} else if count == 0 {
+		tx.Rollback()
+		return ErrPaymentOutOfOrder
+	}
+
+	err = transitionOrder(tx, orderId, status, models.OrderStatusPaid, actorId, "payment captured", now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// undoCapture gives back the money of a capture that couldn't be recorded
+// and marks the payment as refunded. When the refund fails too the payment
+// stays capturing with the reason, for someone to settle by hand.
+func undoCapture(payment *models.Payment, refund func(models.Payment, int, string) error, cause error) error {
+	status := models.PaymentStatusRefunded
+	message := cause.Error()
+
+	if err := refund(*payment, payment.Amount, "payment-"+strconv.Itoa(payment.Id)); err != nil {
+		status = models.PaymentStatusCapturing
+		message = fmt.Sprintf("%s, refunding it failed: %s", message, err)
+		fmt.Printf("Failed to refund payment %d: %s\n", payment.Id, err)
+	}
+
+	_, err := config.Db.Exec(
+		`UPDATE payments SET status = $2, message = $3, updated_at = $4 WHERE id = $1 AND status = $5`,
+		payment.Id,
+		status,
+		message,
+		time.Now(),
+		models.PaymentStatusCapturing,
+	)
+	if err != nil {
+		fmt.Printf("Failed to record the refund of payment %d: %s\n", payment.Id, err)
+	}
+
+	return fmt.Errorf("%w: %w", ErrPaymentCaptured, cause)
+}
+
+// FailPayment marks an attempt that hasn't been captured yet as declined. The
+// order stays awaiting payment so the customer can try again.
+func FailPayment(id int64, message string) error {
+	query := `
+	UPDATE payments
+	SET status = $2, message = NULLIF($3, ''), updated_at = $4
+	WHERE id = $1 AND status IN ($5, $6, $7)
+	`
+
+	res, err := config.Db.Exec(
+		query,
+		id,
+		models.PaymentStatusDeclined,
+		message,
+		time.Now(),
+		models.PaymentStatusPending,
+		models.PaymentStatusRequiresAction,
+		models.PaymentStatusAuthorized,
+	)
+	if err != nil {
+		return err
+	}
+
+	if count, err := res.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrPaymentOutOfOrder
+	} else {
+		return nil
+	}
+}
+
+// SettlePayment moves the payment's order to orderStatus and the payment to
+// paymentStatus together, for changes the provider reports after the fact.
+func SettlePayment(id int64, orderStatus string, paymentStatus string, actorId *int, reason string) error {
+	payment, err := GetPaymentById(id)
+	if err != nil {
+		return err
+	} else if payment.Status != models.PaymentStatusCaptured && payment.Status != models.PaymentStatusDisputed {
+		return ErrPaymentOutOfOrder
+	}
+
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	orderId := int64(payment.OrderId)
+
+	status, err := lockOrder(tx, orderId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	now := time.Now()
+
+	if err = transitionOrder(tx, orderId, status, orderStatus, actorId, reason, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE payments SET status = $2, updated_at = $3 WHERE id = $1`, id, paymentStatus, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func GetPaymentByReference(provider string, reference string) (*models.Payment, error) {
+	payments, err := queryPayments("WHERE provider = $1 AND reference = $2", provider, reference)
+
+	if err != nil {
+		return nil, err
+	} else if len(payments) == 0 {
+		return nil, ErrPaymentNotFound
+	} else {
+		return &payments[0], nil
+	}
+}
+
+func GetPaymentById(id int64) (*models.Payment, error) {
+	payments, err := queryPayments("WHERE id = $1", id)
+
+	if err != nil {
+		return nil, err
+	} else if len(payments) == 0 {
+		return nil, ErrPaymentNotFound
+	} else {
+		return &payments[0], nil
+	}
+}
+
+func GetPaymentsByOrderId(orderId int64) ([]models.Payment, error) {
+	return queryPayments("WHERE order_id = $1", orderId)
+}
+
+func queryPayments(condition string, args ...interface{}) ([]models.Payment, error) {
+	results := []models.Payment{}
+
+	query := fmt.Sprintf(`
+	SELECT id, order_id, provider, reference, status, amount, action_url, message, created_at, updated_at
+	FROM payments
+	%s
+	ORDER BY created_at, id
+	`, condition)
+
+	rows, err := config.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			payment   models.Payment
+			reference sql.NullString
+			actionURL sql.NullString
+			message   sql.NullString
+		)
+
+		err := rows.Scan(
+			&payment.Id, &payment.OrderId, &payment.Provider, &reference, &payment.Status,
+			&payment.Amount, &actionURL, &message, &payment.CreatedAt, &payment.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		payment.Reference = reference.String
+		payment.ActionURL = actionURL.String
+		payment.Message = message.String
+		results = append(results, payment)
+	}
+
+	return results, rows.Err()
+}
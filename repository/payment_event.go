@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/config"
+	"golang-final-project/models"
+	"time"
+)
+
+// RecordPaymentEvent stores a received webhook event and reports whether it
+// is new. Events the provider already delivered are skipped, unless handling
+// them failed last time, in which case the retry is let through.
+func RecordPaymentEvent(event *models.PaymentEvent) (bool, error) {
+	query := `
+	INSERT INTO payment_webhook_events (provider, event_id, event_type, reference, payload, received_at)
+	VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+	ON CONFLICT (provider, event_id) DO UPDATE
+	SET received_at = EXCLUDED.received_at, status = NULL, note = NULL, processed_at = NULL
+	WHERE payment_webhook_events.status = $7
+	RETURNING id
+	`
+
+	err := config.Db.QueryRow(
+		query,
+		event.Provider,
+		event.EventId,
+		event.Type,
+		event.Reference,
+		string(event.Payload),
+		event.ReceivedAt,
+		models.PaymentEventFailed,
+	).Scan(&event.Id)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else {
+		return true, nil
+	}
+}
+
+func FinishPaymentEvent(id int, status string, note string) error {
+	_, err := config.Db.Exec(
+		`UPDATE payment_webhook_events SET status = $2, note = NULLIF($3, ''), processed_at = $4 WHERE id = $1`,
+		id,
+		status,
+		note,
+		time.Now(),
+	)
+	return err
+}
+
+// GetPaymentEvents lists received webhook events, newest first, optionally
+// only those that ended with status.
+func GetPaymentEvents(status string) ([]models.PaymentEvent, error) {
+	results := []models.PaymentEvent{}
+
+	query := `
+	SELECT id, provider, event_id, event_type, reference, status, note, payload, received_at, processed_at
+	FROM payment_webhook_events
+	WHERE $1 = '' OR status = $1
+	ORDER BY received_at DESC, id DESC
+	`
+
+	rows, err := config.Db.Query(query, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			event       models.PaymentEvent
+			reference   sql.NullString
+			eventStatus sql.NullString
+			note        sql.NullString
+			payload     []byte
+			processedAt sql.NullTime
+		)
+
+		err := rows.Scan(
+			&event.Id, &event.Provider, &event.EventId, &event.Type, &reference,
+			&eventStatus, &note, &payload, &event.ReceivedAt, &processedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Reference = reference.String
+		event.Status = eventStatus.String
+		event.Note = note.String
+		event.Payload = payload
+		if processedAt.Valid {
+			event.ProcessedAt = &processedAt.Time
+		}
+
+		results = append(results, event)
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,591 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"golang-final-project/config"
+	"golang-final-project/lifecycle"
+	"golang-final-project/models"
+	"golang-final-project/utils"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrOrderAlreadyPaid  = errors.New("order has already been paid")
+	ErrOrderLineNotFound = errors.New("order line doesn't belong to this order")
+	ErrRefundExceedsLine = errors.New("refund quantity exceeds what is left on the order line")
+	ErrNothingToRefund   = errors.New("nothing left to refund")
+	ErrNoCapturedPayment = errors.New("order has no captured payment to refund")
+	ErrRefundPending     = errors.New("another refund of the order is still pending, repeat that one to complete it")
+)
+
+type refundableLine struct {
+	itemId      sql.NullInt64
+	variantId   sql.NullInt64
+	warehouseId sql.NullInt64
+	unitPrice   int
+	remaining   int
+}
+
+// RefundOrder gives back the lines in body. The refund is recorded as
+// pending and committed before refundPayment returns the money through the
+// provider, so nothing that fails afterwards can take back a refund the
+// customer already got. Once the provider has accepted it, restocked
+// quantities are put back into stock, and when nothing is left to refund the
+// order moves to closeAs; this last refund also covers whatever remains of
+// the order total, such as shipping. A refund the provider refuses is marked
+// failed.
+//
+// When completing a refund fails it stays pending, and the next RefundOrder
+// of the order asking for the same refund completes it; any other refund is
+// refused with ErrRefundPending until then. It is sent to the provider again
+// under the same key, which the provider never refunds twice. refundPayment is nil for refunds the provider has made by itself.
+func RefundOrder(orderId int64, body models.RefundBody, operatorId *int, closeAs string, refundPayment func(models.Payment, int, string) error) (*models.Refund, error) {
+	refund, payment, err := beginRefund(orderId, body, operatorId, closeAs)
+	if err != nil {
+		return nil, err
+	}
+
+	if refundPayment != nil {
+		if err = refundPayment(*payment, refund.Amount, strconv.Itoa(refund.Id)); err != nil {
+			_, failErr := config.Db.Exec(
+				`UPDATE refunds SET status = $2 WHERE id = $1 AND status = $3`,
+				refund.Id,
+				models.RefundStatusFailed,
+				models.RefundStatusPending,
+			)
+			if failErr != nil {
+				fmt.Printf("Failed to mark refund %d as failed: %s\n", refund.Id, failErr)
+			}
+			return nil, err
+		}
+	}
+
+	if err = completeRefund(refund, closeAs); err != nil {
+		return nil, err
+	}
+
+	refund.Status = models.RefundStatusSucceeded
+	return refund, nil
+}
+
+// beginRefund records the refund of the lines in body as pending, or returns
+// the order's refund that is still pending when body asks for the same,
+// together with the payment it goes back to. A pending refund of anything
+// else is ErrRefundPending.
+func beginRefund(orderId int64, body models.RefundBody, operatorId *int, closeAs string) (*models.Refund, *models.Payment, error) {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status, err := lockOrder(tx, orderId)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	} else if err = lifecycle.Transition(status, closeAs); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	payment, err := capturedPayment(tx, orderId)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	pending, err := queryRefunds(tx, `r.order_id = $1 AND r.status = $2`, orderId, models.RefundStatusPending)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	} else if len(pending) > 0 {
+		if matches, err := pendingRefundMatches(tx, pending[0], body); err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		} else if !matches {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("%w: %d", ErrRefundPending, pending[0].Id)
+		}
+		return &pending[0], payment, tx.Commit()
+	}
+
+	var subtotal, tax, total, alreadyRefunded int
+
+	query := `
+	SELECT o.subtotal, o.tax, o.total_price, COALESCE((SELECT SUM(r.amount) FROM refunds r WHERE r.order_id = o.id AND r.status <> $2), 0)
+	FROM orders o
+	WHERE o.id = $1
+	`
+
+	if err = tx.QueryRow(query, orderId, models.RefundStatusFailed).Scan(&subtotal, &tax, &total, &alreadyRefunded); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	lines, err := refundableLines(tx, orderId)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	requested := body.Lines
+	if len(requested) == 0 {
+		for id, line := range lines {
+			if line.remaining > 0 {
+				requested = append(requested, models.RefundLineBody{OrderLineId: id, Quantity: line.remaining})
+			}
+		}
+		sort.Slice(requested, func(i, j int) bool {
+			return requested[i].OrderLineId < requested[j].OrderLineId
+		})
+	}
+
+	refund := models.Refund{
+		Id:         utils.IDGenerator(),
+		OrderId:    int(orderId),
+		Reason:     body.Reason,
+		Status:     models.RefundStatusPending,
+		OperatorId: operatorId,
+		Lines:      []models.RefundLine{},
+		CreatedAt:  time.Now(),
+	}
+
+	lineIndex := make(map[int]int)
+
+	for _, request := range requested {
+		line, exists := lines[request.OrderLineId]
+		if !exists {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("%w: %d", ErrOrderLineNotFound, request.OrderLineId)
+		}
+
+		restock := body.Restock == nil || *body.Restock
+		if request.Restock != nil {
+			restock = *request.Restock
+		}
+		restock = restock && line.itemId.Valid
+
+		index, exists := lineIndex[request.OrderLineId]
+		if !exists || refund.Lines[index].Restocked != restock {
+			refund.Lines = append(refund.Lines, models.RefundLine{
+				Id:          utils.IDGenerator(),
+				RefundId:    refund.Id,
+				OrderLineId: request.OrderLineId,
+				Restocked:   restock,
+			})
+			index = len(refund.Lines) - 1
+			lineIndex[request.OrderLineId] = index
+		}
+
+		if request.Quantity > line.remaining {
+			tx.Rollback()
+			return nil, nil, fmt.Errorf("%w: %d", ErrRefundExceedsLine, request.OrderLineId)
+		}
+
+		value := line.unitPrice * request.Quantity
+		if subtotal > 0 {
+			value += value * tax / subtotal
+		}
+
+		line.remaining -= request.Quantity
+		lines[request.OrderLineId] = line
+
+		refund.Lines[index].Quantity += request.Quantity
+		refund.Lines[index].Amount += value
+		refund.Amount += value
+	}
+
+	fullyRefunded := true
+	for _, line := range lines {
+		if line.remaining > 0 {
+			fullyRefunded = false
+		}
+	}
+
+	if fullyRefunded {
+		refund.Amount = total - alreadyRefunded
+	}
+
+	if len(refund.Lines) == 0 || refund.Amount <= 0 {
+		tx.Rollback()
+		return nil, nil, ErrNothingToRefund
+	}
+
+	refund.PaymentId = payment.Id
+
+	_, err = tx.Exec(
+		`INSERT INTO refunds (id, order_id, payment_id, amount, reason, status, operator_id, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		refund.Id,
+		refund.OrderId,
+		refund.PaymentId,
+		refund.Amount,
+		refund.Reason,
+		refund.Status,
+		refund.OperatorId,
+		refund.CreatedAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	for _, refundLine := range refund.Lines {
+		_, err = tx.Exec(
+			`INSERT INTO refund_lines (id, refund_id, order_line_id, quantity, amount, restocked) VALUES ($1, $2, $3, $4, $5, $6)`,
+			refundLine.Id,
+			refundLine.RefundId,
+			refundLine.OrderLineId,
+			refundLine.Quantity,
+			refundLine.Amount,
+			refundLine.Restocked,
+		)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return &refund, payment, nil
+}
+
+// pendingRefundMatches reports whether body asks for the pending refund
+// again: the same quantities of the same lines, restocked the same way, or
+// everything left when the pending refund already covers it.
+func pendingRefundMatches(tx *sql.Tx, pending models.Refund, body models.RefundBody) (bool, error) {
+	lines, err := refundableLines(tx, int64(pending.OrderId))
+	if err != nil {
+		return false, err
+	}
+
+	if len(body.Lines) == 0 {
+		for _, line := range lines {
+			if line.remaining > 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	type refundedLine struct {
+		orderLineId int
+		restocked   bool
+	}
+
+	quantities := make(map[refundedLine]int)
+
+	for _, line := range pending.Lines {
+		quantities[refundedLine{line.OrderLineId, line.Restocked}] += line.Quantity
+	}
+
+	for _, request := range body.Lines {
+		line, exists := lines[request.OrderLineId]
+		if !exists {
+			return false, fmt.Errorf("%w: %d", ErrOrderLineNotFound, request.OrderLineId)
+		}
+
+		restock := body.Restock == nil || *body.Restock
+		if request.Restock != nil {
+			restock = *request.Restock
+		}
+		restock = restock && line.itemId.Valid
+
+		quantities[refundedLine{request.OrderLineId, restock}] -= request.Quantity
+	}
+
+	for _, quantity := range quantities {
+		if quantity != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// completeRefund marks the pending refund as succeeded, puts its restocked
+// quantities back into stock and closes the order as closeAs when nothing is
+// left to refund. A refund completed in the meantime is left alone.
+func completeRefund(refund *models.Refund, closeAs string) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	orderId := int64(refund.OrderId)
+
+	status, err := lockOrder(tx, orderId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	res, err := tx.Exec(
+		`UPDATE refunds SET status = $2 WHERE id = $1 AND status = $3`,
+		refund.Id,
+		models.RefundStatusSucceeded,
+		models.RefundStatusPending,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if count, err := res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return err
+	} else if count == 0 {
+		return tx.Commit()
+	}
+
+	lines, err := refundableLines(tx, orderId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	now := time.Now()
+
+	for _, refundLine := range refund.Lines {
+		if !refundLine.Restocked {
+			continue
+		}
+
+		line := lines[refundLine.OrderLineId]
+
+		var warehouseId *int
+		if line.warehouseId.Valid {
+			id := int(line.warehouseId.Int64)
+			warehouseId = &id
+		}
+
+		err = changeStock(tx, &models.StockMovement{
+			ItemId:      int(line.itemId.Int64),
+			VariantId:   nullableInt(line.variantId),
+			WarehouseId: warehouseId,
+			Kind:        models.StockMovementRefund,
+			Delta:       refundLine.Quantity,
+			Reason:      refund.Reason,
+			ActorId:     refund.OperatorId,
+			ReferenceId: strconv.Itoa(refund.Id),
+			CreatedAt:   now,
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for _, line := range lines {
+		if line.remaining > 0 {
+			return tx.Commit()
+		}
+	}
+
+	reason := refund.Reason
+	if reason == "" {
+		reason = "fully refunded"
+	}
+
+	if err = transitionOrder(tx, orderId, status, closeAs, refund.OperatorId, reason, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE payments SET status = $2, updated_at = $3 WHERE id = $1`,
+		refund.PaymentId,
+		models.PaymentStatusRefunded,
+		now,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// refundableLines returns the order's lines by ID with the quantity not
+// refunded yet, counting pending refunds as made. Restocked units go back to the warehouse that shipped most
+// of the line.
+func refundableLines(tx *sql.Tx, orderId int64) (map[int]refundableLine, error) {
+	query := `
+	SELECT
+		ol.id, ol.item_id, ol.variant_id,
+		(SELECT a.warehouse_id FROM order_line_allocations a WHERE a.order_line_id = ol.id ORDER BY a.quantity DESC, a.warehouse_id LIMIT 1),
+		ol.unit_price, ol.quantity - COALESCE(SUM(rl.quantity), 0)
+	FROM order_lines ol
+	LEFT JOIN refund_lines rl ON rl.order_line_id = ol.id AND rl.refund_id IN (SELECT r.id FROM refunds r WHERE r.status <> $2)
+	WHERE ol.order_id = $1
+	GROUP BY ol.id
+	`
+
+	rows, err := tx.Query(query, orderId, models.RefundStatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := make(map[int]refundableLine)
+
+	for rows.Next() {
+		var (
+			id   int
+			line refundableLine
+		)
+
+		if err := rows.Scan(&id, &line.itemId, &line.variantId, &line.warehouseId, &line.unitPrice, &line.remaining); err != nil {
+			return nil, err
+		}
+		lines[id] = line
+	}
+
+	return lines, rows.Err()
+}
+
+// capturedPayment locks the payment the order was paid with. A disputed
+// payment is still refundable, a lost dispute ends in a refund.
+func capturedPayment(tx *sql.Tx, orderId int64) (*models.Payment, error) {
+	var payment models.Payment
+
+	query := `
+	SELECT id, order_id, provider, reference, status, amount
+	FROM payments
+	WHERE order_id = $1 AND status IN ($2, $3)
+	FOR UPDATE
+	`
+
+	err := tx.QueryRow(query, orderId, models.PaymentStatusCaptured, models.PaymentStatusDisputed).Scan(
+		&payment.Id, &payment.OrderId, &payment.Provider, &payment.Reference, &payment.Status, &payment.Amount,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoCapturedPayment
+	} else if err != nil {
+		return nil, err
+	} else {
+		return &payment, nil
+	}
+}
+
+// CancelOrder cancels an order that hasn't been paid, releasing the stock it
+// holds, and returns the payment attempts still open at the provider so they
+// can be voided. Paid orders are
+// refunded through RefundOrder instead and get ErrOrderAlreadyPaid.
+func CancelOrder(orderId int64, actorId *int, reason string) ([]models.Payment, error) {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := lockOrder(tx, orderId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if status == models.OrderStatusPaid {
+		tx.Rollback()
+		return nil, ErrOrderAlreadyPaid
+	}
+
+	if reason == "" {
+		reason = "cancelled"
+	}
+
+	if err = transitionOrder(tx, orderId, status, models.OrderStatusCancelled, actorId, reason, time.Now()); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err = releaseOrderReservations(tx, orderId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	payments, err := GetPaymentsByOrderId(orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	open := []models.Payment{}
+	for _, payment := range payments {
+		if payment.Status == models.PaymentStatusAuthorized || payment.Status == models.PaymentStatusRequiresAction {
+			open = append(open, payment)
+		}
+	}
+
+	return open, nil
+}
+
+func GetRefundsByOrderId(orderId int64) ([]models.Refund, error) {
+	return queryRefunds(config.Db, `r.order_id = $1`, orderId)
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryRefunds loads the refunds matching condition with their lines, oldest
+// first.
+func queryRefunds(db queryer, condition string, args ...interface{}) ([]models.Refund, error) {
+	results := []models.Refund{}
+
+	query := fmt.Sprintf(`
+	SELECT
+		r.id, r.order_id, r.payment_id, r.amount, r.reason, r.status, r.operator_id, r.created_at,
+		rl.id, rl.order_line_id, rl.quantity, rl.amount, rl.restocked
+	FROM refunds r
+	JOIN refund_lines rl ON rl.refund_id = r.id
+	WHERE %s
+	ORDER BY r.created_at, r.id, rl.id
+	`, condition)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refundIndex := make(map[int]int)
+
+	for rows.Next() {
+		var (
+			refund     models.Refund
+			operatorId sql.NullInt64
+			line       models.RefundLine
+		)
+
+		err := rows.Scan(
+			&refund.Id, &refund.OrderId, &refund.PaymentId, &refund.Amount, &refund.Reason, &refund.Status, &operatorId, &refund.CreatedAt,
+			&line.Id, &line.OrderLineId, &line.Quantity, &line.Amount, &line.Restocked,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		index, exists := refundIndex[refund.Id]
+		if !exists {
+			if operatorId.Valid {
+				operator := int(operatorId.Int64)
+				refund.OperatorId = &operator
+			}
+			refund.Lines = []models.RefundLine{}
+
+			results = append(results, refund)
+			index = len(results) - 1
+			refundIndex[refund.Id] = index
+		}
+
+		line.RefundId = refund.Id
+		results[index].Lines = append(results[index].Lines, line)
+	}
+
+	return results, rows.Err()
+}
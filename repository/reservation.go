@@ -0,0 +1,278 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"golang-final-project/allocation"
+	"golang-final-project/config"
+	"golang-final-project/models"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const defaultStockReservationTTL = 15 * time.Minute
+
+// StockReservationTTL is how long checkout holds stock for an unpaid order,
+// configured through STOCK_RESERVATION_TTL.
+func StockReservationTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("STOCK_RESERVATION_TTL")); err == nil && ttl > 0 {
+		return ttl
+	} else {
+		return defaultStockReservationTTL
+	}
+}
+
+// reserveOrderStock allocates every line to one or more warehouses and holds
+// the allocated quantities for the order. The variant's warehouse rows stay
+// locked until tx ends, so concurrent checkouts can't both take the last
+// units; lines are handled in variant order to keep those locks from
+// deadlocking.
+func reserveOrderStock(tx *sql.Tx, orderId int64, lines []models.OrderLine, shipTo *models.GeoPoint, now time.Time) error {
+	insufficient := []string{}
+	expiresAt := now.Add(StockReservationTTL())
+	strategy := allocation.Strategy()
+
+	sorted := make([]models.OrderLine, len(lines))
+	copy(sorted, lines)
+	sort.Slice(sorted, func(i, j int) bool {
+		return *sorted[i].VariantId < *sorted[j].VariantId
+	})
+
+	for _, line := range sorted {
+		itemId, variantId := *line.ItemId, *line.VariantId
+
+		locations, err := lockVariantLocations(tx, variantId)
+		if err != nil {
+			return err
+		}
+
+		allocations := allocation.Allocate(strategy, line.Quantity, locations, shipTo)
+		if allocations == nil {
+			insufficient = append(insufficient, line.Sku)
+			continue
+		}
+
+		for _, allocated := range allocations {
+			_, err = tx.Exec(
+				`UPDATE warehouse_stock SET reserved_stock = reserved_stock + $3 WHERE warehouse_id = $1 AND variant_id = $2`,
+				allocated.WarehouseId,
+				variantId,
+				allocated.Quantity,
+			)
+			if err != nil {
+				return err
+			}
+
+			query := `
+			INSERT INTO stock_reservations (order_id, item_id, variant_id, warehouse_id, quantity, status, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			`
+
+			_, err = tx.Exec(query, orderId, itemId, variantId, allocated.WarehouseId, allocated.Quantity, models.ReservationStatusActive, expiresAt, now)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(
+				`INSERT INTO order_line_allocations (order_line_id, warehouse_id, quantity) VALUES ($1, $2, $3)`,
+				line.Id,
+				allocated.WarehouseId,
+				allocated.Quantity,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err = updateStockTotals(tx, int64(variantId), 0, line.Quantity); err != nil {
+			return err
+		}
+	}
+
+	if len(insufficient) > 0 {
+		return fmt.Errorf("%w for %s", ErrInsufficientStock, strings.Join(insufficient, ", "))
+	}
+
+	return nil
+}
+
+// lockVariantLocations locks the variant's stock at every active warehouse
+// and returns what is available at each.
+func lockVariantLocations(tx *sql.Tx, variantId int) ([]allocation.Location, error) {
+	query := `
+	SELECT ws.warehouse_id, ws.stock - ws.reserved_stock, w.priority, w.latitude, w.longitude
+	FROM warehouse_stock ws
+	JOIN warehouses w ON w.id = ws.warehouse_id
+	WHERE ws.variant_id = $1 AND w.active
+	ORDER BY ws.warehouse_id
+	FOR UPDATE OF ws
+	`
+
+	rows, err := tx.Query(query, variantId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locations := []allocation.Location{}
+
+	for rows.Next() {
+		var (
+			location            allocation.Location
+			latitude, longitude sql.NullFloat64
+		)
+
+		if err := rows.Scan(&location.WarehouseId, &location.Available, &location.Priority, &latitude, &longitude); err != nil {
+			return nil, err
+		}
+
+		if latitude.Valid && longitude.Valid {
+			location.Point = &models.GeoPoint{Latitude: latitude.Float64, Longitude: longitude.Float64}
+		}
+
+		locations = append(locations, location)
+	}
+
+	return locations, rows.Err()
+}
+
+type stockKey struct {
+	variantId   int64
+	warehouseId int64
+}
+
+// sortedStockKeys returns the keys in variant then warehouse order, the
+// order reserveOrderStock locks warehouse rows in.
+func sortedStockKeys(quantities map[stockKey]int) []stockKey {
+	keys := make([]stockKey, 0, len(quantities))
+	for key := range quantities {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].variantId != keys[j].variantId {
+			return keys[i].variantId < keys[j].variantId
+		}
+		return keys[i].warehouseId < keys[j].warehouseId
+	})
+	return keys
+}
+
+// lockOrderReservations locks the order's active reservations and returns
+// the quantity held per variant and warehouse.
+func lockOrderReservations(tx *sql.Tx, orderId int64) (map[stockKey]int, error) {
+	rows, err := tx.Query(
+		`SELECT variant_id, warehouse_id, quantity FROM stock_reservations WHERE order_id = $1 AND status = $2 FOR UPDATE`,
+		orderId,
+		models.ReservationStatusActive,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	held := make(map[stockKey]int)
+	for rows.Next() {
+		var (
+			key      stockKey
+			quantity int
+		)
+		if err := rows.Scan(&key.variantId, &key.warehouseId, &quantity); err != nil {
+			return nil, err
+		}
+		held[key] += quantity
+	}
+
+	return held, rows.Err()
+}
+
+func releaseOrderReservations(tx *sql.Tx, orderId int64) (int, error) {
+	return releaseReservations(tx, `
+	SELECT id, variant_id, warehouse_id, quantity FROM stock_reservations
+	WHERE order_id = $1 AND status = $2
+	FOR UPDATE
+	`, orderId, models.ReservationStatusActive)
+}
+
+// ReleaseExpiredReservations gives the stock held for orders that weren't
+// paid in time back and returns how many reservations were released.
+// Reservations locked by a payment in progress are left for the next run.
+func ReleaseExpiredReservations() (int, error) {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := releaseReservations(tx, `
+	SELECT id, variant_id, warehouse_id, quantity FROM stock_reservations
+	WHERE status = $1 AND expires_at <= $2
+	FOR UPDATE SKIP LOCKED
+	`, models.ReservationStatusActive, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
+func releaseReservations(tx *sql.Tx, query string, args ...interface{}) (int, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := []int64{}
+	quantities := make(map[stockKey]int)
+
+	for rows.Next() {
+		var (
+			id       int64
+			key      stockKey
+			quantity int
+		)
+
+		if err = rows.Scan(&id, &key.variantId, &key.warehouseId, &quantity); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		ids = append(ids, id)
+		quantities[key] += quantity
+	}
+	rows.Close()
+
+	for _, key := range sortedStockKeys(quantities) {
+		quantity := quantities[key]
+
+		_, err = tx.Exec(
+			`UPDATE warehouse_stock SET reserved_stock = reserved_stock - $3 WHERE warehouse_id = $1 AND variant_id = $2`,
+			key.warehouseId,
+			key.variantId,
+			quantity,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err = updateStockTotals(tx, key.variantId, 0, -quantity); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(
+		`UPDATE stock_reservations SET status = $2, closed_at = $3 WHERE id = ANY($1)`,
+		pq.Array(ids),
+		models.ReservationStatusReleased,
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
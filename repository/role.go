@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/config"
+	"golang-final-project/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrRoleNotFound      = errors.New("role doesn't exist")
+	ErrRoleInUse         = errors.New("role is still assigned to one or more users")
+	ErrRoleBuiltIn       = errors.New("built-in roles cannot be deleted")
+	ErrUnknownPermission = errors.New("one or more permissions don't exist")
+)
+
+func GetRoles() ([]models.Role, error) {
+	results := []models.Role{}
+
+	query := `
+	SELECT r.id, r.name, r.description, p.name
+	FROM roles r
+	LEFT JOIN role_permissions rp ON rp.role_id = r.id
+	LEFT JOIN permissions p ON p.id = rp.permission_id
+	ORDER BY r.id, p.name
+	`
+
+	rows, err := config.Db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roleIndex := make(map[int]int)
+
+	for rows.Next() {
+		var (
+			roleId      int
+			name        string
+			description sql.NullString
+			permission  sql.NullString
+		)
+
+		if err := rows.Scan(&roleId, &name, &description, &permission); err != nil {
+			return nil, err
+		}
+
+		index, exists := roleIndex[roleId]
+		if !exists {
+			results = append(results, models.Role{
+				Id:          roleId,
+				Name:        name,
+				Description: description.String,
+				Permissions: []string{},
+			})
+			index = len(results) - 1
+			roleIndex[roleId] = index
+		}
+
+		if permission.Valid {
+			results[index].Permissions = append(results[index].Permissions, permission.String)
+		}
+	}
+
+	return results, rows.Err()
+}
+
+func GetPermissions() ([]models.Permission, error) {
+	results := []models.Permission{}
+
+	rows, err := config.Db.Query(`SELECT id, name, description FROM permissions ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			permission  models.Permission
+			description sql.NullString
+		)
+
+		if err := rows.Scan(&permission.Id, &permission.Name, &description); err != nil {
+			return nil, err
+		}
+
+		permission.Description = description.String
+		results = append(results, permission)
+	}
+
+	return results, rows.Err()
+}
+
+func RoleExists(name string) (bool, error) {
+	var exists bool
+
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, name).Scan(&exists)
+	return exists, err
+}
+
+func CreateRole(role models.Role) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO roles (id, name, description) VALUES ($1, $2, $3)`,
+		role.Id,
+		role.Name,
+		role.Description,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = insertRolePermissions(tx, role.Id, role.Permissions); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func SetRolePermissions(roleId int, permissions []string) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var id int
+	err = tx.QueryRow(`SELECT id FROM roles WHERE id = $1 FOR UPDATE`, roleId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return ErrRoleNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM role_permissions WHERE role_id = $1`, roleId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = insertRolePermissions(tx, roleId, permissions); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertRolePermissions(tx *sql.Tx, roleId int, permissions []string) error {
+	unique := make(map[string]bool)
+	for _, permission := range permissions {
+		unique[permission] = true
+	}
+
+	query := `
+	INSERT INTO role_permissions (role_id, permission_id)
+	SELECT $1, id FROM permissions WHERE name = ANY($2)
+	`
+
+	res, err := tx.Exec(query, roleId, pq.Array(permissions))
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if int(count) != len(unique) {
+		return ErrUnknownPermission
+	} else {
+		return nil
+	}
+}
+
+func DeleteRole(roleId int) error {
+	var (
+		name  string
+		inUse bool
+	)
+
+	query := `
+	SELECT r.name, EXISTS (SELECT 1 FROM users u WHERE u.role = r.name)
+	FROM roles r
+	WHERE r.id = $1
+	`
+
+	err := config.Db.QueryRow(query, roleId).Scan(&name, &inUse)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRoleNotFound
+	} else if err != nil {
+		return err
+	} else if name == models.RoleAdmin || name == models.RoleUser {
+		return ErrRoleBuiltIn
+	} else if inUse {
+		return ErrRoleInUse
+	}
+
+	_, err = config.Db.Exec(`DELETE FROM roles WHERE id = $1`, roleId)
+	return err
+}
+
+func AssignUserRole(userId int, role string) (int64, error) {
+	exists, err := RoleExists(role)
+	if err != nil {
+		return 0, err
+	} else if !exists {
+		return 0, ErrRoleNotFound
+	}
+
+	res, err := config.Db.Exec(`UPDATE users SET role = $2 WHERE id = $1`, userId, role)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	} else {
+		return count, nil
+	}
+}
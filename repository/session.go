@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"golang-final-project/config"
+	"golang-final-project/models"
+	"time"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used, the session has been revoked")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+)
+
+func CreateSession(session models.Session, refreshToken models.RefreshToken) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	sessionQuery := `
+	INSERT INTO sessions (id, user_id, user_agent, ip_address, created_at, last_seen_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err = tx.Exec(
+		sessionQuery,
+		session.Id,
+		session.UserId,
+		session.UserAgent,
+		session.IpAddress,
+		session.CreatedAt,
+		session.LastSeenAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	refreshTokenQuery := `
+	INSERT INTO refresh_tokens (id, session_id, token_hash, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err = tx.Exec(
+		refreshTokenQuery,
+		refreshToken.Id,
+		session.Id,
+		refreshToken.TokenHash,
+		refreshToken.CreatedAt,
+		refreshToken.ExpiresAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RotateRefreshToken exchanges the refresh token matching tokenHash for next.
+// Presenting a token that has already been rotated revokes the whole session,
+// since it means the token was copied and used by somebody else.
+func RotateRefreshToken(tokenHash string, next models.RefreshToken) (*models.Session, string, error) {
+	var (
+		session   models.Session
+		role      string
+		tokenId   int
+		expiresAt time.Time
+		rotatedAt sql.NullTime
+		revokedAt sql.NullTime
+	)
+
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+
+	selectQuery := `
+	SELECT
+		rt.id, rt.expires_at, rt.rotated_at,
+		s.id, s.user_id, s.user_agent, s.ip_address, s.created_at, s.last_seen_at, s.revoked_at,
+		u.role
+	FROM refresh_tokens rt
+	JOIN sessions s ON s.id = rt.session_id
+	JOIN users u ON u.id = s.user_id
+	WHERE rt.token_hash = $1
+	FOR UPDATE OF rt, s
+	`
+
+	err = tx.QueryRow(selectQuery, tokenHash).Scan(
+		&tokenId, &expiresAt, &rotatedAt,
+		&session.Id, &session.UserId, &session.UserAgent, &session.IpAddress,
+		&session.CreatedAt, &session.LastSeenAt, &revokedAt,
+		&role,
+	)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", ErrRefreshTokenInvalid
+		}
+		return nil, "", err
+	}
+
+	now := time.Now()
+
+	if revokedAt.Valid {
+		tx.Rollback()
+		return nil, "", ErrSessionRevoked
+	} else if rotatedAt.Valid {
+		_, err = revokeSessions(tx, "id = $4", session.Id, nil, models.RevocationReasonRefreshTokenReuse)
+		if err != nil {
+			tx.Rollback()
+			return nil, "", err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenReused
+	} else if now.After(expiresAt) {
+		tx.Rollback()
+		return nil, "", ErrRefreshTokenExpired
+	}
+
+	_, err = tx.Exec(`UPDATE refresh_tokens SET rotated_at = $2 WHERE id = $1`, tokenId, now)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+
+	insertQuery := `
+	INSERT INTO refresh_tokens (id, session_id, token_hash, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err = tx.Exec(
+		insertQuery,
+		next.Id,
+		session.Id,
+		next.TokenHash,
+		next.CreatedAt,
+		next.ExpiresAt,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+
+	_, err = tx.Exec(`UPDATE sessions SET last_seen_at = $2 WHERE id = $1`, session.Id, now)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, "", err
+	}
+
+	session.LastSeenAt = now
+	return &session, role, nil
+}
+
+func RevokeSession(sessionId int, actorId *int, reason string) (int64, error) {
+	return revokeSessions(config.Db, "id = $4", sessionId, actorId, reason)
+}
+
+func RevokeUserSessions(userId int, actorId *int, reason string) (int64, error) {
+	return revokeSessions(config.Db, "user_id = $4", userId, actorId, reason)
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// revokeSessions revokes every active session matching condition and records
+// one session_revocations row per revoked session in the same statement.
+func revokeSessions(db execer, condition string, id int, actorId *int, reason string) (int64, error) {
+	sqlStatement := fmt.Sprintf(`
+	WITH revoked AS (
+		UPDATE sessions
+		SET revoked_at = $1::TIMESTAMP
+		WHERE %s AND revoked_at IS NULL
+		RETURNING id, user_id
+	)
+	INSERT INTO session_revocations (session_id, user_id, revoked_by, reason, revoked_at)
+	SELECT id, user_id, $2::BIGINT, $3::VARCHAR, $1::TIMESTAMP FROM revoked
+	`, condition)
+
+	res, err := db.Exec(sqlStatement, time.Now(), actorId, reason, id)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	} else {
+		return count, nil
+	}
+}
@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/config"
+	"golang-final-project/models"
+	"time"
+)
+
+var ErrNegativeStock = errors.New("stock cannot go below zero")
+
+// changeStock applies the movement's delta to the variant's stock at the
+// movement's warehouse, the item's default variant and the default warehouse
+// when it names none, and records it in the ledger. It is how every stock
+// change except a sale is made; sales also release reserved stock and go
+// through deductOrderStock.
+func changeStock(tx *sql.Tx, movement *models.StockMovement) error {
+	variantId, err := stockVariant(tx, movement.ItemId, movement.VariantId)
+	if err != nil {
+		return err
+	}
+	movement.VariantId = &variantId
+
+	warehouseId, err := resolveWarehouse(tx, movement.WarehouseId)
+	if err != nil {
+		return err
+	}
+	movement.WarehouseId = &warehouseId
+
+	var query string
+	if movement.Delta < 0 {
+		query = `
+		UPDATE warehouse_stock SET stock = stock + $3
+		WHERE warehouse_id = $1 AND variant_id = $2 AND stock + $3 >= 0
+		RETURNING stock
+		`
+	} else {
+		query = `
+		INSERT INTO warehouse_stock (warehouse_id, item_id, variant_id, stock)
+		SELECT $1, item_id, id, $3 FROM variants WHERE id = $2
+		ON CONFLICT (warehouse_id, variant_id) DO UPDATE SET stock = warehouse_stock.stock + EXCLUDED.stock
+		RETURNING stock
+		`
+	}
+
+	var warehouseStock int
+	err = tx.QueryRow(query, warehouseId, variantId, movement.Delta).Scan(&warehouseStock)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNegativeStock
+	} else if err != nil {
+		return err
+	}
+
+	movement.StockAfter, err = updateStockTotals(tx, int64(variantId), movement.Delta, 0)
+	if err != nil {
+		return err
+	}
+
+	return recordStockMovement(tx, movement)
+}
+
+// recordStockMovement adds a ledger entry for a change already applied to
+// items.stock, movement.StockAfter must hold the item's resulting stock over
+// all warehouses.
+func recordStockMovement(tx *sql.Tx, movement *models.StockMovement) error {
+	if movement.CreatedAt.IsZero() {
+		movement.CreatedAt = time.Now()
+	}
+
+	query := `
+	INSERT INTO stock_movements (item_id, variant_id, warehouse_id, kind, delta, stock_after, reason, actor_id, reference_id, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10)
+	RETURNING id
+	`
+
+	return tx.QueryRow(
+		query,
+		movement.ItemId,
+		movement.VariantId,
+		movement.WarehouseId,
+		movement.Kind,
+		movement.Delta,
+		movement.StockAfter,
+		movement.Reason,
+		movement.ActorId,
+		movement.ReferenceId,
+		movement.CreatedAt,
+	).Scan(&movement.Id)
+}
+
+// AdjustStock records a manual change to the stock of one of the item's
+// variants at one warehouse. For a stocktake the delta is worked out from the
+// counted stock while the warehouse's row is locked, so sales made in the
+// meantime aren't lost.
+func AdjustStock(itemId int64, body models.StockAdjustmentBody, actorId *int) (*models.StockMovement, error) {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	movement := models.StockMovement{
+		ItemId:      int(itemId),
+		Kind:        body.Kind,
+		Delta:       body.Delta,
+		Reason:      body.Reason,
+		ActorId:     actorId,
+		ReferenceId: body.ReferenceId,
+	}
+
+	variantId, err := stockVariant(tx, int(itemId), body.VariantId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	movement.VariantId = &variantId
+
+	warehouseId, err := resolveWarehouse(tx, body.WarehouseId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	movement.WarehouseId = &warehouseId
+
+	if body.Kind == models.StockMovementStocktake {
+		var stock int
+
+		// No row yet means nothing was ever stocked there.
+		err = tx.QueryRow(
+			`SELECT stock FROM warehouse_stock WHERE warehouse_id = $1 AND variant_id = $2 FOR UPDATE`,
+			warehouseId,
+			variantId,
+		).Scan(&stock)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			tx.Rollback()
+			return nil, err
+		}
+
+		movement.Delta = *body.CountedStock - stock
+	}
+
+	if err = changeStock(tx, &movement); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &movement, nil
+}
+
+// GetStockLedger returns the item's movements, newest first, and whether its
+// stock still matches the sum of the ledger.
+func GetStockLedger(itemId int64) (*models.StockLedger, error) {
+	ledger := models.StockLedger{
+		ItemId:    int(itemId),
+		Movements: []models.StockMovement{},
+	}
+
+	query := `
+	SELECT i.stock, COALESCE((SELECT SUM(sm.delta) FROM stock_movements sm WHERE sm.item_id = i.id), 0)
+	FROM items i
+	WHERE i.id = $1
+	`
+
+	err := config.Db.QueryRow(query, itemId).Scan(&ledger.Stock, &ledger.LedgerBalance)
+	if err != nil {
+		return nil, err
+	}
+	ledger.Reconciled = ledger.Stock == ledger.LedgerBalance
+
+	rows, err := config.Db.Query(`
+	SELECT id, item_id, variant_id, warehouse_id, kind, delta, stock_after, reason, actor_id, reference_id, created_at
+	FROM stock_movements
+	WHERE item_id = $1
+	ORDER BY created_at DESC, id DESC
+	`, itemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			movement    models.StockMovement
+			variantId   sql.NullInt64
+			warehouseId sql.NullInt64
+			actorId     sql.NullInt64
+			referenceId sql.NullString
+		)
+
+		err := rows.Scan(
+			&movement.Id, &movement.ItemId, &variantId, &warehouseId, &movement.Kind, &movement.Delta, &movement.StockAfter,
+			&movement.Reason, &actorId, &referenceId, &movement.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movement.VariantId = nullableInt(variantId)
+		if warehouseId.Valid {
+			warehouse := int(warehouseId.Int64)
+			movement.WarehouseId = &warehouse
+		}
+		if actorId.Valid {
+			actor := int(actorId.Int64)
+			movement.ActorId = &actor
+		}
+		movement.ReferenceId = referenceId.String
+
+		ledger.Movements = append(ledger.Movements, movement)
+	}
+
+	return &ledger, rows.Err()
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"golang-final-project/config"
+	"golang-final-project/storage"
+	"regexp"
+	"time"
+)
+
+// itemUploadPrefix is where item images are uploaded to, nothing else in the
+// store is ever collected.
+const itemUploadPrefix = "items/"
+
+// legacyUploadKey matches the images uploaded before itemUploadPrefix was
+// used, kept at the top of the store as the upload time in nanoseconds and
+// the file name. Those timestamps all start with 1 until 2286, so only that
+// prefix has to be listed to find them.
+var legacyUploadKey = regexp.MustCompile(`^1[0-9]{18}_[^/]+$`)
+
+// CollectOrphanedUploads deletes the uploaded item images no item image or
+// rendition refers to, such as the uploads of item creations that failed,
+// once they are older than grace. Only keys under itemUploadPrefix and legacy
+// upload keys are considered, so a store shared with anything else keeps the
+// rest of its files. Younger files may belong to an upload still in progress,
+// which stores its files before the rows pointing at them. With dryRun
+// nothing is deleted. It returns the files collected and the bytes they took.
+func CollectOrphanedUploads(grace time.Duration, dryRun bool) ([]storage.Blob, int64, error) {
+	store := storage.Default()
+
+	// Listing before reading the references means every file uploaded since
+	// is missed rather than wrongly taken for an orphan
+	blobs, err := listUploads(store)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	referenced, err := referencedBlobKeys()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cutoff := time.Now().Add(-grace)
+	orphans := []storage.Blob{}
+	var freed int64
+
+	for _, blob := range blobs {
+		if referenced[blob.Key] || blob.ModifiedAt.After(cutoff) {
+			continue
+		}
+
+		if !dryRun {
+			if err := store.Delete(blob.Key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				fmt.Printf("Failed to delete %s from storage: %s\n", blob.Key, err)
+				continue
+			}
+		}
+
+		orphans = append(orphans, blob)
+		freed += blob.Size
+	}
+
+	return orphans, freed, nil
+}
+
+func listUploads(store storage.BlobStore) ([]storage.Blob, error) {
+	blobs, err := store.List(itemUploadPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	legacy, err := store.List("1")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, blob := range legacy {
+		if legacyUploadKey.MatchString(blob.Key) {
+			blobs = append(blobs, blob)
+		}
+	}
+
+	return blobs, nil
+}
+
+func referencedBlobKeys() (map[string]bool, error) {
+	rows, err := config.Db.Query(`
+	SELECT image_url FROM items_images
+	UNION
+	SELECT storage_key FROM item_image_renditions
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+
+	return keys, rows.Err()
+}
@@ -7,7 +7,6 @@ import (
 	"golang-final-project/config"
 	"golang-final-project/middleware"
 	"golang-final-project/models"
-	"time"
 )
 
 func CreateUser(user models.User) string {
@@ -23,24 +22,20 @@ func CreateUser(user models.User) string {
 		return "Username has been taken"
 	} else {
 		sqlStatement := `
-		INSERT INTO users (id, username, password, token, expire_time, role)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		Returning id, username, password, token, expire_time, role
+		INSERT INTO users (id, username, password, role)
+		VALUES ($1, $2, $3, $4)
+		Returning id, username, password, role
 		`
 		config.Err = config.Db.QueryRow(
 			sqlStatement,
 			user.Id,
 			user.Username,
 			user.Password,
-			nil,
-			nil,
 			user.Role,
 		).Scan(
 			&userCredentials.Id,
 			&userCredentials.Username,
 			&userCredentials.Password,
-			&userCredentials.Token,
-			&userCredentials.ExpireTime,
 			&userCredentials.Role,
 		)
 
@@ -84,28 +79,9 @@ func Login(username string, password string) (*models.User, error) {
 	}
 }
 
-func AssignAccessToken(id int, token string, expire_time time.Time) (int64, error) {
-	sqlStatement := `
-	UPDATE users
-	set token = $2, expire_time = $3
-	WHERE id = $1
-	`
-
-	res, err := config.Db.Exec(
-		sqlStatement,
-		id,
-		token,
-		expire_time,
-	)
+func CountUsersWithRole(role string) (int, error) {
+	var count int
 
-	if err != nil {
-		return 0, err
-	} else {
-		count, e := res.RowsAffected()
-		if e != nil {
-			return 0, err
-		} else {
-			return count, nil
-		}
-	}
+	err := config.Db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = $1`, role).Scan(&count)
+	return count, err
 }
@@ -0,0 +1,739 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"golang-final-project/config"
+	"golang-final-project/models"
+	"golang-final-project/storage"
+	"golang-final-project/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrVariantNotFound       = errors.New("variant doesn't exist")
+	ErrVariantRequired       = errors.New("item has several variants, pick one with variant_id")
+	ErrVariantInactive       = errors.New("variant is no longer sold")
+	ErrOptionTypeNotFound    = errors.New("option type doesn't exist")
+	ErrOptionsInUse          = errors.New("option types cannot be added once variants use options")
+	ErrInvalidVariantOptions = errors.New("variant must pick one existing value for every option type of the item")
+	ErrVariantOptionsFixed   = errors.New("options of a variant cannot be changed once set")
+	ErrDuplicateVariant      = errors.New("another variant of the item already has these options")
+	ErrUnknownImage          = errors.New("one or more images don't belong to this item")
+)
+
+// sellableVariant is what a cart needs to know about the variant it adds.
+type sellableVariant struct {
+	id        int
+	itemId    int
+	sku       string
+	price     int
+	available int
+}
+
+// resolveVariant finds the variant a cart line refers to and locks it
+// against stock changes for the rest of tx. Without variantId the item has to
+// have exactly one active variant; without itemId the variant decides the
+// item.
+func resolveVariant(tx *sql.Tx, itemId, variantId int) (*sellableVariant, error) {
+	if itemId == 0 && variantId == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrItemNotFound, itemId)
+	}
+
+	query := `
+	SELECT v.id, v.item_id, v.sku, COALESCE(v.price, i.price), v.stock - v.reserved_stock, v.active
+	FROM variants v
+	JOIN items i ON i.id = v.item_id
+	WHERE ($1 = 0 OR v.item_id = $1) AND ($2 = 0 OR v.id = $2) AND ($2 <> 0 OR v.active)
+	ORDER BY v.id
+	LIMIT 2
+	FOR SHARE OF v
+	`
+
+	rows, err := tx.Query(query, itemId, variantId)
+	if err != nil {
+		return nil, err
+	}
+
+	variants := []sellableVariant{}
+	inactive := false
+
+	for rows.Next() {
+		var (
+			variant sellableVariant
+			active  bool
+		)
+
+		if err = rows.Scan(&variant.id, &variant.itemId, &variant.sku, &variant.price, &variant.available, &active); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		inactive = !active
+		variants = append(variants, variant)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(variants) > 1 {
+		return nil, ErrVariantRequired
+	} else if len(variants) == 1 && inactive {
+		return nil, fmt.Errorf("%w: %s", ErrVariantInactive, variants[0].sku)
+	} else if len(variants) == 1 {
+		return &variants[0], nil
+	} else if variantId != 0 {
+		return nil, fmt.Errorf("%w: %d", ErrVariantNotFound, variantId)
+	} else {
+		return nil, fmt.Errorf("%w: %d", ErrItemNotFound, itemId)
+	}
+}
+
+// stockVariant returns variantId when it is a variant of the item, or the
+// item's default variant when variantId is nil.
+func stockVariant(tx *sql.Tx, itemId int, variantId *int) (int, error) {
+	var id int
+
+	err := tx.QueryRow(
+		`SELECT id FROM variants WHERE item_id = $1 AND (id = $2 OR ($2 IS NULL AND is_default))`,
+		itemId,
+		variantId,
+	).Scan(&id)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		if err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM items WHERE id = $1)`, itemId).Scan(&exists); err != nil {
+			return 0, err
+		} else if !exists {
+			return 0, ErrItemNotFound
+		} else {
+			return 0, ErrVariantNotFound
+		}
+	}
+	return id, err
+}
+
+// updateStockTotals adds the deltas to the totals kept on the variant and on
+// its item, once the warehouse row they come from has been changed. It
+// returns the item's stock afterwards.
+func updateStockTotals(tx *sql.Tx, variantId int64, stockDelta, reservedDelta int) (int, error) {
+	var itemId int64
+
+	err := tx.QueryRow(
+		`UPDATE variants SET stock = stock + $2, reserved_stock = reserved_stock + $3 WHERE id = $1 RETURNING item_id`,
+		variantId,
+		stockDelta,
+		reservedDelta,
+	).Scan(&itemId)
+	if err != nil {
+		return 0, err
+	}
+
+	var stock int
+
+	err = tx.QueryRow(
+		`UPDATE items SET stock = stock + $2, reserved_stock = reserved_stock + $3 WHERE id = $1 RETURNING stock`,
+		itemId,
+		stockDelta,
+		reservedDelta,
+	).Scan(&stock)
+	return stock, err
+}
+
+// createDefaultVariant gives a new item the variant its stock and sales go
+// to until other variants are added.
+func createDefaultVariant(tx *sql.Tx, itemId int, stock int, createdAt time.Time) (int, error) {
+	variantId := utils.IDGenerator()
+
+	_, err := tx.Exec(
+		`INSERT INTO variants (id, item_id, sku, stock, is_default, created_at) VALUES ($1, $2, $3, $4, TRUE, $5)`,
+		variantId,
+		itemId,
+		fmt.Sprintf("ITEM-%d", itemId),
+		stock,
+		createdAt,
+	)
+	return variantId, err
+}
+
+func GetItemVariants(itemId int64) (*models.ItemVariants, error) {
+	var exists bool
+
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM items WHERE id = $1)`, itemId).Scan(&exists)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, ErrItemNotFound
+	}
+
+	options, err := getOptionTypes(itemId)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := queryVariants("v.item_id = $1", itemId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ItemVariants{
+		ItemId:   int(itemId),
+		Options:  options,
+		Variants: variants,
+	}, nil
+}
+
+func GetVariantById(itemId, variantId int64) (*models.Variant, error) {
+	variants, err := queryVariants("v.item_id = $1 AND v.id = $2", itemId, variantId)
+
+	if err != nil {
+		return nil, err
+	} else if len(variants) == 0 {
+		return nil, ErrVariantNotFound
+	} else {
+		return &variants[0], nil
+	}
+}
+
+func getOptionTypes(itemId int64) ([]models.OptionType, error) {
+	query := `
+	SELECT ot.id, ot.item_id, ot.name, ot.position, ov.id, ov.value, ov.position
+	FROM option_types ot
+	LEFT JOIN option_values ov ON ov.option_type_id = ot.id
+	WHERE ot.item_id = $1
+	ORDER BY ot.position, ot.id, ov.position, ov.id
+	`
+
+	rows, err := config.Db.Query(query, itemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.OptionType{}
+	typeIndex := make(map[int]int)
+
+	for rows.Next() {
+		var (
+			optionType    models.OptionType
+			valueId       sql.NullInt64
+			value         sql.NullString
+			valuePosition sql.NullInt64
+		)
+
+		err := rows.Scan(&optionType.Id, &optionType.ItemId, &optionType.Name, &optionType.Position, &valueId, &value, &valuePosition)
+		if err != nil {
+			return nil, err
+		}
+
+		index, exists := typeIndex[optionType.Id]
+		if !exists {
+			optionType.Values = []models.OptionValue{}
+			results = append(results, optionType)
+			index = len(results) - 1
+			typeIndex[optionType.Id] = index
+		}
+
+		if valueId.Valid {
+			results[index].Values = append(results[index].Values, models.OptionValue{
+				Id:       int(valueId.Int64),
+				Value:    value.String,
+				Position: int(valuePosition.Int64),
+			})
+		}
+	}
+
+	return results, rows.Err()
+}
+
+// queryVariants loads the variants matching condition with their options
+// and images.
+func queryVariants(condition string, args ...interface{}) ([]models.Variant, error) {
+	query := `
+	SELECT
+		v.id, v.item_id, v.sku, v.name, COALESCE(v.price, i.price), v.price,
+		v.stock, v.reserved_stock, v.is_default, v.active, v.position, v.created_at
+	FROM variants v
+	JOIN items i ON i.id = v.item_id
+	WHERE ` + condition + `
+	ORDER BY v.position, v.id
+	`
+
+	rows, err := config.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.Variant{}
+	ids := []int{}
+	index := make(map[int]int)
+
+	for rows.Next() {
+		var (
+			variant       models.Variant
+			priceOverride sql.NullInt64
+		)
+
+		err := rows.Scan(
+			&variant.Id, &variant.ItemId, &variant.Sku, &variant.Name, &variant.Price, &priceOverride,
+			&variant.Stock, &variant.Reserved, &variant.IsDefault, &variant.Active, &variant.Position, &variant.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		variant.PriceOverride = nullableInt(priceOverride)
+		variant.Available = max(variant.Stock-variant.Reserved, 0)
+		variant.Options = []models.VariantOption{}
+		variant.Images = []models.ItemImages{}
+
+		index[variant.Id] = len(results)
+		ids = append(ids, variant.Id)
+		results = append(results, variant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	optionRows, err := config.Db.Query(`
+	SELECT vov.variant_id, ot.name, ov.value
+	FROM variant_option_values vov
+	JOIN option_values ov ON ov.id = vov.option_value_id
+	JOIN option_types ot ON ot.id = ov.option_type_id
+	WHERE vov.variant_id = ANY($1)
+	ORDER BY ot.position, ot.id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer optionRows.Close()
+
+	for optionRows.Next() {
+		var (
+			variantId int
+			option    models.VariantOption
+		)
+
+		if err := optionRows.Scan(&variantId, &option.Name, &option.Value); err != nil {
+			return nil, err
+		}
+		results[index[variantId]].Options = append(results[index[variantId]].Options, option)
+	}
+
+	if err = optionRows.Err(); err != nil {
+		return nil, err
+	}
+
+	imageRows, err := config.Db.Query(
+		`SELECT id, item_id, variant_id, image_url, position, is_primary FROM items_images WHERE variant_id = ANY($1) ORDER BY position, id`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer imageRows.Close()
+
+	for imageRows.Next() {
+		var (
+			image     models.ItemImages
+			variantId int
+		)
+
+		if err := imageRows.Scan(&image.Id, &image.ItemId, &variantId, &image.ImageUrl, &image.Position, &image.IsPrimary); err != nil {
+			return nil, err
+		}
+
+		image.VariantId = &variantId
+		image.ImageUrl = storage.AssetURL(image.ImageUrl)
+		results[index[variantId]].Images = append(results[index[variantId]].Images, image)
+	}
+
+	if err = imageRows.Err(); err != nil {
+		return nil, err
+	}
+
+	images := []*models.ItemImages{}
+	for i := range results {
+		for j := range results[i].Images {
+			images = append(images, &results[i].Images[j])
+		}
+	}
+
+	return results, loadImageRenditions(images)
+}
+
+// CreateOptionType adds a way the item's variants differ. It has to come
+// before the variants do, a variant's options are fixed once set.
+func CreateOptionType(itemId int64, optionType *models.OptionType) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = lockItem(tx, itemId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var inUse bool
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM variant_option_values vov JOIN variants v ON v.id = vov.variant_id WHERE v.item_id = $1)`,
+		itemId,
+	).Scan(&inUse)
+	if err != nil {
+		tx.Rollback()
+		return err
+	} else if inUse {
+		tx.Rollback()
+		return ErrOptionsInUse
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO option_types (id, item_id, name, position) VALUES ($1, $2, $3, $4)`,
+		optionType.Id,
+		itemId,
+		optionType.Name,
+		optionType.Position,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for i := range optionType.Values {
+		value := &optionType.Values[i]
+		value.Id = utils.IDGenerator()
+
+		_, err = tx.Exec(
+			`INSERT INTO option_values (id, option_type_id, value, position) VALUES ($1, $2, $3, $4)`,
+			value.Id,
+			optionType.Id,
+			value.Value,
+			value.Position,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// OptionTypeExists reports whether the item already has an option type
+// called name.
+func OptionTypeExists(itemId int64, name string) (bool, error) {
+	var exists bool
+
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM option_types WHERE item_id = $1 AND name = $2)`, itemId, name).Scan(&exists)
+	return exists, err
+}
+
+// AddOptionValue adds a value to one of the item's option types, such as a
+// new size. Existing variants keep theirs.
+func AddOptionValue(itemId, optionTypeId int64, value models.OptionValue) error {
+	var exists bool
+
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM option_types WHERE id = $1 AND item_id = $2)`, optionTypeId, itemId).Scan(&exists)
+	if err != nil {
+		return err
+	} else if !exists {
+		return ErrOptionTypeNotFound
+	}
+
+	_, err = config.Db.Exec(
+		`INSERT INTO option_values (id, option_type_id, value, position) VALUES ($1, $2, $3, $4)`,
+		value.Id,
+		optionTypeId,
+		value.Value,
+		value.Position,
+	)
+	return err
+}
+
+func OptionValueExists(optionTypeId int64, value string) (bool, error) {
+	var exists bool
+
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM option_values WHERE option_type_id = $1 AND value = $2)`, optionTypeId, value).Scan(&exists)
+	return exists, err
+}
+
+// SkuTaken reports whether a variant other than exceptId uses sku.
+func SkuTaken(sku string, exceptId int64) (bool, error) {
+	var taken bool
+
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM variants WHERE sku = $1 AND id <> $2)`, sku, exceptId).Scan(&taken)
+	return taken, err
+}
+
+// CreateVariant adds a variant to the item. body.Options has to name one
+// value of every option type of the item, in a combination no other variant
+// of the item has.
+func CreateVariant(itemId int64, variant models.Variant, body models.VariantBody) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = lockItem(tx, itemId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	valueIds, name, err := resolveVariantOptions(tx, itemId, int64(variant.Id), body.Options)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query := `
+	INSERT INTO variants (id, item_id, sku, name, price, is_default, active, position, created_at)
+	VALUES ($1, $2, $3, $4, $5, FALSE, $6, $7, $8)
+	`
+
+	_, err = tx.Exec(query, variant.Id, itemId, body.Sku, name, body.Price, variant.Active, body.Position, variant.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = insertVariantOptions(tx, int64(variant.Id), valueIds); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateVariant changes the variant's SKU, price, position and whether it is
+// still sold. Options can be given once, to a variant that has none yet,
+// such as the item's default variant when option types are introduced.
+func UpdateVariant(itemId, variantId int64, body models.VariantBody) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = lockItem(tx, itemId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var hasOptions bool
+
+	err = tx.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM variant_option_values WHERE variant_id = v.id) FROM variants v WHERE v.id = $1 AND v.item_id = $2`,
+		variantId,
+		itemId,
+	).Scan(&hasOptions)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return ErrVariantNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if len(body.Options) > 0 {
+		if hasOptions {
+			tx.Rollback()
+			return ErrVariantOptionsFixed
+		}
+
+		valueIds, name, err := resolveVariantOptions(tx, itemId, variantId, body.Options)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = insertVariantOptions(tx, variantId, valueIds); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err = tx.Exec(`UPDATE variants SET name = $2 WHERE id = $1`, variantId, name); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		`UPDATE variants SET sku = $2, price = $3, active = COALESCE($4, active), position = $5 WHERE id = $1`,
+		variantId,
+		body.Sku,
+		body.Price,
+		body.Active,
+		body.Position,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockItem locks the item so changes to its variants and images are made
+// one at a time, which keeps checks such as the duplicate one in
+// resolveVariantOptions valid until tx ends.
+func lockItem(tx *sql.Tx, itemId int64) error {
+	var id int64
+
+	err := tx.QueryRow(`SELECT id FROM items WHERE id = $1 FOR UPDATE`, itemId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrItemNotFound
+	}
+	return err
+}
+
+// resolveVariantOptions maps the option names and values to option value
+// IDs and builds the variant's name from them, such as "M / Red".
+func resolveVariantOptions(tx *sql.Tx, itemId, variantId int64, options map[string]string) ([]int64, string, error) {
+	query := `
+	SELECT ot.name, ov.id, ov.value
+	FROM option_types ot
+	JOIN option_values ov ON ov.option_type_id = ot.id
+	WHERE ot.item_id = $1
+	ORDER BY ot.position, ot.id
+	`
+
+	rows, err := tx.Query(query, itemId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	types := []string{}
+	values := make(map[string]map[string]int64)
+
+	for rows.Next() {
+		var (
+			typeName, value string
+			valueId         int64
+		)
+
+		if err = rows.Scan(&typeName, &valueId, &value); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+
+		if _, exists := values[typeName]; !exists {
+			types = append(types, typeName)
+			values[typeName] = make(map[string]int64)
+		}
+		values[typeName][value] = valueId
+	}
+	rows.Close()
+
+	if len(options) != len(types) {
+		return nil, "", ErrInvalidVariantOptions
+	}
+
+	valueIds := []int64{}
+	names := []string{}
+
+	for _, typeName := range types {
+		valueId, exists := values[typeName][options[typeName]]
+		if !exists {
+			return nil, "", fmt.Errorf("%w: no %s %q", ErrInvalidVariantOptions, typeName, options[typeName])
+		}
+
+		valueIds = append(valueIds, valueId)
+		names = append(names, options[typeName])
+	}
+
+	slices.Sort(valueIds)
+
+	var duplicate bool
+
+	err = tx.QueryRow(`
+	SELECT EXISTS (
+		SELECT 1 FROM variants v
+		WHERE v.item_id = $1 AND v.id <> $2 AND (
+			SELECT COALESCE(array_agg(vov.option_value_id ORDER BY vov.option_value_id), '{}'::bigint[])
+			FROM variant_option_values vov
+			WHERE vov.variant_id = v.id
+		) = $3::bigint[]
+	)
+	`, itemId, variantId, pq.Array(valueIds)).Scan(&duplicate)
+	if err != nil {
+		return nil, "", err
+	} else if duplicate {
+		return nil, "", ErrDuplicateVariant
+	}
+
+	return valueIds, strings.Join(names, " / "), nil
+}
+
+func insertVariantOptions(tx *sql.Tx, variantId int64, valueIds []int64) error {
+	for _, valueId := range valueIds {
+		_, err := tx.Exec(`INSERT INTO variant_option_values (variant_id, option_value_id) VALUES ($1, $2)`, variantId, valueId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetVariantImages shows the given images of the item for the variant,
+// replacing the ones it had. Images not picked by any variant are shown for
+// the item as a whole.
+func SetVariantImages(itemId, variantId int64, imageIds []int) error {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	err = tx.QueryRow(`SELECT id FROM variants WHERE id = $1 AND item_id = $2 FOR UPDATE`, variantId, itemId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return ErrVariantNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec(`UPDATE items_images SET variant_id = NULL WHERE variant_id = $1`, variantId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	unique := make(map[int]bool)
+	for _, imageId := range imageIds {
+		unique[imageId] = true
+	}
+
+	res, err := tx.Exec(
+		`UPDATE items_images SET variant_id = $1 WHERE item_id = $2 AND id = ANY($3)`,
+		variantId,
+		itemId,
+		pq.Array(imageIds),
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if count, err := res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return err
+	} else if int(count) != len(unique) {
+		tx.Rollback()
+		return ErrUnknownImage
+	}
+
+	return tx.Commit()
+}
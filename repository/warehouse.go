@@ -0,0 +1,310 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"golang-final-project/config"
+	"golang-final-project/models"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrWarehouseNotFound = errors.New("warehouse doesn't exist")
+	ErrWarehouseInactive = errors.New("warehouse is not active")
+	ErrWarehouseDefault  = errors.New("the default warehouse cannot be deactivated")
+)
+
+// resolveWarehouse returns id when that warehouse exists, or the default
+// warehouse when id is nil.
+func resolveWarehouse(tx *sql.Tx, id *int) (int, error) {
+	var (
+		warehouseId int
+		err         error
+	)
+
+	if id == nil {
+		err = tx.QueryRow(`SELECT id FROM warehouses WHERE is_default`).Scan(&warehouseId)
+	} else {
+		err = tx.QueryRow(`SELECT id FROM warehouses WHERE id = $1`, *id).Scan(&warehouseId)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrWarehouseNotFound
+	}
+	return warehouseId, err
+}
+
+func GetWarehouses() ([]models.Warehouse, error) {
+	return queryWarehouses("")
+}
+
+func GetWarehouseById(id int64) (*models.Warehouse, error) {
+	warehouses, err := queryWarehouses("WHERE id = $1", id)
+
+	if err != nil {
+		return nil, err
+	} else if len(warehouses) == 0 {
+		return nil, sql.ErrNoRows
+	} else {
+		return &warehouses[0], nil
+	}
+}
+
+func queryWarehouses(condition string, args ...interface{}) ([]models.Warehouse, error) {
+	results := []models.Warehouse{}
+
+	query := `
+	SELECT id, code, name, latitude, longitude, priority, is_default, active, created_at
+	FROM warehouses
+	` + condition + `
+	ORDER BY priority, id
+	`
+
+	rows, err := config.Db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			warehouse           models.Warehouse
+			latitude, longitude sql.NullFloat64
+		)
+
+		err := rows.Scan(
+			&warehouse.Id, &warehouse.Code, &warehouse.Name, &latitude, &longitude,
+			&warehouse.Priority, &warehouse.IsDefault, &warehouse.Active, &warehouse.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if latitude.Valid && longitude.Valid {
+			warehouse.Location = &models.GeoPoint{Latitude: latitude.Float64, Longitude: longitude.Float64}
+		}
+
+		results = append(results, warehouse)
+	}
+
+	return results, rows.Err()
+}
+
+func WarehouseExists(code string) (bool, error) {
+	var exists bool
+
+	err := config.Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM warehouses WHERE code = $1)`, code).Scan(&exists)
+	return exists, err
+}
+
+func CreateWarehouse(warehouse models.Warehouse) error {
+	latitude, longitude := geoColumns(warehouse.Location)
+
+	query := `
+	INSERT INTO warehouses (id, code, name, latitude, longitude, priority, is_default, active, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, FALSE, $7, $8)
+	`
+
+	_, err := config.Db.Exec(
+		query,
+		warehouse.Id,
+		warehouse.Code,
+		warehouse.Name,
+		latitude,
+		longitude,
+		warehouse.Priority,
+		warehouse.Active,
+		warehouse.CreatedAt,
+	)
+	return err
+}
+
+// UpdateWarehouse changes everything but the code, which other systems use
+// to refer to the warehouse. Deactivating a warehouse keeps its stock but
+// stops new orders from being allocated to it.
+func UpdateWarehouse(id int64, body models.WarehouseBody) error {
+	var isDefault bool
+
+	err := config.Db.QueryRow(`SELECT is_default FROM warehouses WHERE id = $1`, id).Scan(&isDefault)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrWarehouseNotFound
+	} else if err != nil {
+		return err
+	} else if isDefault && body.Active != nil && !*body.Active {
+		return ErrWarehouseDefault
+	}
+
+	latitude, longitude := geoColumns(body.Location)
+
+	query := `
+	UPDATE warehouses
+	SET name = $2, latitude = $3, longitude = $4, priority = $5, active = COALESCE($6, active)
+	WHERE id = $1
+	`
+
+	_, err = config.Db.Exec(query, id, body.Name, latitude, longitude, body.Priority, body.Active)
+	return err
+}
+
+func geoColumns(point *models.GeoPoint) (sql.NullFloat64, sql.NullFloat64) {
+	if point == nil {
+		return sql.NullFloat64{}, sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: point.Latitude, Valid: true}, sql.NullFloat64{Float64: point.Longitude, Valid: true}
+}
+
+// TransferStock moves unreserved stock of an item between two warehouses and
+// records it as a pair of transfer movements. The item's total stock doesn't
+// change.
+func TransferStock(body models.StockTransferBody, actorId *int) ([]models.StockMovement, error) {
+	tx, err := config.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var active bool
+
+	err = tx.QueryRow(`SELECT active FROM warehouses WHERE id = $1`, body.ToWarehouseId).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return nil, ErrWarehouseNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if !active {
+		tx.Rollback()
+		return nil, ErrWarehouseInactive
+	}
+
+	if _, err = resolveWarehouse(tx, &body.FromWarehouseId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	variantId, err := stockVariant(tx, body.ItemId, body.VariantId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var stock int
+
+	if err = tx.QueryRow(`SELECT stock FROM items WHERE id = $1`, body.ItemId).Scan(&stock); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Both rows are locked in warehouse order so opposite transfers can't
+	// deadlock.
+	_, err = tx.Exec(
+		`SELECT 1 FROM warehouse_stock WHERE variant_id = $1 AND warehouse_id = ANY($2) ORDER BY warehouse_id FOR UPDATE`,
+		variantId,
+		pq.Array([]int{body.FromWarehouseId, body.ToWarehouseId}),
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	res, err := tx.Exec(
+		`UPDATE warehouse_stock SET stock = stock - $3 WHERE warehouse_id = $1 AND variant_id = $2 AND stock - reserved_stock >= $3`,
+		body.FromWarehouseId,
+		variantId,
+		body.Quantity,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if count, err := res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return nil, err
+	} else if count == 0 {
+		tx.Rollback()
+		return nil, ErrInsufficientStock
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO warehouse_stock (warehouse_id, item_id, variant_id, stock) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (warehouse_id, variant_id) DO UPDATE SET stock = warehouse_stock.stock + EXCLUDED.stock`,
+		body.ToWarehouseId,
+		body.ItemId,
+		variantId,
+		body.Quantity,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	now := time.Now()
+	reference := strconv.Itoa(body.FromWarehouseId) + "->" + strconv.Itoa(body.ToWarehouseId)
+
+	movements := []models.StockMovement{
+		{WarehouseId: &body.FromWarehouseId, Delta: -body.Quantity},
+		{WarehouseId: &body.ToWarehouseId, Delta: body.Quantity},
+	}
+
+	for i := range movements {
+		movements[i].ItemId = body.ItemId
+		movements[i].VariantId = &variantId
+		movements[i].Kind = models.StockMovementTransfer
+		movements[i].StockAfter = stock
+		movements[i].Reason = body.Reason
+		movements[i].ActorId = actorId
+		movements[i].ReferenceId = reference
+		movements[i].CreatedAt = now
+
+		if err = recordStockMovement(tx, &movements[i]); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return movements, nil
+}
+
+// GetItemLocations returns the stock of each of the items per warehouse,
+// summed over their variants.
+func GetItemLocations(itemIds []int) (map[int][]models.ItemLocation, error) {
+	query := `
+	SELECT ws.item_id, ws.warehouse_id, w.code, SUM(ws.stock), SUM(ws.reserved_stock)
+	FROM warehouse_stock ws
+	JOIN warehouses w ON w.id = ws.warehouse_id
+	WHERE ws.item_id = ANY($1)
+	GROUP BY ws.item_id, ws.warehouse_id, w.code, w.priority, w.id
+	ORDER BY ws.item_id, w.priority, w.id
+	`
+
+	rows, err := config.Db.Query(query, pq.Array(itemIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locations := make(map[int][]models.ItemLocation)
+
+	for rows.Next() {
+		var (
+			itemId   int
+			location models.ItemLocation
+		)
+
+		if err := rows.Scan(&itemId, &location.WarehouseId, &location.WarehouseCode, &location.Stock, &location.Reserved); err != nil {
+			return nil, err
+		}
+
+		location.Available = location.Stock - location.Reserved
+		locations[itemId] = append(locations[itemId], location)
+	}
+
+	return locations, rows.Err()
+}
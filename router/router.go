@@ -1,7 +1,15 @@
 package router
 
 import (
+	"fmt"
 	"golang-final-project/controllers"
+	"golang-final-project/middleware"
+	"golang-final-project/models"
+	"golang-final-project/payment"
+	"golang-final-project/storage"
+	"net/http"
+	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,26 +17,121 @@ import (
 func StartServer() *gin.Engine {
 	router := gin.Default()
 
-	router.Static("/uploads", "./uploads")
+	// ClientIP scopes anonymous idempotency keys, so forwarded addresses are
+	// only believed from the proxies listed in TRUSTED_PROXIES
+	if err := router.SetTrustedProxies(trustedProxies()); err != nil {
+		panic(err)
+	}
 
-	router.POST("/api/register", controllers.Register)
+	uploads := gin.WrapH(http.StripPrefix("/uploads", storage.Handler()))
+	router.GET("/uploads/*key", uploads)
+	router.HEAD("/uploads/*key", uploads)
+
+	router.GET("/.well-known/jwks.json", controllers.GetJWKS)
+
+	// Login and token refresh are left out, replaying them would keep issued
+	// tokens in the database and skip refresh token reuse detection. Webhooks
+	// are left out too, providers' events carry their own IDs that
+	// ReceivePaymentWebhook deduplicates on
+	public := router.Group("/api", middleware.Idempotency())
+	public.POST("/register", controllers.Register)
+	public.POST("/invitations/:token/accept", controllers.AcceptInvitation)
 	router.POST("/api/login", controllers.Login)
+	router.POST("/api/token/refresh", controllers.RefreshToken)
+
+	// Without a usable secret anyone could forge the provider's events
+	if len(payment.WebhookProviders()) > 0 {
+		router.POST("/api/webhooks/payments/:provider", controllers.ReceivePaymentWebhook)
+	} else {
+		fmt.Println("Payment webhooks are disabled, set PAYMENT_WEBHOOK_SECRET_<PROVIDER> to at least 32 random bytes")
+	}
+
+	api := router.Group("/api", middleware.Authenticate(), middleware.Idempotency())
+	can := middleware.RequirePermission
+
+	api.POST("/logout", controllers.Logout)
+	api.POST("/logout/all", controllers.LogoutAll)
+	api.DELETE("/users/:id/sessions", can(models.PermissionSessionsRevoke), controllers.RevokeUserSessions)
 
-	router.POST("/api/items", controllers.PostItem)
-	router.GET("/api/items", controllers.GetItems)
-	router.GET("/api/items/:id", controllers.GetItemById)
-	router.PUT("/api/items/:id", controllers.UpdateItem)
-	router.DELETE("/api/items/:id", controllers.DeleteItem)
+	api.GET("/permissions", can(models.PermissionRolesManage), controllers.GetPermissions)
+	api.GET("/roles", can(models.PermissionRolesManage), controllers.GetRoles)
+	api.POST("/roles", can(models.PermissionRolesManage), controllers.PostRole)
+	api.PUT("/roles/:id/permissions", can(models.PermissionRolesManage), controllers.UpdateRolePermissions)
+	api.DELETE("/roles/:id", can(models.PermissionRolesManage), controllers.DeleteRole)
+	api.PUT("/users/:id/role", can(models.PermissionRolesManage), controllers.UpdateUserRole)
+	api.POST("/admin/invitations", can(models.PermissionUsersInvite), controllers.PostInvitation)
 
-	router.POST("/api/carts", controllers.PostCart)
-	router.GET("/api/carts", controllers.GetCarts)
-	router.GET("/api/carts/:id", controllers.GetCartById)
-	router.GET("/api/carts/:id/users", controllers.GetCartsByUserId)
-	// router.PUT("/api/carts/:id", controllers.UpdateCart)
-	// router.DELETE("/api/carts/:id/cart_items", controllers.DeleteCartItems)
-	router.DELETE("/api/carts/:id", controllers.DeleteCart)
+	api.POST("/items", can(models.PermissionItemsWrite), controllers.PostItem)
+	api.GET("/items", can(models.PermissionItemsRead), controllers.GetItems)
+	api.GET("/items/search", can(models.PermissionItemsRead), controllers.SearchItems)
+	api.GET("/items/:id", can(models.PermissionItemsRead), controllers.GetItemById)
+	api.PUT("/items/:id", can(models.PermissionItemsWrite), controllers.UpdateItem)
+	api.DELETE("/items/:id", can(models.PermissionItemsWrite), controllers.DeleteItem)
+	api.GET("/items/:id/stock-movements", can(models.PermissionInventoryManage), controllers.GetStockMovements)
+	api.POST("/items/:id/stock-adjustments", can(models.PermissionInventoryManage), controllers.PostStockAdjustment)
+	api.PUT("/items/:id/category", can(models.PermissionItemsWrite), controllers.UpdateItemCategory)
+	api.PUT("/items/:id/tags", can(models.PermissionItemsWrite), controllers.UpdateItemTags)
+	api.GET("/items/:id/images", can(models.PermissionItemsRead), controllers.GetItemImages)
+	api.POST("/items/:id/images", can(models.PermissionItemsWrite), controllers.PostItemImages)
+	api.PUT("/items/:id/images", can(models.PermissionItemsWrite), controllers.ReorderItemImages)
+	api.DELETE("/items/:id/images/:image_id", can(models.PermissionItemsWrite), controllers.DeleteItemImage)
+	api.PUT("/items/:id/images/:image_id/primary", can(models.PermissionItemsWrite), controllers.SetPrimaryItemImage)
+	api.GET("/items/:id/variants", can(models.PermissionItemsRead), controllers.GetItemVariants)
+	api.POST("/items/:id/variants", can(models.PermissionItemsWrite), controllers.PostVariant)
+	api.PUT("/items/:id/variants/:variant_id", can(models.PermissionItemsWrite), controllers.UpdateVariant)
+	api.PUT("/items/:id/variants/:variant_id/images", can(models.PermissionItemsWrite), controllers.UpdateVariantImages)
+	api.POST("/items/:id/options", can(models.PermissionItemsWrite), controllers.PostOptionType)
+	api.POST("/items/:id/options/:option_id/values", can(models.PermissionItemsWrite), controllers.PostOptionValue)
 
-	router.PUT("/api/pay/:cart_id", controllers.PayCart)
+	api.GET("/categories", can(models.PermissionItemsRead), controllers.GetCategories)
+	api.POST("/categories", can(models.PermissionCatalogManage), controllers.PostCategory)
+	api.PUT("/categories/:id", can(models.PermissionCatalogManage), controllers.UpdateCategory)
+	api.DELETE("/categories/:id", can(models.PermissionCatalogManage), controllers.DeleteCategory)
+	api.GET("/tags", can(models.PermissionItemsRead), controllers.GetTags)
+	api.POST("/tags", can(models.PermissionCatalogManage), controllers.PostTag)
+	api.PUT("/tags/:id", can(models.PermissionCatalogManage), controllers.UpdateTag)
+	api.DELETE("/tags/:id", can(models.PermissionCatalogManage), controllers.DeleteTag)
+
+	api.GET("/warehouses", can(models.PermissionInventoryManage), controllers.GetWarehouses)
+	api.POST("/warehouses", can(models.PermissionInventoryManage), controllers.PostWarehouse)
+	api.PUT("/warehouses/:id", can(models.PermissionInventoryManage), controllers.UpdateWarehouse)
+	api.POST("/warehouses/transfers", can(models.PermissionInventoryManage), controllers.PostStockTransfer)
+
+	api.GET("/cart", can(models.PermissionCartsRead), controllers.GetActiveCart)
+	api.POST("/carts", can(models.PermissionCartsWrite), controllers.PostCart)
+	api.GET("/carts", can(models.PermissionCartsReadAny), controllers.GetCarts)
+	api.GET("/carts/:id", can(models.PermissionCartsRead), controllers.GetCartById)
+	api.GET("/carts/:id/users", can(models.PermissionCartsRead), controllers.GetCartsByUserId)
+	api.POST("/carts/:id/items", can(models.PermissionCartsWrite), controllers.AddCartItem)
+	api.PATCH("/carts/:id/items/:item_id", can(models.PermissionCartsWrite), controllers.UpdateCartItem)
+	api.DELETE("/carts/:id/items/:item_id", can(models.PermissionCartsWrite), controllers.DeleteCartItem)
+	api.DELETE("/carts/:id", can(models.PermissionCartsWrite), controllers.DeleteCart)
+	api.POST("/carts/:id/checkout", can(models.PermissionCartsWrite), controllers.Checkout)
+
+	api.GET("/orders", can(models.PermissionOrdersRead), controllers.GetOrders)
+	api.GET("/orders/:id", can(models.PermissionOrdersRead), controllers.GetOrderById)
+	api.GET("/orders/:id/history", can(models.PermissionOrdersRead), controllers.GetOrderHistory)
+	api.GET("/users/:id/orders", can(models.PermissionOrdersRead), controllers.GetOrdersByUserId)
+	api.PUT("/orders/:id/pay", can(models.PermissionOrdersPay), controllers.PayOrder)
+	api.POST("/orders/:id/payments/:payment_id/confirm", can(models.PermissionOrdersPay), controllers.ConfirmPayment)
+	api.GET("/orders/:id/payments", can(models.PermissionOrdersRead), controllers.GetOrderPayments)
+	api.POST("/orders/:id/cancel", can(models.PermissionOrdersCancel), controllers.CancelOrder)
+	api.POST("/orders/:id/refunds", can(models.PermissionOrdersRefund), controllers.RefundOrder)
+	api.GET("/orders/:id/refunds", can(models.PermissionOrdersRead), controllers.GetOrderRefunds)
+	api.GET("/admin/payment-events", can(models.PermissionOrdersReadAny), controllers.GetPaymentEvents)
+	api.PUT("/orders/:id/status", can(models.PermissionOrdersFulfil), controllers.UpdateOrderStatus)
 
 	return router
 }
+
+// trustedProxies reads the comma separated addresses or CIDRs of
+// TRUSTED_PROXIES, none by default.
+func trustedProxies() []string {
+	proxies := []string{}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
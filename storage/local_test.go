@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "uploads")
+	store := NewLocalStore(dir, "http://shop.test/uploads", nil, false)
+
+	if blobs, err := store.List(""); err != nil || len(blobs) != 0 {
+		t.Fatalf("List of a missing directory = %v, %v, want nothing", blobs, err)
+	}
+
+	if err := store.Put("items/1/2/original.jpg", strings.NewReader("jpeg"), "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put("legacy.png", strings.NewReader("png!"), "image/png"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	reader, err := store.Open("items/1/2/original.jpg")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "jpeg" {
+		t.Errorf("Open returned %q", data)
+	}
+
+	blobs, err := store.List("items/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(blobs) != 1 || blobs[0].Key != "items/1/2/original.jpg" || blobs[0].Size != 4 || blobs[0].ModifiedAt.IsZero() {
+		t.Errorf("List(items/) = %+v", blobs)
+	}
+
+	if blobs, _ := store.List(""); len(blobs) != 2 {
+		t.Errorf("List() returned %d blobs, want 2", len(blobs))
+	}
+
+	if err := store.Delete("items/1/2/original.jpg"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "items")); !os.IsNotExist(err) {
+		t.Errorf("Delete left the emptied directories behind")
+	}
+	if _, err := store.Open("items/1/2/original.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete("items/1/2/original.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete of a missing blob = %v, want ErrNotFound", err)
+	}
+
+	for _, key := range []string{"", "../outside", "a//b", "a/./b", "/abs"} {
+		if err := store.Put(key, strings.NewReader(""), ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestLocalStoreSignedURLs(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalStore(dir, "http://shop.test/uploads/", []byte("signing-key"), true)
+
+	if err := store.Put("items/1/photo.jpg", strings.NewReader("jpeg"), "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put("items/1/other.jpg", strings.NewReader("jpeg"), "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	signed, err := url.Parse(store.SignedURL("items/1/photo.jpg", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := signed.Query()
+
+	expired := url.Values{}
+	expired.Set("expires", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	expired.Set("signature", store.signature("items/1/photo.jpg", expired.Get("expires")))
+
+	tampered := url.Values{}
+	tampered.Set("expires", query.Get("expires"))
+	tampered.Set("signature", strings.Repeat("0", len(query.Get("signature"))))
+
+	extended := url.Values{}
+	extended.Set("expires", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	extended.Set("signature", query.Get("signature"))
+
+	tests := []struct {
+		name   string
+		path   string
+		query  url.Values
+		status int
+	}{
+		{"signed", "/items/1/photo.jpg", query, http.StatusOK},
+		{"unsigned", "/items/1/photo.jpg", url.Values{}, http.StatusForbidden},
+		{"expired", "/items/1/photo.jpg", expired, http.StatusForbidden},
+		{"tampered signature", "/items/1/photo.jpg", tampered, http.StatusForbidden},
+		{"tampered expiry", "/items/1/photo.jpg", extended, http.StatusForbidden},
+		{"other key", "/items/1/other.jpg", query, http.StatusForbidden},
+		{"invalid key", "/../secret", query, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://shop.test"+test.path+"?"+test.query.Encode(), nil)
+			req.URL.Path = test.path
+			rec := httptest.NewRecorder()
+
+			store.ServeHTTP(rec, req)
+
+			if rec.Code != test.status {
+				t.Errorf("status = %d, want %d", rec.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestLocalStorePublicURLs(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "http://shop.test/uploads", nil, false)
+
+	if err := store.Put("items/1/photo one.jpg", strings.NewReader("jpeg"), "image/jpeg"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if got, want := store.URL("items/1/photo one.jpg"), "http://shop.test/uploads/items/1/photo%20one.jpg"; got != want {
+		t.Errorf("URL = %s, want %s", got, want)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/items/1/photo%20one.jpg", nil)
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "jpeg" {
+		t.Errorf("public blob served with %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLoadRejectsWeakSigningKeys(t *testing.T) {
+	defer func(store BlobStore, wasPrivate bool) {
+		current, private = store, wasPrivate
+	}(current, private)
+
+	t.Setenv("STORAGE_BACKEND", BackendLocal)
+	t.Setenv("STORAGE_LOCAL_DIR", t.TempDir())
+
+	cases := []struct {
+		private string
+		key     string
+		valid   bool
+	}{
+		{"true", "", false},
+		{"true", "too-short", false},
+		{"true", "change-me-to-a-long-random-signing-key", false},
+		{"true", strings.Repeat("k", 32), true},
+		{"false", "", true},
+		{"false", "too-short", true},
+	}
+
+	for _, c := range cases {
+		t.Setenv("STORAGE_PRIVATE", c.private)
+		t.Setenv("STORAGE_SIGNING_KEY", c.key)
+
+		if err := Load(); (err == nil) != c.valid {
+			t.Errorf("Load with STORAGE_PRIVATE=%s STORAGE_SIGNING_KEY=%q = %v, want valid %t", c.private, c.key, err, c.valid)
+		}
+	}
+}
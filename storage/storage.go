@@ -0,0 +1,174 @@
+// Package storage keeps uploaded files. Each backend is a BlobStore; the one
+// used is picked with STORAGE_BACKEND when Load runs:
+//
+//	local  files under STORAGE_LOCAL_DIR (uploads by default), served by the
+//	       application itself under /uploads (default)
+//	s3     objects in S3_BUCKET of an S3-compatible service, see NewS3Store
+//
+// Files are referred to by key, such as 1753641250599129000_photo.jpg, and
+// only the store turns a key into a URL. With STORAGE_PRIVATE=true every URL
+// handed out is signed and expires after STORAGE_SIGNED_URL_TTL (15 minutes
+// by default).
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"golang-final-project/config"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound       = errors.New("blob doesn't exist")
+	ErrInvalidKey     = errors.New("invalid blob key")
+	ErrUnknownBackend = errors.New("unknown storage backend")
+)
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+const defaultSignedURLTTL = 15 * time.Minute
+
+// minSigningKeyLength is the shortest STORAGE_SIGNING_KEY accepted.
+const minSigningKeyLength = 32
+
+// placeholderSigningKeys were once committed to config/.env, they are public
+// and never accepted.
+var placeholderSigningKeys = map[string]bool{
+	"change-me-to-a-long-random-signing-key": true,
+}
+
+type BlobStore interface {
+	Put(key string, body io.Reader, contentType string) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	// URL is where the blob can be fetched by anyone.
+	URL(key string) string
+	// SignedURL is where the blob can be fetched until ttl has passed, even
+	// when the store isn't public.
+	SignedURL(key string, ttl time.Duration) string
+	// List returns every blob whose key starts with prefix, an empty prefix
+	// listing the whole store.
+	List(prefix string) ([]Blob, error)
+}
+
+// Blob describes a stored blob without its content.
+type Blob struct {
+	Key        string
+	Size       int64
+	ModifiedAt time.Time
+}
+
+var (
+	current      BlobStore
+	private      bool
+	signedURLTTL = defaultSignedURLTTL
+)
+
+// Load sets up the store picked with STORAGE_BACKEND.
+func Load() error {
+	private = os.Getenv("STORAGE_PRIVATE") == "true"
+	if ttl, err := time.ParseDuration(os.Getenv("STORAGE_SIGNED_URL_TTL")); err == nil && ttl > 0 {
+		signedURLTTL = ttl
+	}
+
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", BackendLocal:
+		signingKey := os.Getenv("STORAGE_SIGNING_KEY")
+		if private && signingKey == "" {
+			return errors.New("private local storage needs STORAGE_SIGNING_KEY")
+		} else if private && (len(signingKey) < minSigningKeyLength || placeholderSigningKeys[signingKey]) {
+			return fmt.Errorf("STORAGE_SIGNING_KEY must be at least %d random bytes", minSigningKeyLength)
+		}
+
+		current = NewLocalStore(localDir(), localPublicURL(), []byte(signingKey), private)
+	case BackendS3:
+		store, err := NewS3Store(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyId:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       os.Getenv("S3_PATH_STYLE") == "true",
+			PublicURL:       os.Getenv("STORAGE_PUBLIC_URL"),
+		})
+		if err != nil {
+			return err
+		}
+		current = store
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownBackend, backend)
+	}
+
+	return nil
+}
+
+// Default returns the store set up by Load, or a public local store when
+// Load hasn't run.
+func Default() BlobStore {
+	if current == nil {
+		current = NewLocalStore(localDir(), localPublicURL(), nil, false)
+	}
+	return current
+}
+
+// AssetURL is the URL handed out for the blob in API responses, signed when
+// the storage is private.
+func AssetURL(key string) string {
+	if private {
+		return Default().SignedURL(key, signedURLTTL)
+	}
+	return Default().URL(key)
+}
+
+// Handler serves the blobs under /uploads. Stores that can't serve files
+// themselves redirect to where the blob is kept, so links made before
+// switching backends keep working.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store := Default()
+
+		if handler, ok := store.(http.Handler); ok {
+			handler.ServeHTTP(w, r)
+		} else if key := strings.TrimPrefix(r.URL.Path, "/"); !ValidKey(key) {
+			http.NotFound(w, r)
+		} else {
+			http.Redirect(w, r, AssetURL(key), http.StatusFound)
+		}
+	})
+}
+
+// ValidKey reports whether key can name a blob: a relative, slash separated
+// path without empty, . or .. segments.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 1024 || strings.ContainsAny(key, "\\\x00") {
+		return false
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func localDir() string {
+	if dir := os.Getenv("STORAGE_LOCAL_DIR"); dir != "" {
+		return dir
+	}
+	return "uploads"
+}
+
+func localPublicURL() string {
+	if publicURL := os.Getenv("STORAGE_PUBLIC_URL"); publicURL != "" {
+		return publicURL
+	}
+	return config.BaseUrl + "uploads/"
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+	slugPattern    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+// Slugify lowercases s and joins its runs of letters and digits with dashes,
+// "Home & Garden" becomes "home-garden".
+func Slugify(s string) string {
+	return strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+func IsSlug(s string) bool {
+	return slugPattern.MatchString(s)
+}